@@ -0,0 +1,276 @@
+package memoryshare
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// BlobStore stores the content of published Files, addressed by the SHA-256 hash of the content. Content shared by
+// several Files is stored once and reference counted.
+type BlobStore interface {
+	// Put stores the contents of the file at src under its hash. If the hash is already stored, its reference count is
+	// incremented instead.
+	Put(hash string, src string) error
+	// Open opens a blob for reading. The returned reader fails with ErrHashMismatch at EOF if the content read does
+	// not match the hash.
+	Open(hash string) (io.ReadCloser, error)
+	// Serve writes a blob to a HTTP response. The name is used to determine the content type.
+	Serve(w http.ResponseWriter, r *http.Request, hash string, name string) error
+	// Exists determines whether a blob is stored.
+	Exists(hash string) (bool, error)
+	// Release decrements the reference count of a blob, deleting it once it is no longer referenced.
+	Release(hash string) error
+	// Delete removes a blob regardless of its reference count.
+	Delete(hash string) error
+	// List returns the hash of every stored blob.
+	List() ([]string, error)
+}
+
+// ErrBlobNotFound implies no blob is stored under the requested hash.
+var ErrBlobNotFound = errors.New("blob not found")
+
+// ErrHashMismatch implies content does not match the hash it is stored under.
+var ErrHashMismatch = errors.New("content does not match hash")
+
+// ErrInvalidHash implies a hash is not a hex encoded SHA-256 hash.
+var ErrInvalidHash = errors.New("invalid hash")
+
+var hashRegex = regexp.MustCompile(`^[0-9a-f]{64}$`).MatchString
+
+const (
+	// LocalContentBackend stores published content on the local file system.
+	LocalContentBackend = "local"
+	// S3ContentBackend stores published content in an S3 compatible bucket.
+	S3ContentBackend = "s3"
+)
+
+// NewBlobStore creates the BlobStore for the configured content backend.
+func NewBlobStore(dbDir string) (BlobStore, error) {
+	switch config.ContentBackend {
+	case LocalContentBackend:
+		return NewLocalBlobStore(dbDir + "/blobs")
+	case S3ContentBackend:
+		return NewS3BlobStore(config.ContentSettings)
+	}
+	return nil, ErrUnknownBackend
+}
+
+// LocalBlobStore is a BlobStore which stores blobs on the local file system, sharded into sub directories by the first
+// two bytes of the hash (i.e. ab/cd/abcd...). The reference count of each blob is kept in a sidecar .refs file.
+type LocalBlobStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewLocalBlobStore creates a LocalBlobStore rooted at dir.
+func NewLocalBlobStore(dir string) (*LocalBlobStore, error) {
+	if err := EnsureDirExists(dir); err != nil {
+		return nil, errors.Wrap(err, "failed to create blob store directory")
+	}
+	return &LocalBlobStore{dir: dir}, nil
+}
+
+// path determines the path of the blob stored under hash.
+func (s *LocalBlobStore) path(hash string) string {
+	return filepath.Join(s.dir, hash[0:2], hash[2:4], hash)
+}
+
+// refs reads the reference count of a blob.
+func (s *LocalBlobStore) refs(hash string) (int, error) {
+	data, err := ioutil.ReadFile(s.path(hash) + ".refs")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// setRefs writes the reference count of a blob.
+func (s *LocalBlobStore) setRefs(hash string, count int) error {
+	return WriteFileAtomic(s.path(hash)+".refs", 0, func(w io.Writer) error {
+		_, err := fmt.Fprint(w, count)
+		return err
+	})
+}
+
+// Put copies the file at src into the store, verifying its contents against hash.
+func (s *LocalBlobStore) Put(hash string, src string) error {
+	if !hashRegex(hash) {
+		return ErrInvalidHash
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// content already stored
+	if count, err := s.refs(hash); err == nil {
+		return s.setRefs(hash, count+1)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "failed to open src file")
+	}
+	defer in.Close()
+
+	dst := s.path(hash)
+	if err = os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return errors.Wrap(err, "failed to create blob shard directory")
+	}
+
+	// copy to blob path, verifying hash before the blob is renamed into place
+	err = WriteFileAtomic(dst, 0, func(w io.Writer) error {
+		h := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(w, h), in); err != nil {
+			return err
+		}
+		if fmt.Sprintf("%x", h.Sum(nil)) != hash {
+			return ErrHashMismatch
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to store blob")
+	}
+
+	return s.setRefs(hash, 1)
+}
+
+// Open opens a blob for reading, verifying the content against hash as it is read.
+func (s *LocalBlobStore) Open(hash string) (io.ReadCloser, error) {
+	if !hashRegex(hash) {
+		return nil, ErrInvalidHash
+	}
+
+	file, err := os.Open(s.path(hash))
+	if os.IsNotExist(err) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open blob")
+	}
+	return &verifyingReader{ReadCloser: file, hash: hash, h: sha256.New()}, nil
+}
+
+// Serve writes a blob to a HTTP response. Range requests are supported so that media can be streamed, so the content is
+// not verified as it is served.
+func (s *LocalBlobStore) Serve(w http.ResponseWriter, r *http.Request, hash string, name string) error {
+	if !hashRegex(hash) {
+		return ErrInvalidHash
+	}
+
+	file, err := os.Open(s.path(hash))
+	if os.IsNotExist(err) {
+		return ErrBlobNotFound
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to open blob")
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return errors.Wrap(err, "failed to stat blob")
+	}
+
+	http.ServeContent(w, r, name, info.ModTime(), file)
+	return nil
+}
+
+// Exists determines whether a blob is stored.
+func (s *LocalBlobStore) Exists(hash string) (bool, error) {
+	if !hashRegex(hash) {
+		return false, ErrInvalidHash
+	}
+	return FileOrDirExists(s.path(hash))
+}
+
+// Release decrements the reference count of a blob, deleting the blob once it is no longer referenced.
+func (s *LocalBlobStore) Release(hash string) error {
+	if !hashRegex(hash) {
+		return ErrInvalidHash
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count, err := s.refs(hash)
+	if os.IsNotExist(err) {
+		return ErrBlobNotFound
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to read blob reference count")
+	}
+
+	if count > 1 {
+		return s.setRefs(hash, count-1)
+	}
+	return s.remove(hash)
+}
+
+// Delete removes a blob & its reference count regardless of the number of references.
+func (s *LocalBlobStore) Delete(hash string) error {
+	if !hashRegex(hash) {
+		return ErrInvalidHash
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.remove(hash)
+}
+
+// remove deletes a blob & its reference count. The caller must hold the lock.
+func (s *LocalBlobStore) remove(hash string) error {
+	if err := os.Remove(s.path(hash)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to delete blob")
+	}
+	if err := os.Remove(s.path(hash) + ".refs"); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to delete blob reference count")
+	}
+	return nil
+}
+
+// List walks the shard directories, returning the hash of every stored blob.
+func (s *LocalBlobStore) List() (hashes []string, err error) {
+	err = filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && hashRegex(info.Name()) {
+			hashes = append(hashes, info.Name())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list blobs")
+	}
+	return hashes, nil
+}
+
+// verifyingReader hashes content as it is read and fails at EOF if it does not match the expected hash.
+type verifyingReader struct {
+	io.ReadCloser
+	hash string
+	h    hash.Hash
+}
+
+// Read reads from the underlying reader, checking the hash once EOF is reached.
+func (v *verifyingReader) Read(p []byte) (n int, err error) {
+	n, err = v.ReadCloser.Read(p)
+	v.h.Write(p[:n])
+	if err == io.EOF && fmt.Sprintf("%x", v.h.Sum(nil)) != v.hash {
+		return n, ErrHashMismatch
+	}
+	return
+}
@@ -0,0 +1,142 @@
+package memoryshare
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestLocalBlobStore(t *testing.T) {
+	dir := newTestConfig(t, GobBackend)
+	s, err := NewLocalBlobStore(dir + "/db/blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, hash := writeTestBlob(t, dir, "hello")
+	if err := s.Put(hash, path); err != nil {
+		t.Fatal(err)
+	}
+
+	// blobs are sharded by the first two bytes of their hash
+	expectedPath := filepath.Join(dir, "db", "blobs", hash[0:2], hash[2:4], hash)
+	if exists, _ := FileOrDirExists(expectedPath); !exists {
+		t.Fatalf("expected the blob to be stored at %v", expectedPath)
+	}
+
+	r, err := s.Open(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || string(content) != "hello" {
+		t.Fatalf("expected to read the blob content, got %q (%v)", content, err)
+	}
+
+	if err := s.Put(strings.Repeat("0", 64), path); errors.Cause(err) != ErrHashMismatch {
+		t.Fatalf("expected %v, got %v", ErrHashMismatch, err)
+	}
+	if err := s.Put("not a hash", path); err != ErrInvalidHash {
+		t.Fatalf("expected %v, got %v", ErrInvalidHash, err)
+	}
+	if _, err := s.Open(strings.Repeat("1", 64)); err != ErrBlobNotFound {
+		t.Fatalf("expected %v, got %v", ErrBlobNotFound, err)
+	}
+	if hashes, err := s.List(); err != nil || strings.Join(hashes, ",") != hash {
+		t.Fatalf("expected only the stored blob to be listed, got %v (%v)", hashes, err)
+	}
+}
+
+func TestLocalBlobStoreReferenceCounting(t *testing.T) {
+	dir := newTestConfig(t, GobBackend)
+	s, err := NewLocalBlobStore(dir + "/db/blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// identical content is stored once
+	path, hash := writeTestBlob(t, dir, "shared")
+	for i := 0; i < 3; i++ {
+		if err := s.Put(hash, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count, err := s.refs(hash); err != nil || count != 3 {
+		t.Fatalf("expected 3 references, got %v (%v)", count, err)
+	}
+
+	// reference counts survive the store being reopened
+	if s, err = NewLocalBlobStore(dir + "/db/blobs"); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := s.refs(hash); err != nil || count != 3 {
+		t.Fatalf("expected 3 references after reopening, got %v (%v)", count, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := s.Release(hash); err != nil {
+			t.Fatal(err)
+		}
+		if exists, _ := s.Exists(hash); !exists {
+			t.Fatalf("expected the blob to exist while referenced, after %v releases", i+1)
+		}
+	}
+	if err := s.Release(hash); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := s.Exists(hash); exists {
+		t.Fatal("expected the blob to be deleted once no longer referenced")
+	}
+	if exists, _ := FileOrDirExists(s.path(hash) + ".refs"); exists {
+		t.Fatal("expected the reference count to be deleted with the blob")
+	}
+	if err := s.Release(hash); err != ErrBlobNotFound {
+		t.Fatalf("expected %v, got %v", ErrBlobNotFound, err)
+	}
+
+	// Delete ignores the reference count
+	if err := s.Put(hash, path); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(hash, path); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(hash); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := s.Exists(hash); exists {
+		t.Fatal("expected the blob to be deleted")
+	}
+}
+
+func TestLocalBlobStoreServe(t *testing.T) {
+	dir := newTestConfig(t, GobBackend)
+	s, err := NewLocalBlobStore(dir + "/db/blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, hash := writeTestBlob(t, dir, "hello world")
+	if err := s.Put(hash, path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/static/content/a.txt", nil)
+	req.Header.Set("Range", "bytes=6-10")
+	rec := httptest.NewRecorder()
+	if err := s.Serve(rec, req, hash, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "world" {
+		t.Fatalf("expected a partial response, got %v %q", rec.Code, rec.Body.String())
+	}
+}
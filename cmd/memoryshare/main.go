@@ -2,6 +2,8 @@
 package main
 
 import (
+	"flag"
+	"strings"
 	"time"
 	"github.com/jemgunay/memoryshare"
 	"github.com/jemgunay/logger"
@@ -17,6 +19,12 @@ func main() {
 		return
 	}
 
+	// run pending migrations without launching the service, e.g. "memoryshare migrate --dry-run"
+	if flag.Arg(0) == "migrate" {
+		migrate(config, flag.Args()[1:])
+		return
+	}
+
 	// launch servers
 	server, err := memoryshare.NewServer(config)
 	if err != nil {
@@ -34,11 +42,22 @@ func main() {
 				memoryshare.Critical.Log(err)
 			}
 
-			switch input {
+			args := strings.Fields(input)
+			if len(args) == 0 {
+				continue
+			}
+
+			switch args[0] {
 			// terminate service
 			case "exit":
 				server.Stop()
 				return
+			// restore published memories to a point in time
+			case "restore":
+				restore(server, args[1:])
+			// check the integrity of stored memories
+			case "fsck":
+				fsck(server, args[1:])
 			default:
 				memoryshare.Info.Log("Unsupported command.")
 			}
@@ -46,4 +65,74 @@ func main() {
 	} else {
 		<-exit
 	}
-}
\ No newline at end of file
+}
+
+// Runs pending DB migrations, logging each change. If the --dry-run flag is set, changes are reported but not stored.
+func migrate(config *memoryshare.Config, args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "report what would change without storing the changes")
+	flags.Parse(args)
+
+	changes, err := memoryshare.Migrate(config, *dryRun)
+	if err != nil {
+		memoryshare.Critical.Logf("Unable to migrate: %v", err)
+		return
+	}
+
+	if len(changes) == 0 {
+		memoryshare.Info.Logf("Already at schema version %v, no migrations to run.", memoryshare.SchemaVersion)
+		return
+	}
+	for _, change := range changes {
+		memoryshare.Info.Log(change)
+	}
+	if *dryRun {
+		memoryshare.Info.Logf("Dry run: %v changes would be made to migrate to schema version %v.", len(changes), memoryshare.SchemaVersion)
+	} else {
+		memoryshare.Info.Logf("Made %v changes to migrate to schema version %v.", len(changes), memoryshare.SchemaVersion)
+	}
+}
+
+// Restores published memories to a transaction UUID or time, logging each memory changed. Usage:
+// restore [--dry-run] <transaction UUID|unix timestamp|RFC 3339 time|YYYY-MM-DD>
+func restore(server *memoryshare.Server, args []string) {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "report what would change without changing it")
+	if err := flags.Parse(args); err != nil {
+		return
+	}
+	if flags.NArg() != 1 {
+		memoryshare.Info.Log("Usage: restore [--dry-run] <transaction UUID|unix timestamp|RFC 3339 time|YYYY-MM-DD>")
+		return
+	}
+
+	report, err := server.Restore(flags.Arg(0), "console", *dryRun)
+	if err != nil {
+		memoryshare.Critical.Logf("Unable to restore: %v", err)
+		return
+	}
+
+	for _, file := range report.Files {
+		memoryshare.Info.Logf("%v (%v): %v", file.Name, file.UUID, memoryshare.ToJSON(file.Changes, false))
+	}
+	if *dryRun {
+		memoryshare.Info.Logf("Dry run: %v memories would be restored to transaction %v.", len(report.Files), report.TransactionCount)
+	} else {
+		memoryshare.Info.Logf("Restored %v memories to transaction %v.", len(report.Files), report.TransactionCount)
+	}
+}
+
+// Checks that the FileDB, uploaded files & published content agree, logging the report. Usage:
+// fsck [--verify] [--quarantine] [--repair]
+func fsck(server *memoryshare.Server, args []string) {
+	var opts memoryshare.FsckOptions
+	flags := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	flags.BoolVar(&opts.Verify, "verify", false, "re-hash all content to detect bitrot")
+	flags.BoolVar(&opts.Quarantine, "quarantine", false, "move orphaned & corrupt content to db/quarantine")
+	flags.BoolVar(&opts.Repair, "repair", false, "re-register orphaned uploads, remove broken uploads & restore missing content")
+	if err := flags.Parse(args); err != nil {
+		return
+	}
+
+	memoryshare.Info.Log("\n", memoryshare.ToJSON(server.Fsck(opts), true))
+}
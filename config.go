@@ -7,6 +7,7 @@ import (
 
 	"github.com/BurntSushi/toml"
 	"github.com/jemgunay/logger"
+	"github.com/pkg/errors"
 )
 
 var (
@@ -34,6 +35,9 @@ type Config struct {
 	GeneralSettings `toml:"general_settings"`
 	ServerSettings  `toml:"server_settings"`
 	DebugSettings   `toml:"debug_settings"`
+	DBSettings      `toml:"db_settings"`
+	ContentSettings `toml:"content_settings"`
+	QuotaSettings   `toml:"quota_settings"`
 	FileFormats     `toml:"file_formats"`
 }
 
@@ -42,6 +46,7 @@ type GeneralSettings struct {
 	Version               string `toml:"version"`
 	ServiceName           string `toml:"service_name"`
 	EnableConsoleCommands bool   `toml:"enable_console_commands"`
+	InstanceName          string `toml:"instance_name"`
 }
 
 // ServerSettings is a container for HTTP server, mail and access settings.
@@ -60,6 +65,11 @@ type ServerSettings struct {
 	MaxDescriptionLength int  `toml:"max_description_length"`
 	MaxTagsCount         int  `toml:"max_tags_count"`
 	MaxPeopleCount       int  `toml:"max_people_count"`
+	MaxBulkPublishFiles  int  `toml:"max_bulk_publish_files"`
+
+	ResumableUploadExpiry   int `toml:"resumable_upload_expiry"`
+	UploadExpiryDays        int `toml:"upload_expiry_days"`
+	UploadExpiryWarningDays int `toml:"upload_expiry_warning_days"`
 }
 
 // DebugSettings i sa container for all debug related settings.
@@ -67,6 +77,37 @@ type DebugSettings struct {
 	CacheTemplates bool `toml:"cache_templates"`
 }
 
+// DBSettings is a container for database persistence settings.
+type DBSettings struct {
+	StorageBackend    string `toml:"storage_backend"`
+	SnapshotInterval  int    `toml:"snapshot_interval"`
+	BackupGenerations int    `toml:"backup_generations"`
+}
+
+// ContentSettings is a container for published content storage settings.
+type ContentSettings struct {
+	ContentBackend  string `toml:"content_backend"`
+	S3Endpoint      string `toml:"s3_endpoint"`
+	S3Region        string `toml:"s3_region"`
+	S3Bucket        string `toml:"s3_bucket"`
+	S3AccessKey     string `toml:"s3_access_key"`
+	S3SecretKey     string `toml:"s3_secret_key" json:"-"`
+	S3PathStyle     bool   `toml:"s3_path_style"`
+	S3Presign       bool   `toml:"s3_presign"`
+	S3PresignExpiry int    `toml:"s3_presign_expiry"`
+
+	TrashRetentionDays    int `toml:"trash_retention_days"`
+	NearDuplicateDistance int `toml:"near_duplicate_distance"`
+}
+
+// QuotaSettings is a container for the default storage quotas of each UserType. Sizes are in MB & 0 implies unlimited.
+type QuotaSettings struct {
+	StandardQuotaSize  int `toml:"standard_quota_size"`
+	StandardQuotaFiles int `toml:"standard_quota_files"`
+	AdminQuotaSize     int `toml:"admin_quota_size"`
+	AdminQuotaFiles    int `toml:"admin_quota_files"`
+}
+
 // FileFormats is a container for permitted file upload types.
 type FileFormats struct {
 	ImageFormats []string `toml:"image_formats"`
@@ -119,12 +160,54 @@ func NewConfig() (conf *Config, err error) {
 // Load service config from file.
 func (c *Config) Load() (err error) {
 	// parse TOML config file
-	if _, err = toml.DecodeFile(c.file, &c); err != nil {
+	meta, err := toml.DecodeFile(c.file, &c)
+	if err != nil {
 		return
 	}
 
 	// process config values
 	c.MaxFileUploadSize *= 1024 * 1024
+	if c.InstanceName == "" {
+		if c.InstanceName, err = os.Hostname(); err != nil {
+			return errors.Wrap(err, "failed to determine instance name from host name")
+		}
+	}
+	if c.StorageBackend == "" {
+		c.StorageBackend = GobBackend
+	}
+	if c.SnapshotInterval <= 0 {
+		c.SnapshotInterval = 500
+	}
+	if !meta.IsDefined("db_settings", "backup_generations") {
+		c.BackupGenerations = 3
+	}
+	if c.ContentBackend == "" {
+		c.ContentBackend = LocalContentBackend
+	}
+	if c.S3Region == "" {
+		c.S3Region = "us-east-1"
+	}
+	if c.S3PresignExpiry <= 0 {
+		c.S3PresignExpiry = 900
+	}
+	if c.MaxBulkPublishFiles <= 0 {
+		c.MaxBulkPublishFiles = 100
+	}
+	if !meta.IsDefined("server_settings", "resumable_upload_expiry") {
+		c.ResumableUploadExpiry = 24
+	}
+	if !meta.IsDefined("server_settings", "upload_expiry_days") {
+		c.UploadExpiryDays = 30
+	}
+	if !meta.IsDefined("server_settings", "upload_expiry_warning_days") {
+		c.UploadExpiryWarningDays = 3
+	}
+	if !meta.IsDefined("content_settings", "trash_retention_days") {
+		c.TrashRetentionDays = 30
+	}
+	if !meta.IsDefined("content_settings", "near_duplicate_distance") {
+		c.NearDuplicateDistance = 6
+	}
 	return
 }
 
@@ -0,0 +1,108 @@
+package memoryshare
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// UploadExpiryWarner warns a user that their unpublished uploads will be deleted at the given time.
+type UploadExpiryWarner func(username string, files []File, expires time.Time) error
+
+// ExpireUploads deletes the temp files & Uploaded entries of uploads which have not been published within the ttl. If
+// warning is not 0, the uploader is warned that an upload is about to expire that long before it does. An upload is
+// never deleted before the full warning period has passed since the warning was sent, so uploads are not deleted without
+// warning if the service was not running during the warning period or the warning could not be sent.
+func (db *FileDB) ExpireUploads(ttl, warning time.Duration, warn UploadExpiryWarner) (expired int, err error) {
+	now := time.Now()
+	toWarn := make(map[string][]File)
+
+	uploads := db.Uploaded.PerformFunc(func(m FileMapDB, mapName string) interface{} {
+		files := make([]File, 0, len(m))
+		for _, file := range m {
+			files = append(files, file)
+		}
+		return files
+	}).([]File)
+
+	for _, file := range uploads {
+		// the upload is being published, so has not expired
+		if db.isPublishing(file.UUID) {
+			continue
+		}
+
+		expires := time.Unix(0, file.UploadedTimestamp).Add(ttl)
+		if warning > 0 {
+			if file.WarnedTimestamp == 0 {
+				if now.After(expires.Add(-warning)) {
+					toWarn[file.UploaderUsername] = append(toWarn[file.UploaderUsername], file)
+				}
+				continue
+			}
+			if warned := time.Unix(0, file.WarnedTimestamp).Add(warning); warned.After(expires) {
+				expires = warned
+			}
+		}
+		if now.Before(expires) {
+			continue
+		}
+
+		if err = os.Remove(file.UploadPath()); err != nil && !os.IsNotExist(err) {
+			return expired, errors.Wrapf(err, "failed to remove expired upload %v", file.UUID)
+		}
+		db.Uploaded.Delete(file.UUID)
+		expired++
+	}
+
+	// warn each uploader once, marking the uploads as warned only if the warning was sent
+	for username, files := range toWarn {
+		if err := warn(username, SortFilesByDate(files), now.Add(warning)); err != nil {
+			Critical.Log(errors.Wrapf(err, "failed to warn %v of expiring uploads", username))
+			continue
+		}
+		for _, file := range files {
+			if current, ok := db.Uploaded.Get(file.UUID); ok {
+				current.WarnedTimestamp = now.UnixNano()
+				db.Uploaded.Set(file.UUID, current)
+			}
+		}
+	}
+
+	if expired > 0 || len(toWarn) > 0 {
+		db.Checkpoint()
+	}
+	return expired, nil
+}
+
+// uploadExpiryInterval is the period between checks for expired uploads.
+const uploadExpiryInterval = time.Hour
+
+// runUploadJanitor warns the uploaders of uploads which are about to expire & deletes expired uploads periodically until
+// stop is closed. Expiry is disabled if the upload expiry period is 0.
+func (db *FileDB) runUploadJanitor(stop <-chan struct{}, warn UploadExpiryWarner) {
+	if config.UploadExpiryDays <= 0 {
+		return
+	}
+	ttl := time.Duration(config.UploadExpiryDays) * 24 * time.Hour
+	warning := time.Duration(config.UploadExpiryWarningDays) * 24 * time.Hour
+
+	ticker := time.NewTicker(uploadExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		expired, err := db.ExpireUploads(ttl, warning, warn)
+		if err != nil {
+			Critical.Logf("%+v", err)
+		}
+		if expired > 0 {
+			Info.Logf("deleted %v expired unpublished uploads", expired)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package memoryshare
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestExpireUploads(t *testing.T) {
+	db, _ := newTestFileDB(t, GobBackend)
+	if err := os.MkdirAll(db.dir+"/temp/bob", 0755); err != nil {
+		t.Fatal(err)
+	}
+	day := 24 * time.Hour
+	upload := func(UUID string, age time.Duration) File {
+		file := File{UUID: UUID, Extension: "txt", Hash: "hash-" + UUID, UploaderUsername: "bob", State: Uploaded,
+			UploadedTimestamp: time.Now().Add(-age).UnixNano()}
+		if err := ioutil.WriteFile(file.UploadPath(), []byte(UUID), 0666); err != nil {
+			t.Fatal(err)
+		}
+		db.Uploaded.Set(UUID, file)
+		return file
+	}
+	var warned []string
+	warn := func(username string, files []File, expires time.Time) error {
+		for _, file := range files {
+			warned = append(warned, file.UUID)
+		}
+		return nil
+	}
+
+	expiredUpload := upload("expired", 40*day)
+	upload("expiring", 28*day)
+	upload("new", day)
+
+	// without a warning period, expired uploads are deleted immediately
+	if expired, err := db.ExpireUploads(30*day, 0, warn); expired != 1 || len(warned) != 0 || err != nil {
+		t.Fatalf("expected 1 upload to expire without warning, got %v & %v warned (%v)", expired, warned, err)
+	}
+	if exists, _ := FileOrDirExists(expiredUpload.UploadPath()); exists {
+		t.Fatal("expected the content of the expired upload to be deleted")
+	}
+
+	// uploads due to expire within the warning period are warned about first
+	upload("expired", 40*day)
+	if expired, err := db.ExpireUploads(30*day, 3*day, warn); expired != 0 || err != nil {
+		t.Fatalf("expected no uploads to expire before warning, got %v (%v)", expired, err)
+	}
+	sort.Strings(warned)
+	if strings.Join(warned, ",") != "expired,expiring" {
+		t.Fatalf("expected expired & expiring to be warned about, got %v", warned)
+	}
+	if expired, _ := db.ExpireUploads(30*day, 3*day, warn); expired != 0 || len(warned) != 2 {
+		t.Fatalf("expected a warned upload to be kept for the warning period without another warning, got %v", expired)
+	}
+
+	file, _ := db.Uploaded.Get("expired")
+	file.WarnedTimestamp = time.Now().Add(-4 * day).UnixNano()
+	db.Uploaded.Set("expired", file)
+	if expired, _ := db.ExpireUploads(30*day, 3*day, warn); expired != 1 || db.Uploaded.Count() != 2 {
+		t.Fatalf("expected the upload to expire once the warning period has passed, got %v", expired)
+	}
+
+	// an upload is not marked as warned if the warning could not be sent
+	upload("unwarned", 40*day)
+	db.ExpireUploads(30*day, 3*day, func(string, []File, time.Time) error {
+		return errors.New("failed to send email")
+	})
+	if file, _ := db.Uploaded.Get("unwarned"); file.WarnedTimestamp != 0 {
+		t.Fatal("expected the upload not to be marked as warned")
+	}
+}
+
+func TestUploadExpiryEmailBody(t *testing.T) {
+	newTestConfig(t, GobBackend)
+	config.ServiceName = "Memory Share"
+
+	user := User{Forename: "<b>Bob</b>"}
+	files := []File{{Name: `<img src="x" onerror="alert(1)">`, Extension: "txt"}}
+	body := uploadExpiryEmailBody(user, files, time.Now())
+
+	if strings.Contains(body, "<b>Bob") || strings.Contains(body, "<img") {
+		t.Fatalf("expected user controlled names to be escaped, got %v", body)
+	}
+	if !strings.Contains(body, "&lt;b&gt;Bob&lt;/b&gt;") || !strings.Contains(body, "&lt;img src=&#34;x&#34;") {
+		t.Fatalf("expected the escaped names to be included, got %v", body)
+	}
+}
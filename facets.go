@@ -0,0 +1,44 @@
+package memoryshare
+
+import (
+	"time"
+)
+
+// SearchFacets counts the memories of a search result set by each value of their tags, people, media types, uploaders
+// & dates, i.e. the number of results which refining the search by that value would return.
+type SearchFacets struct {
+	Tags       map[string]int `json:"tags"`
+	People     map[string]int `json:"people"`
+	MediaTypes map[string]int `json:"media_types"`
+	Uploaders  map[string]int `json:"uploaders"`
+	Years      map[string]int `json:"years"`  // i.e. "2017"
+	Months     map[string]int `json:"months"` // i.e. "2017-06"
+}
+
+// newSearchFacets counts the facets of every file in a search result set.
+func newSearchFacets(results []ScoredFile) SearchFacets {
+	facets := SearchFacets{
+		Tags:       make(map[string]int),
+		People:     make(map[string]int),
+		MediaTypes: make(map[string]int),
+		Uploaders:  make(map[string]int),
+		Years:      make(map[string]int),
+		Months:     make(map[string]int),
+	}
+
+	for _, result := range results {
+		for _, tag := range result.Tags {
+			facets.Tags[tag]++
+		}
+		for _, person := range result.People {
+			facets.People[person]++
+		}
+		facets.MediaTypes[result.MediaType]++
+		facets.Uploaders[result.UploaderUsername]++
+
+		date := time.Unix(0, indexDate(result.File))
+		facets.Years[date.Format("2006")]++
+		facets.Months[date.Format("2006-01")]++
+	}
+	return facets
+}
@@ -0,0 +1,47 @@
+package memoryshare
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSearchFacets(t *testing.T) {
+	db, _ := newTestFileDB(t, GobBackend)
+	publish := func(UUID, date, mediaType, uploader string, tags []string) {
+		published, err := time.ParseInLocation("2006-01-02", date, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Published.Set(UUID, File{UUID: UUID, UploaderUsername: uploader, State: Published, PublishedTimestamp: published.UnixNano(),
+			MetaData: MetaData{MediaType: mediaType, Tags: tags, People: []string{"bob"}}})
+	}
+	publish("a", "2017-06-01", Image, "bob", []string{"beach", "sun"})
+	publish("b", "2017-06-20", Video, "eve", []string{"beach"})
+	publish("c", "2018-01-01", Image, "bob", []string{"snow"})
+	publish("d", "2018-01-02", Image, "bob", []string{"beach"})
+	if err := db.DeleteFile("d", "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	// facets count every matching file, not only those on the page
+	result := db.Search(SearchRequest{tags: []string{"beach"}, resultsPerPage: 1})
+	if result.TotalCount != 2 || result.ResultCount != 1 {
+		t.Fatalf("expected a page of 1 of 2 results, got %v of %v", result.ResultCount, result.TotalCount)
+	}
+	facets := result.Facets
+	if facets.Tags["beach"] != 2 || facets.Tags["sun"] != 1 || facets.Tags["snow"] != 0 || facets.People["bob"] != 2 {
+		t.Errorf("unexpected tag & people facets: %v %v", facets.Tags, facets.People)
+	}
+	if facets.MediaTypes[Video] != 1 || facets.MediaTypes[Image] != 1 || facets.Uploaders["bob"] != 1 ||
+		facets.Uploaders["eve"] != 1 {
+		t.Errorf("unexpected media type & uploader facets: %v %v", facets.MediaTypes, facets.Uploaders)
+	}
+	if facets.Years["2017"] != 2 || facets.Months["2017-06"] != 2 || len(facets.Years) != 1 {
+		t.Errorf("unexpected date facets: %v %v", facets.Years, facets.Months)
+	}
+
+	result = db.Search(SearchRequest{})
+	if result.TotalCount != 3 || result.Facets.Years["2018"] != 1 || result.Facets.Months["2018-01"] != 1 {
+		t.Errorf("expected deleted files not to be counted, got %v results & years %v", result.TotalCount, result.Facets.Years)
+	}
+}
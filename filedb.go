@@ -1,7 +1,6 @@
 package memoryshare
 
 import (
-	"encoding/gob"
 	"fmt"
 	"io"
 	"net/http"
@@ -50,6 +49,9 @@ const (
 	// Deleted represents a published File which has been marked as deleted and which is no longer visible to users.
 	// There will be another Transaction corresponding with the deletion.
 	Deleted
+	// Purged represents a deleted File whose content has been removed once its trash retention period expired. It can
+	// no longer be restored.
+	Purged
 )
 
 // File contains details about a media file and its corresponding memory metadata.
@@ -58,20 +60,26 @@ type File struct {
 	Extension          string
 	UploadedTimestamp  int64
 	PublishedTimestamp int64
+	DeletedTimestamp   int64
+	WarnedTimestamp    int64 // the uploader was warned that the unpublished upload is about to expire
 	Size               int64
 	UUID               string
 	Hash               string
 	UploaderUsername   string
+	PerceptualHash     uint64 // dHash of an image, used to detect near-duplicates (0 if not an image or not yet computed)
 	State
 	MetaData
 }
 
-// AbsolutePath determines the full absolute path to file.
-func (f *File) AbsolutePath() string {
-	if f.State == Uploaded {
-		return config.rootPath + "/db/temp/" + f.UploaderUsername + "/" + f.UUID + "." + f.Extension
-	}
-	return config.rootPath + "/static/content/" + f.UUID + "." + f.Extension
+// UploadPath determines the full absolute path to an uploaded file in the temp dir. Once a file has been published, its
+// content is stored in the BlobStore under its hash instead.
+func (f *File) UploadPath() string {
+	return config.rootPath + "/db/temp/" + f.UploaderUsername + "/" + f.UUID + "." + f.Extension
+}
+
+// ContentName returns the file name used when serving a file's content.
+func (f *File) ContentName() string {
+	return f.UUID + "." + f.Extension
 }
 
 // TransactionType the type of memory transformation operation documented.
@@ -86,6 +94,10 @@ const (
 	Delete
 	// Merge transactions represent a memory merge with a duplicate memory from another service host.
 	Merge
+	// Undelete transactions represent a deleted memory being restored from the trash.
+	Undelete
+	// Purge transactions represent the content of a deleted memory being removed from the trash.
+	Purge
 )
 
 // Transaction is an immutable record of a successful FileDB transforming request.
@@ -95,26 +107,113 @@ type Transaction struct {
 	Type              TransactionType
 	CreationTimestamp int64
 	Version           string
+	ActorUsername     string // user who made the change
+	SourceInstance    string // name of the service instance the change was made on
+	Before            FileRevision
+	After             FileRevision
+}
+
+// FileRevision is the MetaData & State of a File before or after a Transaction.
+type FileRevision struct {
+	State
+	MetaData
+}
+
+// FieldChange describes a File field which was changed by a Transaction.
+type FieldChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// stateNames are the display names of each State.
+var stateNames = map[State]string{Uploaded: "uploaded", Published: "published", Deleted: "deleted", Purged: "purged"}
+
+// Changes compares the Before & After revisions of a Transaction, returning each field which differs.
+func (t Transaction) Changes() (changes []FieldChange) {
+	compare := func(field, before, after string) {
+		if before != after {
+			changes = append(changes, FieldChange{Field: field, Before: before, After: after})
+		}
+	}
+
+	compare("state", stateNames[t.Before.State], stateNames[t.After.State])
+	compare("description", t.Before.Description, t.After.Description)
+	compare("media_type", t.Before.MediaType, t.After.MediaType)
+	compare("tags", strings.Join(t.Before.Tags, ", "), strings.Join(t.After.Tags, ", "))
+	compare("people", strings.Join(t.Before.People, ", "), strings.Join(t.After.People, ", "))
+	return
 }
 
 // TransactionMutex wraps all Transformations to allow permit concurrent access.
 type TransactionMutex struct {
 	Transactions []Transaction
 	mu           sync.RWMutex
+	store        FileStore
 }
 
-// Create creates a new Transaction and adds it to the Transactions list.
-func (tm *TransactionMutex) Create(transactionType TransactionType, fileUUID string) {
-	tm.mu.Lock()
-	defer tm.mu.Unlock()
-	newTransaction := Transaction{
+// Create creates a new Transaction recording the change of a File from before to after by the actor, and adds it to the
+// Transactions list.
+func (tm *TransactionMutex) Create(transactionType TransactionType, actor string, before, after File) {
+	tm.add(Transaction{
 		UUID:              NewUUID(),
 		CreationTimestamp: time.Now().Unix(),
 		Type:              transactionType,
-		TargetFileUUID:    fileUUID,
+		TargetFileUUID:    after.UUID,
 		Version:           config.Version,
+		ActorUsername:     actor,
+		SourceInstance:    config.InstanceName,
+		Before:            FileRevision{State: before.State, MetaData: before.MetaData},
+		After:             FileRevision{State: after.State, MetaData: after.MetaData},
+	})
+}
+
+// add appends a Transaction to the Transactions list & writes it through to the store.
+func (tm *TransactionMutex) add(transaction Transaction) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.Transactions = append(tm.Transactions, transaction)
+
+	if tm.store != nil {
+		if err := tm.store.AddTransaction(transaction); err != nil {
+			Critical.Log(err)
+		}
 	}
-	tm.Transactions = append(tm.Transactions, newTransaction)
+}
+
+// TransactionHistory is a page of Transactions, newest first.
+type TransactionHistory struct {
+	ResultCount  int            `json:"result_count"`
+	TotalCount   int            `json:"total_count"`
+	Transactions []HistoryEntry `json:"transactions"`
+}
+
+// HistoryEntry is a Transaction along with the changes it made.
+type HistoryEntry struct {
+	Transaction
+	Changes []FieldChange `json:"changes"`
+}
+
+// History returns a page of the Transactions which match the filter, newest first. A resultsPerPage of 0 returns all
+// matching Transactions.
+func (tm *TransactionMutex) History(filter func(Transaction) bool, page, resultsPerPage int) TransactionHistory {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	history := TransactionHistory{Transactions: make([]HistoryEntry, 0)}
+	for i := len(tm.Transactions) - 1; i >= 0; i-- {
+		if !filter(tm.Transactions[i]) {
+			continue
+		}
+		history.TotalCount++
+
+		if resultsPerPage > 0 && (history.TotalCount <= page*resultsPerPage || history.TotalCount > (page+1)*resultsPerPage) {
+			continue
+		}
+		history.Transactions = append(history.Transactions, HistoryEntry{tm.Transactions[i], tm.Transactions[i].Changes()})
+	}
+	history.ResultCount = len(history.Transactions)
+	return history
 }
 
 // FileMapMutex wraps all Files to permit safe concurrent access.
@@ -122,13 +221,48 @@ type FileMapMutex struct {
 	Files map[string]File
 	mu    sync.RWMutex
 	name  string
+	store FileStore
+	index *fileIndex
 }
 
 // Set creates or updates a File in a FileDB.
 func (fm *FileMapMutex) Set(UUID string, file File) {
 	fm.mu.Lock()
 	defer fm.mu.Unlock()
+	fm.set(UUID, file)
+}
+
+// Update replaces an existing File in a FileDB with the File returned by update, unless update returns false. The File
+// is read & replaced under a single lock, so changes made concurrently by Set are not overwritten.
+func (fm *FileMapMutex) Update(UUID string, update func(file File) (File, bool)) (updated bool) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	existing, ok := fm.Files[UUID]
+	if !ok {
+		return false
+	}
+	file, ok := update(existing)
+	if !ok {
+		return false
+	}
+	fm.set(UUID, file)
+	return true
+}
+
+// set creates or updates a File in a FileDB. The caller must hold the write lock.
+func (fm *FileMapMutex) set(UUID string, file File) {
+	if existing, ok := fm.Files[UUID]; ok {
+		fm.index.remove(existing)
+	}
 	fm.Files[UUID] = file
+	fm.index.add(file)
+
+	// write through to store while locked so that changes are stored in the same order as they are applied
+	if fm.store != nil {
+		if err := fm.store.SetFile(fm.name, UUID, file); err != nil {
+			Critical.Log(err)
+		}
+	}
 }
 
 // Get attempts to retrieve a File from a FileDB.
@@ -150,7 +284,16 @@ func (fm *FileMapMutex) Count() (size int) {
 func (fm *FileMapMutex) Delete(UUID string) {
 	fm.mu.Lock()
 	defer fm.mu.Unlock()
+	if existing, ok := fm.Files[UUID]; ok {
+		fm.index.remove(existing)
+	}
 	delete(fm.Files, UUID)
+
+	if fm.store != nil {
+		if err := fm.store.DeleteFile(fm.name, UUID); err != nil {
+			Critical.Log(err)
+		}
+	}
 }
 
 // FileMapDB is a File container, where the map key is the file UUID.
@@ -161,7 +304,8 @@ type FileMapDB map[string]File
 type FileMapFunc func(FileMapDB, string) interface{}
 
 // PerformFunc executes the FileMapFunc, wrapping it in a Mutex lock to serialise access. This is used for more complex
-// operations where many locking and unlocking operations would have been required otherwise.
+// operations where many locking and unlocking operations would have been required otherwise. The FileMapFunc must not
+// modify the map, as changes made outside of Set & Delete bypass the store & the secondary indexes.
 func (fm *FileMapMutex) PerformFunc(fileMapFunc FileMapFunc) interface{} {
 	fm.mu.Lock()
 	defer fm.mu.Unlock()
@@ -175,8 +319,17 @@ type FileDB struct {
 	Uploaded         FileMapMutex     // in temp dir, viewable by the uploader only
 	FileTransactions TransactionMutex // uniquely documents all memory creations/transformations
 
-	dir  string
-	file string
+	dir   string
+	store FileStore
+	blobs BlobStore // content of published files
+
+	publishing   map[string]bool // UUIDs of Files currently being published
+	publishingMu sync.Mutex
+
+	resumableLocks map[string]bool // IDs of resumable uploads currently being written to
+	resumableMu    sync.Mutex
+
+	recoveryWarnings []string // data loss detected while loading the FileDB from its store
 }
 
 // LockAll locks all child Mutexes on the FileDB. Used when serializing the entire FileDB to file.
@@ -193,12 +346,54 @@ func (db *FileDB) UnlockAll() {
 	db.FileTransactions.mu.Unlock()
 }
 
-// NewFileDB initialises the FileDB containers and populates them with data from the stored file if possible. Otherwise,
-// a new file is created containing the serialized empty FileDB.
+// NewFileDB initialises the FileDB containers, populates them with data from the configured FileStore & runs any
+// pending migrations.
 func NewFileDB(dbDir string) (fileDB *FileDB, err error) {
-	// check db/temp & static/content directories exists
-	if err = EnsureDirExists(dbDir, dbDir+"/temp/", config.rootPath+"/static/content/"); err != nil {
-		return nil, errors.Wrap(err, "a FileDB directory could not be created")
+	fileDB, schemaVersion, err := openFileDB(dbDir, false)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := fileDB.migrate(schemaVersion, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not migrate FileDB")
+	}
+	for _, change := range changes {
+		Info.Log("migrated " + change)
+	}
+	fileDB.Checkpoint()
+
+	if err = fileDB.recoverPublishes(); err != nil {
+		return nil, errors.Wrap(err, "could not recover interrupted publishes")
+	}
+
+	if err = fileDB.importLegacyContent(); err != nil {
+		return nil, errors.Wrap(err, "could not import content into BlobStore")
+	}
+
+	return fileDB, nil
+}
+
+// openFileDB initialises the FileDB containers and loads them from the configured FileStore, returning the schema
+// version the data was stored with. If readOnly is set, nothing on disk is created or modified & the FileDB has no
+// BlobStore, i.e. for a migration dry run.
+func openFileDB(dbDir string, readOnly bool) (fileDB *FileDB, schemaVersion int, err error) {
+	// check db/temp directory exists
+	if !readOnly {
+		if err = EnsureDirExists(dbDir, dbDir+"/temp/"); err != nil {
+			return nil, 0, errors.Wrap(err, "a FileDB directory could not be created")
+		}
+	}
+
+	store, err := NewFileStore(dbDir, readOnly)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "could not open FileDB store")
+	}
+	var blobs BlobStore
+	if !readOnly {
+		if blobs, err = NewBlobStore(dbDir); err != nil {
+			return nil, 0, errors.Wrap(err, "could not open BlobStore")
+		}
 	}
 
 	// init file DB
@@ -207,21 +402,41 @@ func NewFileDB(dbDir string) (fileDB *FileDB, err error) {
 		Uploaded:         FileMapMutex{Files: make(FileMapDB), name: "Uploaded"},
 		FileTransactions: TransactionMutex{Transactions: make([]Transaction, 0, 0)},
 		dir:              dbDir,
-		file:             dbDir + "/file_db.dat",
+		blobs:            blobs,
+	}
+	fileDB.setStore(store)
+
+	// load DB from store
+	fileDB.LockAll()
+	schemaVersion, err = store.Load(fileDB)
+	fileDB.Published.rebuildIndex()
+	fileDB.Uploaded.rebuildIndex()
+	fileDB.UnlockAll()
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "could not load FileDB from store")
 	}
 
-	// load DB from file
-	if err = fileDB.DeserializeFromFile(); err != nil {
-		err = errors.Wrap(err, "could not deserialize FileDB from file")
+	return fileDB, schemaVersion, nil
+}
+
+// setStore sets the FileStore which FileDB changes are written through to. A nil store keeps changes in memory only.
+func (db *FileDB) setStore(store FileStore) {
+	db.Published.store = store
+	db.Uploaded.store = store
+	db.FileTransactions.store = store
+	if store != nil {
+		db.store = store
 	}
-	return
 }
 
 // FileSearchResult is a structure for returning File search results from FileDB.search.
 type FileSearchResult struct {
-	ResultCount int    `json:"result_count"`
-	TotalCount  int    `json:"total_count"`
-	Files       []File `json:"memories"`
+	ResultCount int          `json:"result_count"` // number of results on the requested page
+	TotalCount  int          `json:"total_count"`  // number of results across all pages
+	Files       []ScoredFile `json:"memories"`
+	Facets      SearchFacets `json:"facets"` // counted across all pages
+	PrevCursor  string       `json:"prev_cursor,omitempty"`
+	NextCursor  string       `json:"next_cursor,omitempty"`
 	state       string
 }
 
@@ -231,7 +446,7 @@ var ErrInvalidFile = errors.New("invalid file name or extension")
 // ErrUnsupportedFormat implies the file is of an unsupported file format.
 var ErrUnsupportedFormat = errors.New("unsupported file format")
 
-// FileExistsError implies a file has already been uploaded or published.
+// FileExistsError implies a file has already been uploaded, published or deleted without yet being purged.
 type FileExistsError struct {
 	state       State
 	userIsOwner bool
@@ -245,9 +460,12 @@ func (e *FileExistsError) Error() string {
 // ConstructResponse constructs the response required by the calling HTTP handler.
 func (e *FileExistsError) ConstructResponse() string {
 	response := "already_"
-	if e.state == Published {
+	switch e.state {
+	case Published:
 		response += "published"
-	} else {
+	case Deleted:
+		response += "deleted"
+	default:
 		response += "uploaded"
 	}
 	if e.userIsOwner {
@@ -267,34 +485,16 @@ func (db *FileDB) UploadFile(r *http.Request, user User) (newTempFile File, err
 	}
 	defer newFormFile.Close()
 
-	// if a temp dir for the user does not exist, create one named by their UUID
-	tempFilePath := config.rootPath + "/db/temp/" + user.Username + "/"
-	if err = EnsureDirExists(tempFilePath); err != nil {
-		err = errors.Wrap(err, "could not create temp dir for user")
-		return
-	}
-
-	// create new file object
-	newTempFile = File{
-		UploadedTimestamp: time.Now().UnixNano(),
-		State:             Uploaded,
-		UUID:              NewUUID(),
-		UploaderUsername:  user.Username,
-	}
-
-	// separate & validate file name/extension
-	newTempFile.Name, newTempFile.Extension = SplitFileName(handler.Filename)
-	if newTempFile.Name == "" || newTempFile.Extension == "" {
-		err = ErrInvalidFile
+	// create new file object & validate file name/extension
+	if newTempFile, err = db.newUpload(handler.Filename, user.Username); err != nil {
 		return
 	}
-	if newTempFile.MediaType = config.CheckMediaType(newTempFile.Extension); newTempFile.MediaType == Unsupported {
-		err = ErrUnsupportedFormat
+	if err = db.checkQuota(user, handler.Size); err != nil {
 		return
 	}
 
 	// create new empty file
-	tempFile, err := os.OpenFile(newTempFile.AbsolutePath(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	tempFile, err := os.OpenFile(newTempFile.UploadPath(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		err = errors.Wrap(err, "failed to create new upload copy dst file")
 		return
@@ -311,142 +511,203 @@ func (db *FileDB) UploadFile(r *http.Request, user User) (newTempFile File, err
 	fileStat, err := tempFile.Stat()
 	if err != nil {
 		err = errors.Wrap(err, "failed to determine file size")
-		os.Remove(newTempFile.AbsolutePath()) // delete temp file on error
+		os.Remove(newTempFile.UploadPath()) // delete temp file on error
 		return
 	}
 	newTempFile.Size = fileStat.Size()
 
 	// generate hash of file contents
-	newTempFile.Hash, err = GenerateFileHash(newTempFile.AbsolutePath())
+	newTempFile.Hash, err = GenerateFileHash(newTempFile.UploadPath())
 	if err != nil {
 		err = errors.Wrap(err, "failed to generate hash of file")
-		os.Remove(newTempFile.AbsolutePath()) // delete temp file on error
+		os.Remove(newTempFile.UploadPath()) // delete temp file on error
 		return
 	}
 
-	// for each below, inform user if they themselves uploaded the original copy of a colliding file:
-	// compare hash against the hashes of files stored in published DB
-	hashMatch := func(m FileMapDB, mapName string) interface{} {
-		for _, file := range m {
-			if file.Hash == newTempFile.Hash {
-				existsErr := &FileExistsError{state: Published, userIsOwner: false}
+	return newTempFile, db.registerUpload(newTempFile)
+}
 
-				if mapName == "Uploaded" {
-					existsErr.state = Uploaded
-				}
-				if file.UploaderUsername == user.Username {
-					existsErr.userIsOwner = true
-				}
+// newUpload creates the File for a new upload by the user, validating the file name & extension of the uploaded file
+// and creating the temp dir of the user.
+func (db *FileDB) newUpload(fileName string, username string) (newTempFile File, err error) {
+	newTempFile = File{
+		UploadedTimestamp: time.Now().UnixNano(),
+		State:             Uploaded,
+		UUID:              NewUUID(),
+		UploaderUsername:  username,
+	}
+
+	// separate & validate file name/extension
+	newTempFile.Name, newTempFile.Extension = SplitFileName(fileName)
+	if newTempFile.Name == "" || newTempFile.Extension == "" {
+		return newTempFile, ErrInvalidFile
+	}
+	if newTempFile.MediaType = config.CheckMediaType(newTempFile.Extension); newTempFile.MediaType == Unsupported {
+		return newTempFile, ErrUnsupportedFormat
+	}
 
-				os.Remove(newTempFile.AbsolutePath()) // delete temp file if already exists in DB
-				return existsErr
+	// if a temp dir for the user does not exist, create one named by their UUID
+	if err = EnsureDirExists(db.dir + "/temp/" + username + "/"); err != nil {
+		return newTempFile, errors.Wrap(err, "could not create temp dir for user")
+	}
+	return newTempFile, nil
+}
+
+// registerUpload adds a File whose content has been written to its temp file & hashed to the Uploaded DB, computing its
+// perceptual hash if it is an image. If the content has already been uploaded, published or deleted, the temp file is
+// deleted & a FileExistsError is returned. The content of a purged file is no longer stored, so can be uploaded again.
+func (db *FileDB) registerUpload(newTempFile File) error {
+	// inform user if they themselves uploaded the original copy of a colliding published, deleted or uploaded file
+	for _, fm := range []*FileMapMutex{&db.Published, &db.Uploaded} {
+		for _, file := range fm.GetAllByHash(newTempFile.Hash) {
+			if file.State == Purged {
+				continue
 			}
+			existsErr := &FileExistsError{state: file.State, userIsOwner: file.UploaderUsername == newTempFile.UploaderUsername}
+
+			os.Remove(newTempFile.UploadPath()) // delete temp file if already exists in DB
+			return existsErr
 		}
-		return nil
 	}
 
-	if hashResult := db.Published.PerformFunc(hashMatch); hashResult != nil {
-		return newTempFile, hashResult.(error)
-	}
-	if hashResult := db.Uploaded.PerformFunc(hashMatch); hashResult != nil {
-		return newTempFile, hashResult.(error)
+	// visually similar images are only detectable by a perceptual hash
+	if perceptualHashable(newTempFile) {
+		var err error
+		if newTempFile.PerceptualHash, err = db.filePerceptualHash(newTempFile); err != nil {
+			Input.Log(errors.Wrapf(err, "could not compute perceptual hash of %v", newTempFile.UUID))
+		}
 	}
 
 	// add to temp file DB
 	db.Uploaded.Set(newTempFile.UUID, newTempFile)
-	db.SerializeToFile()
-
-	return newTempFile, nil
+	db.Checkpoint()
+	return nil
 }
 
 // ErrFileNotFound implies a file was not found which should exist.
 var ErrFileNotFound = errors.New("file not found")
 
 // PublishFile publishes the file, making it visible to all logged in users. User input Metadata is also added to the
-// file here and the original temp file will be deleted.
-func (db *FileDB) PublishFile(fileUUID string, metaData MetaData) (err error) {
+// file here and the original temp file will be deleted. The actor is the username of the publishing user. The publish is
+// all or nothing: if the content cannot be stored, the upload is left untouched, and an intent is persisted until the
+// publish completes so that an interrupted publish is completed or rolled back at startup (see recoverPublishes).
+func (db *FileDB) PublishFile(fileUUID string, metaData MetaData, actor string) (err error) {
+	// prevent the same file being published by concurrent requests
+	if err = db.claimPublish(fileUUID); err != nil {
+		return err
+	}
+	defer db.releasePublish(fileUUID)
+
 	// append new details to file object
 	uploadedFile, ok := db.Uploaded.Get(fileUUID)
 	if !ok {
 		return ErrFileNotFound
 	}
+	intent := publishIntent{File: uploadedFile, Actor: actor, Before: uploadedFile}
 
-	uploadedFile.PublishedTimestamp = time.Now().UnixNano()
+	intent.File.PublishedTimestamp = time.Now().UnixNano()
 	// get MediaType from temp uploaded file object
 	metaData.MediaType = uploadedFile.MediaType
-	uploadedFile.MetaData = metaData
-
-	// set state to published - causes AbsolutePath to return new static location instead of temp location
-	tempFilePath := uploadedFile.AbsolutePath()
-	uploadedFile.State = Published
+	intent.File.MetaData = metaData
+	intent.File.State = Published
 
-	// delete from temp DB
-	db.Uploaded.Delete(fileUUID)
-
-	if err = MoveFile(tempFilePath, uploadedFile.AbsolutePath()); err != nil {
-		os.Remove(tempFilePath) // destroy temp file on add failure
-		return errors.Wrap(err, "failed to move temp file to uploads")
+	if err = db.writeIntent(intent); err != nil {
+		return errors.Wrap(err, "failed to persist publish intent")
 	}
 
-	// add to file DB & record transaction
-	db.Published.Set(fileUUID, uploadedFile)
-	db.FileTransactions.Create(Create, fileUUID)
+	// store content in BlobStore, leaving the upload in place on failure
+	if err = db.blobs.Put(uploadedFile.Hash, uploadedFile.UploadPath()); err != nil {
+		db.removeIntent(fileUUID)
+		return errors.Wrap(err, "failed to store temp file content")
+	}
+	intent.BlobStored = true
+	if err = db.writeIntent(intent); err != nil {
+		if releaseErr := db.blobs.Release(uploadedFile.Hash); releaseErr != nil {
+			Critical.Log(errors.Wrap(releaseErr, "failed to release content of failed publish"))
+		}
+		db.removeIntent(fileUUID)
+		return errors.Wrap(err, "failed to persist publish intent")
+	}
 
-	db.SerializeToFile()
+	// add to file DB, record transaction & remove temp file
+	db.commitPublish(intent)
 	return nil
 }
 
 // GetMetaData returns a specified type of DB related metadata.
 func (db *FileDB) GetMetaData(target string) (result []string) {
+	switch target {
 	// min/max dates data request
-	if target == "dates" {
-		sortedFiles := SortFilesByDate(db.ToSlice())
-		if len(sortedFiles) > 0 {
-			minDate := fmt.Sprintf("%d", sortedFiles[len(sortedFiles)-1].PublishedTimestamp)
-			maxDate := fmt.Sprintf("%d", sortedFiles[0].PublishedTimestamp)
-			result = append(result, minDate)
-			result = append(result, maxDate)
+	case "dates":
+		if minDate, maxDate, ok := db.Published.DateRange(); ok {
+			result = append(result, fmt.Sprintf("%d", minDate), fmt.Sprintf("%d", maxDate))
 		}
-		return
+	case "tags":
+		result = db.Published.Tags()
+	case "people":
+		result = db.Published.People()
+	case "file_types":
+		fileTypes := make(map[string]bool)
+		for _, file := range db.ToSlice() {
+			fileTypes[strings.Title(file.MediaType)] = true
+		}
+		for fileType := range fileTypes {
+			result = append(result, fileType)
+		}
+		sort.Strings(result)
 	}
 
-	resultMap := make(map[string]bool)
+	return
+}
 
-	// other data request types
-	uploadedMetadata := func(m FileMapDB, mapName string) interface{} {
-		for _, file := range m {
-			switch target {
-			case "tags":
-				for _, tag := range file.Tags {
-					resultMap[tag] = true
-				}
-			case "people":
-				for _, person := range file.People {
-					resultMap[person] = true
-				}
-			case "file_types":
-				resultMap[strings.Title(file.MediaType)] = true
-			}
+// EditFile replaces the description, tags & people of a published file. The actor is the username of the editing user,
+// and an Edit Transaction is recorded unless the MetaData is unchanged. The state is checked & the file replaced under a
+// single lock, so a file deleted concurrently is never brought back by an edit.
+func (db *FileDB) EditFile(fileUUID string, metaData MetaData, actor string) error {
+	var transaction Transaction
+	found := false
+	db.Published.Update(fileUUID, func(file File) (File, bool) {
+		if file.State != Published {
+			return file, false
+		}
+		found = true
+
+		before := file
+		file.Description = metaData.Description
+		file.Tags = metaData.Tags
+		file.People = metaData.People
+
+		transaction = Transaction{
+			UUID:              NewUUID(),
+			CreationTimestamp: time.Now().Unix(),
+			Type:              Edit,
+			TargetFileUUID:    file.UUID,
+			Version:           config.Version,
+			ActorUsername:     actor,
+			SourceInstance:    config.InstanceName,
+			Before:            FileRevision{State: before.State, MetaData: before.MetaData},
+			After:             FileRevision{State: file.State, MetaData: file.MetaData},
 		}
-		return resultMap
+		return file, len(transaction.Changes()) > 0
+	})
+	if !found {
+		return ErrFileNotFound
 	}
-
-	resultMap = db.Published.PerformFunc(uploadedMetadata).(map[string]bool)
-
-	// map to slice
-	for item := range resultMap {
-		result = append(result, item)
+	if len(transaction.Changes()) == 0 {
+		return nil
 	}
-	sort.Strings(result)
+	db.FileTransactions.add(transaction)
 
-	return
+	db.Checkpoint()
+	return nil
 }
 
 // ErrFileAlreadyDeleted implies that the file to be deleted has already been deleted.
 var ErrFileAlreadyDeleted = errors.New("file has already been deleted")
 
-// DeleteFile marks a published file in the DB as deleted, or deletes an actual temp uploaded file.
-func (db *FileDB) DeleteFile(fileUUID string) (err error) {
+// DeleteFile marks a published file in the DB as deleted, or deletes an actual temp uploaded file. The actor is the
+// username of the deleting user.
+func (db *FileDB) DeleteFile(fileUUID string, actor string) (err error) {
 	// check if file exists in either published or temp/uploaded DB
 	file, ok := db.Uploaded.Get(fileUUID)
 	if !ok {
@@ -459,24 +720,43 @@ func (db *FileDB) DeleteFile(fileUUID string) (err error) {
 	// set state to deleted (so that other servers will hide the file also)
 	switch file.State {
 	case Uploaded:
-		if err = os.Remove(file.AbsolutePath()); err != nil {
+		if db.isPublishing(fileUUID) {
+			return ErrPublishInProgress
+		}
+		if err = os.Remove(file.UploadPath()); err != nil {
 			return errors.Wrap(err, "target file could not be removed")
 		}
 		db.Uploaded.Delete(fileUUID)
 
 	case Published:
+		before := file
 		file.State = Deleted
+		file.DeletedTimestamp = time.Now().UnixNano()
 		db.Published.Set(fileUUID, file)
-		db.FileTransactions.Create(Delete, file.UUID)
+		db.FileTransactions.Create(Delete, actor, before, file)
 
-	case Deleted:
+	case Deleted, Purged:
 		return ErrFileAlreadyDeleted
 	}
 
-	db.SerializeToFile()
+	db.Checkpoint()
 	return nil
 }
 
+// FileHistory returns a page of the Transactions which changed a File, newest first.
+func (db *FileDB) FileHistory(fileUUID string, page, resultsPerPage int) TransactionHistory {
+	return db.FileTransactions.History(func(t Transaction) bool {
+		return t.TargetFileUUID == fileUUID
+	}, page, resultsPerPage)
+}
+
+// InstanceHistory returns a page of the Transactions made on a service instance, newest first.
+func (db *FileDB) InstanceHistory(instance string, page, resultsPerPage int) TransactionHistory {
+	return db.FileTransactions.History(func(t Transaction) bool {
+		return t.SourceInstance == instance
+	}, page, resultsPerPage)
+}
+
 // SortFilesByDate sorts a list of Files by date.
 func SortFilesByDate(files []File) []File {
 	sort.Slice(files, func(i, j int) bool {
@@ -490,28 +770,69 @@ func SortFilesByDate(files []File) []File {
 
 // Search searches the DB for Files which match the specified criteria.
 func (db *FileDB) Search(searchReq SearchRequest) FileSearchResult {
-	files := db.ToSlice()
+	// select files by the tags, people & date range indexes, comparing dates by year/month/day only
+	query := FileQuery{
+		Tags:   searchReq.tags,
+		People: searchReq.people,
+		From:   StartOfDay(searchReq.minDate).UnixNano(),
+	}
+	if searchReq.maxDate != 0 {
+		query.To = StartOfDay(searchReq.maxDate).AddDate(0, 0, 1).UnixNano()
+	}
+	// narrow the selection by the terms which the query language requires
+	criteria := searchReq.query.criteria()
+	query.Tags = append(query.Tags, criteria.Tags...)
+	query.People = append(query.People, criteria.People...)
+	query = query.restrictDates(criteria.From, criteria.To)
+
+	files := db.Published.Query(query)
+	if searchReq.includeDeleted {
+		files = SortFilesByDate(append(files, db.Published.QueryDeleted(query)...))
+	}
+	if searchReq.query != nil {
+		matched := make([]File, 0, len(files))
+		for _, file := range files {
+			if searchReq.query.Matches(file) {
+				matched = append(matched, file)
+			}
+		}
+		files = matched
+	}
 	var filterResults, searchResults []File
 
-	// fuzzy search by description
+	// search descriptions, tags, people & file names by the full-text index, keeping the date descending order of the
+	// index
 	if searchReq.description != "" {
-		// create a slice of descriptions
-		descriptionFiles := make([]string, db.Published.Count())
-		for i, file := range files {
-			descriptionFiles[i] = file.Description
+		if matches, ok := db.Published.TextSearch(searchReq.description); ok {
+			// deleted files are not in the full-text index, so are matched against their own terms
+			deletedMatch := queryText(textTerms(searchReq.description))
+			for _, file := range files {
+				if _, found := matches[file.UUID]; found || (file.State == Deleted && deletedMatch.match(file)) {
+					searchResults = append(searchResults, file)
+				}
+			}
 		}
 
-		// fuzzy search description for matches
-		matches := fuzzy.Find(searchReq.description, descriptionFiles)
-		searchResults = make([]File, len(matches))
+		// fall back to fuzzy searching descriptions, i.e. if the search contains a typo
+		if len(searchResults) == 0 {
+			// create a slice of descriptions
+			descriptionFiles := make([]string, len(files))
+			for i, file := range files {
+				descriptionFiles[i] = file.Description
+			}
+
+			// fuzzy search description for matches
+			matches := fuzzy.Find(searchReq.description, descriptionFiles)
+			searchResults = make([]File, len(matches))
 
-		for i, match := range matches {
-			searchResults[i] = files[match.Index]
+			for i, match := range matches {
+				searchResults[i] = files[match.Index]
+			}
 		}
 
 	} else {
-		// if no description search criteria was supplied, then specific order does not matter - sort results date descending
-		searchResults = SortFilesByDate(files)
+		// if no description search criteria was supplied, then the date descending order of the index is kept
+		searchResults = files
 	}
 
 	// false = add file to results, true = remove file from results
@@ -519,56 +840,6 @@ func (db *FileDB) Search(searchReq SearchRequest) FileSearchResult {
 	keepCounter := 0
 
 	for i := range searchResults {
-		// trim epoch to HH:MM:SS to filter by year/month/day only
-		minSearchDate := TrimUnixEpoch(searchReq.minDate, false)
-		maxSearchDate := TrimUnixEpoch(searchReq.maxDate, false)
-		fileDate := TrimUnixEpoch(searchResults[i].PublishedTimestamp, true)
-
-		// min date
-		if fileDate.Before(minSearchDate) {
-			ignoreFiles[i] = true
-			continue
-		}
-		// max date
-		if searchReq.maxDate != 0 && fileDate.After(maxSearchDate) {
-			ignoreFiles[i] = true
-			continue
-		}
-
-		// filter by tags
-		if len(searchReq.tags) > 0 {
-			tagsMatched := 0
-			concatFileTags := "|" + strings.Join(searchResults[i].Tags, "|") + "|"
-			// iterate over search request tags checking if they are a substring of the combined file tags
-			for _, tag := range searchReq.tags {
-				if strings.Contains(concatFileTags, "|"+tag+"|") {
-					tagsMatched++
-				}
-			}
-			// tag not found on file
-			if tagsMatched < len(searchReq.tags) {
-				ignoreFiles[i] = true
-				continue
-			}
-		}
-
-		// filter by people
-		if len(searchReq.people) > 0 {
-			peopleMatched := 0
-			concatFilePeople := "|" + strings.Join(searchResults[i].People, "|") + "|"
-			// iterate over search request people checking if they are a substring of the combined file people
-			for _, person := range searchReq.people {
-				if strings.Contains(concatFilePeople, "|"+person+"|") {
-					peopleMatched++
-				}
-			}
-			// tag not found on file
-			if peopleMatched < len(searchReq.people) {
-				ignoreFiles[i] = true
-				continue
-			}
-		}
-
 		// filter by file types
 		if len(searchReq.fileTypes) > 0 {
 			typeMatched := false
@@ -587,6 +858,12 @@ func (db *FileDB) Search(searchReq SearchRequest) FileSearchResult {
 			}
 		}
 
+		// filter by uploader, extension, size & favourites
+		if !searchReq.matchesFilters(searchResults[i]) {
+			ignoreFiles[i] = true
+			continue
+		}
+
 		// increment counter if file is to be kept
 		if ignoreFiles[i] == false {
 			keepCounter++
@@ -603,26 +880,52 @@ func (db *FileDB) Search(searchReq SearchRequest) FileSearchResult {
 		}
 	}
 
-	// limit number of results to pagination fields
-	totalCount := db.Published.Count()
-	state := "ok"
-
-	if searchReq.resultsPerPage > 0 {
-		rangeBounds := [2]int64{searchReq.page * searchReq.resultsPerPage, (searchReq.page + 1) * searchReq.resultsPerPage}
-
-		if rangeBounds[0] > int64(len(filterResults)-1) {
-			// request out of range, return empty result set
-			return FileSearchResult{Files: make([]File, 0), ResultCount: 0, TotalCount: totalCount, state: "empty_results"}
-		}
-		if rangeBounds[1] > int64(len(filterResults)-1) {
-			rangeBounds[1] = int64(len(filterResults))
-			state = "end_of_results"
-		}
-
-		filterResults = filterResults[rangeBounds[0]:rangeBounds[1]]
+	// score each result by relevance & order them
+	scoring := newSearchScoring(searchReq)
+	scoredResults := make([]ScoredFile, len(filterResults))
+	for i, file := range filterResults {
+		scoredResults[i] = ScoredFile{File: file, Score: scoring.score(file)}
+	}
+	sortResults(scoredResults, searchReq.sort, searchReq.favourites)
+
+	// select the requested page, counting the facets across all pages
+	page := paginate(scoredResults, searchReq.sort, searchReq.cursor, int(searchReq.page), int(searchReq.resultsPerPage))
+	result := FileSearchResult{
+		ResultCount: len(page.results),
+		TotalCount:  len(scoredResults),
+		Files:       page.results,
+		Facets:      newSearchFacets(scoredResults),
+		PrevCursor:  page.prevCursor,
+		NextCursor:  page.nextCursor,
+		state:       "ok",
 	}
+	if len(page.results) == 0 {
+		result.state = "empty_results"
+	} else if page.nextCursor == "" {
+		result.state = "end_of_results"
+	}
+	return result
+}
 
-	return FileSearchResult{Files: filterResults, ResultCount: len(filterResults), TotalCount: totalCount, state: state}
+// matchesFilters determines whether a File satisfies the uploader, extension, size & favourites filters of a
+// SearchRequest. Empty filters do not restrict the results.
+func (searchReq SearchRequest) matchesFilters(file File) bool {
+	if len(searchReq.uploaders) > 0 && !containsFold(searchReq.uploaders, file.UploaderUsername) {
+		return false
+	}
+	if searchReq.uploadedBy != "" && file.UploaderUsername != searchReq.uploadedBy {
+		return false
+	}
+	if len(searchReq.extensions) > 0 && !containsFold(searchReq.extensions, file.Extension) {
+		return false
+	}
+	if file.Size < searchReq.minSize || (searchReq.maxSize != 0 && file.Size > searchReq.maxSize) {
+		return false
+	}
+	if searchReq.onlyFavourites && !searchReq.favouriteUUIDs[file.UUID] {
+		return false
+	}
+	return true
 }
 
 // GetFilesByUser retrieves all uploaded or published files corresponding to a User's username.
@@ -692,7 +995,7 @@ func (db *FileDB) ToSlice() []File {
 		files := make([]File, 0, len(m))
 
 		for _, file := range m {
-			if file.State != Deleted {
+			if file.State == Published {
 				files = append(files, file)
 			}
 		}
@@ -703,54 +1006,68 @@ func (db *FileDB) ToSlice() []File {
 	return db.Published.PerformFunc(publishedToSlice).([]File)
 }
 
-// SerializeToFile serializes the entire FileDB to a file on disk via gob.
-func (db *FileDB) SerializeToFile() (err error) {
-	db.LockAll()
-	defer db.UnlockAll()
-
-	// create/truncate file for writing to
-	file, err := os.Create(db.file)
-	if err != nil {
-		Critical.Log(err)
-		return err
+// ToSliceAll generates a slice of all published files, including those which have been deleted or purged.
+func (db *FileDB) ToSliceAll() []File {
+	allToSlice := func(m FileMapDB, mapName string) interface{} {
+		files := make([]File, 0, len(m))
+		for _, file := range m {
+			files = append(files, file)
+		}
+		return files
 	}
-	defer file.Close()
 
-	// encode & store DB to file
-	if err = gob.NewEncoder(file).Encode(&db); err != nil {
+	return db.Published.PerformFunc(allToSlice).([]File)
+}
+
+// Checkpoint allows the FileStore to compact the changes it has stored. This is called after each FileDB operation.
+func (db *FileDB) Checkpoint() {
+	if err := db.store.Checkpoint(db, false); err != nil {
 		Critical.Log(err)
-		return err
 	}
-
-	return nil
 }
 
-// DeserializeFromFile deserializes a file to the FileDB structure, overwriting current map values.
-func (db *FileDB) DeserializeFromFile() (err error) {
-	db.LockAll()
-
-	// if db file does not exist, create a new one
-	if _, err = os.Stat(db.file); os.IsNotExist(err) {
-		db.UnlockAll()
-		db.SerializeToFile()
-		return nil
-	}
-	defer db.UnlockAll()
+// RecoveryWarnings returns a description of any data loss detected while loading the FileDB from its store, i.e. if the
+// newest snapshot was unreadable & changes made since its backup could not be recovered.
+func (db *FileDB) RecoveryWarnings() []string {
+	warnings := make([]string, len(db.recoveryWarnings))
+	copy(warnings, db.recoveryWarnings)
+	return warnings
+}
 
-	// open file to read from
-	file, err := os.Open(db.file)
-	if err != nil {
-		Critical.Log(err)
+// Close forces a final checkpoint & closes the FileStore.
+func (db *FileDB) Close() error {
+	if err := db.store.Checkpoint(db, true); err != nil {
 		return err
 	}
-	defer file.Close()
+	return db.store.Close()
+}
 
-	// decode file contents to store map
-	if err = gob.NewDecoder(file).Decode(&db); err != nil {
-		Critical.Log(err)
+// importLegacyContent moves the content of files published before the BlobStore was introduced (stored in
+// static/content/<UUID>.<ext>) into the BlobStore.
+func (db *FileDB) importLegacyContent() error {
+	legacyDir := config.rootPath + "/static/content/"
+	exists, err := FileOrDirExists(legacyDir)
+	if err != nil || !exists {
 		return err
 	}
 
+	for _, file := range db.ToSliceAll() {
+		legacyPath := legacyDir + file.ContentName()
+		if exists, err := FileOrDirExists(legacyPath); err != nil || !exists {
+			continue
+		}
+
+		// content may already have been imported if the previous attempt was interrupted
+		if stored, _ := db.blobs.Exists(file.Hash); !stored {
+			if err = db.blobs.Put(file.Hash, legacyPath); err != nil {
+				return errors.Wrapf(err, "failed to import %v", legacyPath)
+			}
+		}
+		if err = os.Remove(legacyPath); err != nil {
+			return errors.Wrapf(err, "failed to remove %v", legacyPath)
+		}
+		Info.Logf("imported %v into BlobStore", file.ContentName())
+	}
 	return nil
 }
 
@@ -758,17 +1075,20 @@ func (db *FileDB) DeserializeFromFile() (err error) {
 func (db *FileDB) reset() (err error) {
 	db.LockAll()
 	defer db.UnlockAll()
-	if err = os.Remove(db.file); err != nil {
+	if err = db.store.Reset(); err != nil {
 		return
 	}
 
 	// delete all content files
-	RemoveDirContents(config.rootPath + "/static/content/")
+	RemoveDirContents(db.dir + "/blobs/")
 	RemoveDirContents(db.dir + "/temp/")
+	RemoveDirContents(db.uploadsDir())
 
 	// reinitialise DB
 	db.Published.Files = make(map[string]File)
 	db.Uploaded.Files = make(map[string]File)
+	db.Published.rebuildIndex()
+	db.Uploaded.rebuildIndex()
 	db.FileTransactions.Transactions = make([]Transaction, 0, 0)
 
 	Info.Log("DB has been reset.")
@@ -0,0 +1,159 @@
+package memoryshare
+
+import (
+	"testing"
+)
+
+func TestFileHistory(t *testing.T) {
+	db, _ := newTestFileDB(t, GobBackend)
+
+	file := File{UUID: "a", Hash: "h", State: Published, MetaData: MetaData{Description: "d", Tags: []string{"x"}}}
+	db.Published.Set(file.UUID, file)
+	db.FileTransactions.Create(Create, "bob", File{UUID: file.UUID}, file)
+
+	edited := file
+	edited.Tags = []string{"x", "y"}
+	db.Published.Set(file.UUID, edited)
+	db.FileTransactions.Create(Edit, "eve", file, edited)
+
+	if err := db.DeleteFile(file.UUID, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	history := db.FileHistory(file.UUID, 0, 2)
+	if history.TotalCount != 3 || history.ResultCount != 2 {
+		t.Fatalf("expected a page of 2 of 3 transactions, got %v of %v", history.ResultCount, history.TotalCount)
+	}
+	deleted, edit := history.Transactions[0], history.Transactions[1]
+	if deleted.Type != Delete || deleted.ActorUsername != "alice" || deleted.SourceInstance != "test-instance" {
+		t.Fatalf("expected the newest transaction to be alice's delete, got %+v", deleted.Transaction)
+	}
+	if len(edit.Changes) != 1 || edit.Changes[0].Field != "tags" || edit.Changes[0].Before != "x" ||
+		edit.Changes[0].After != "x, y" {
+		t.Fatalf("expected the edit to change the tags, got %+v", edit.Changes)
+	}
+
+	history = db.FileHistory(file.UUID, 1, 2)
+	if history.ResultCount != 1 || history.Transactions[0].Type != Create {
+		t.Fatalf("expected the last page to hold the create transaction, got %+v", history.Transactions)
+	}
+
+	if count := db.InstanceHistory("test-instance", 0, 0).TotalCount; count != 3 {
+		t.Fatalf("expected 3 transactions for the instance, got %v", count)
+	}
+	if count := db.InstanceHistory("other-instance", 0, 0).TotalCount; count != 0 {
+		t.Fatalf("expected no transactions for another instance, got %v", count)
+	}
+}
+
+func TestEditFile(t *testing.T) {
+	db, _ := newTestFileDB(t, GobBackend)
+	metaData := MetaData{Description: "d", Tags: []string{"x"}, People: []string{"p"}}
+	db.Published.Set("a", File{UUID: "a", State: Published, UploaderUsername: "bob", MetaData: metaData})
+
+	if err := db.EditFile("a", metaData, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if count := len(db.FileTransactions.Transactions); count != 0 {
+		t.Fatalf("expected an unchanged edit not to be recorded, got %v transactions", count)
+	}
+
+	if err := db.EditFile("a", MetaData{Description: "new", Tags: []string{"y"}, People: []string{"p"}}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	history := db.FileHistory("a", 0, 0)
+	if history.TotalCount != 1 || history.Transactions[0].Type != Edit || history.Transactions[0].ActorUsername != "admin" ||
+		len(history.Transactions[0].Changes) != 2 {
+		t.Fatalf("expected an Edit transaction changing the description & tags, got %v", ToJSON(history, false))
+	}
+
+	// the indexes reflect the edit
+	if files := db.Published.Query(FileQuery{Tags: []string{"y"}}); len(files) != 1 {
+		t.Fatalf("expected the new tag to be indexed, got %v", fileUUIDs(files))
+	}
+	if files := db.Published.Query(FileQuery{Tags: []string{"x"}}); len(files) != 0 {
+		t.Fatalf("expected the old tag to be removed from the index, got %v", fileUUIDs(files))
+	}
+
+	if err := db.EditFile("missing", MetaData{}, "bob"); err != ErrFileNotFound {
+		t.Fatalf("expected %v, got %v", ErrFileNotFound, err)
+	}
+	if err := db.DeleteFile("a", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.EditFile("a", MetaData{Description: "deleted"}, "bob"); err != ErrFileNotFound {
+		t.Fatalf("expected deleted files not to be editable, got %v", err)
+	}
+}
+
+func TestEditDuringDelete(t *testing.T) {
+	db, _ := newTestFileDB(t, GobBackend)
+	metaData := MetaData{Description: "d", Tags: []string{"x"}, People: []string{"p"}}
+
+	for i := 0; i < 50; i++ {
+		db.Published.Set("a", File{UUID: "a", State: Published, UploaderUsername: "bob", MetaData: metaData})
+
+		deleted := make(chan error)
+		go func() {
+			deleted <- db.DeleteFile("a", "bob")
+		}()
+		err := db.EditFile("a", MetaData{Description: "edited", Tags: []string{"y"}, People: []string{"p"}}, "bob")
+		if err != nil && err != ErrFileNotFound {
+			t.Fatal(err)
+		}
+		if err := <-deleted; err != nil {
+			t.Fatal(err)
+		}
+
+		// an edit which loses the race never brings the deleted file back
+		if file, _ := db.Published.Get("a"); file.State != Deleted {
+			t.Fatalf("expected the file to remain deleted, got %+v", file)
+		}
+	}
+}
+
+func TestSearchFilters(t *testing.T) {
+	db, _ := newTestFileDB(t, GobBackend)
+	publish := func(UUID, uploader, extension string, size int64, description string) {
+		db.Published.Set(UUID, File{UUID: UUID, UploaderUsername: uploader, State: Published, PublishedTimestamp: size,
+			Size: size, Extension: extension, MetaData: MetaData{MediaType: Image, Description: description, Tags: []string{"beach"}}})
+	}
+	publish("a", "bob", "jpg", 100, "sunny beach")
+	publish("b", "eve", "png", 200, "sunny hills")
+	publish("c", "bob", "png", 300, "rainy day")
+	publish("d", "eve", "jpg", 400, "sunny deleted")
+	if err := db.DeleteFile("d", "eve"); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		req      SearchRequest
+		expected string
+	}{
+		{SearchRequest{}, "a,b,c"},
+		{SearchRequest{uploaders: []string{"bob"}}, "a,c"},
+		{SearchRequest{uploadedBy: "eve"}, "b"},
+		{SearchRequest{extensions: []string{"png"}}, "b,c"},
+		{SearchRequest{minSize: 150, maxSize: 300}, "b,c"},
+		{SearchRequest{minSize: 150}, "b,c"},
+		{SearchRequest{onlyFavourites: true, favouriteUUIDs: map[string]bool{"c": true}}, "c"},
+		{SearchRequest{onlyFavourites: true}, ""},
+		{SearchRequest{includeDeleted: true}, "a,b,c,d"},
+		{SearchRequest{includeDeleted: true, description: "sunny"}, "a,b,d"},
+		{SearchRequest{includeDeleted: true, tags: []string{"beach"}, uploaders: []string{"eve"}}, "b,d"},
+	}
+	for i, c := range cases {
+		c.req.sort = SortOldest
+		if got := resultUUIDs(db.Search(c.req)); got != c.expected {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, got)
+		}
+	}
+
+	query, err := ParseQuery("uploader:bob ext:.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resultUUIDs(db.Search(SearchRequest{sort: SortOldest, query: query})); got != "c" {
+		t.Errorf("expected the uploader & extension query fields to filter, got %v", got)
+	}
+}
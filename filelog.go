@@ -0,0 +1,282 @@
+package memoryshare
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// logOperation represents the type of FileDB mutation documented by a logEntry.
+type logOperation int
+
+const (
+	// setFileOp represents a File being created or updated in a FileMapMutex.
+	setFileOp logOperation = iota
+	// deleteFileOp represents a File being removed from a FileMapMutex.
+	deleteFileOp
+	// createTransactionOp represents a Transaction being appended to the TransactionMutex.
+	createTransactionOp
+)
+
+// logEntry is a single FileDB mutation which is appended to the FileDB log.
+type logEntry struct {
+	Sequence    uint64 // position of the entry in the sequence of every entry appended, 0 for entries logged before
+	Operation   logOperation
+	MapName     string
+	UUID        string
+	File        File
+	Transaction Transaction
+}
+
+// maxLogEntrySize is the upper bound on the size of a single encoded logEntry. Anything larger is assumed to be a
+// corrupt entry header.
+const maxLogEntrySize = 64 * 1024 * 1024
+
+// logEntryHeaderSize is the size of the length & checksum header which precedes each encoded logEntry.
+const logEntryHeaderSize = 8
+
+// FileDBLog is an append-only log of FileDB mutations. Each entry is framed by its length and a CRC32 checksum of its
+// gob encoded contents so that a partially written entry (i.e. after a crash) can be detected and discarded on replay.
+// Entries are numbered by an increasing sequence which continues across truncations, so that a snapshot can record the
+// last entry it contains.
+type FileDBLog struct {
+	file     *os.File
+	path     string
+	count    int    // number of entries stored in the log since the last snapshot
+	sequence uint64 // sequence of the last entry appended
+	readOnly bool
+	mu       sync.Mutex
+}
+
+// OpenFileDBLog opens the log file at the given path for appending, creating it if it does not exist.
+func OpenFileDBLog(path string) (*FileDBLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open FileDB log")
+	}
+	return &FileDBLog{file: file, path: path}, nil
+}
+
+// OpenFileDBLogReadOnly opens the log file at the given path for replaying only. The log is never modified, so entries
+// cannot be appended & an incomplete entry is not truncated on replay. A missing log file contains no entries.
+func OpenFileDBLogReadOnly(path string) (*FileDBLog, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return &FileDBLog{path: path, readOnly: true}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open FileDB log")
+	}
+	return &FileDBLog{file: file, path: path, readOnly: true}, nil
+}
+
+// Append numbers a logEntry with the next sequence, encodes it and appends it to the end of the log, syncing the log to
+// disk before returning.
+func (l *FileDBLog) Append(entry logEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.readOnly {
+		return ErrReadOnlyStore
+	}
+
+	entry.Sequence = l.sequence + 1
+	payload := &bytes.Buffer{}
+	if err := gob.NewEncoder(payload).Encode(&entry); err != nil {
+		return errors.Wrap(err, "failed to encode log entry")
+	}
+
+	frame := make([]byte, logEntryHeaderSize, logEntryHeaderSize+payload.Len())
+	binary.BigEndian.PutUint32(frame[0:4], uint32(payload.Len()))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload.Bytes()))
+	frame = append(frame, payload.Bytes()...)
+
+	if _, err := l.file.Write(frame); err != nil {
+		return errors.Wrap(err, "failed to write log entry")
+	}
+	if err := l.file.Sync(); err != nil {
+		return errors.Wrap(err, "failed to sync log")
+	}
+	l.sequence = entry.Sequence
+	l.count++
+	return nil
+}
+
+// Replay reads every complete entry from the start of the log and passes it to apply in the order it was appended.
+// Entries up to & including the after sequence are already contained in the snapshot being replayed onto (i.e. if the
+// process stopped between writing a snapshot & rotating the log), so are skipped. An incomplete or corrupt entry marks
+// the end of the log - it and anything following it are truncated, unless the log is read only.
+func (l *FileDBLog) Replay(after uint64, apply func(logEntry)) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.count = 0
+	l.sequence = after
+	if l.file == nil {
+		return nil
+	}
+	if _, err := l.file.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "failed to seek to start of log")
+	}
+
+	validOffset := readLogEntries(l.file, func(entry logEntry) {
+		l.count++
+		if entry.Sequence != 0 && entry.Sequence <= after {
+			return
+		}
+		apply(entry)
+		if entry.Sequence > l.sequence {
+			l.sequence = entry.Sequence
+		}
+	})
+
+	// drop anything after the last valid entry so that new entries are not appended after garbage
+	if l.readOnly {
+		return nil
+	}
+	if err := l.file.Truncate(validOffset); err != nil {
+		return errors.Wrap(err, "failed to truncate log")
+	}
+	return nil
+}
+
+// readLogEntries passes every complete entry read from r to read in the order it was appended, returning the offset
+// following the last complete entry.
+func readLogEntries(r io.Reader, read func(logEntry)) (validOffset int64) {
+	reader := bufio.NewReader(r)
+	header := make([]byte, logEntryHeaderSize)
+
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err != io.EOF {
+				Critical.Log("discarding incomplete FileDB log entry header")
+			}
+			break
+		}
+
+		size := binary.BigEndian.Uint32(header[0:4])
+		checksum := binary.BigEndian.Uint32(header[4:8])
+		if size > maxLogEntrySize {
+			Critical.Log("discarding FileDB log entry with invalid size")
+			break
+		}
+
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			Critical.Log("discarding incomplete FileDB log entry")
+			break
+		}
+		if crc32.ChecksumIEEE(payload) != checksum {
+			Critical.Log("discarding FileDB log entry with invalid checksum")
+			break
+		}
+
+		var entry logEntry
+		if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&entry); err != nil {
+			Critical.Log(errors.Wrap(err, "discarding undecodable FileDB log entry"))
+			break
+		}
+
+		validOffset += int64(logEntryHeaderSize) + int64(size)
+		read(entry)
+	}
+	return validOffset
+}
+
+// ReplayGeneration reads every complete entry of a previous generation of the log (see Rotate) & passes it to apply.
+// A missing generation contains no entries.
+func (l *FileDBLog) ReplayGeneration(generation int, apply func(logEntry)) error {
+	file, err := os.Open(fmt.Sprintf("%s.%d", l.path, generation))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to open previous log generation")
+	}
+	defer file.Close()
+
+	readLogEntries(file, apply)
+	return nil
+}
+
+// Count returns the number of entries stored in the log.
+func (l *FileDBLog) Count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.count
+}
+
+// Sequence returns the sequence of the last entry appended to the log.
+func (l *FileDBLog) Sequence() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.sequence
+}
+
+// Truncate removes all entries from the log. This is performed once the entries have been compacted into a snapshot.
+func (l *FileDBLog) Truncate() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.readOnly {
+		return ErrReadOnlyStore
+	}
+	if err := l.file.Truncate(0); err != nil {
+		return errors.Wrap(err, "failed to truncate log")
+	}
+	if err := l.file.Sync(); err != nil {
+		return errors.Wrap(err, "failed to sync log")
+	}
+	l.count = 0
+	return nil
+}
+
+// Rotate moves the entries of the log into a previous generation once they have been compacted into a snapshot, so that
+// a backup generation of the snapshot can be brought up to date if the newer snapshot is unreadable. The log is moved to
+// path.1 (newest) & previous generations are shifted along up to path.<backups> (oldest). If backups is zero, the log is
+// truncated instead.
+func (l *FileDBLog) Rotate(backups int) error {
+	if backups <= 0 {
+		return l.Truncate()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.readOnly {
+		return ErrReadOnlyStore
+	}
+	for i := backups - 1; i > 0; i-- {
+		src := fmt.Sprintf("%s.%d", l.path, i)
+		if err := os.Rename(src, fmt.Sprintf("%s.%d", l.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "failed to rotate log generation")
+		}
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return errors.Wrap(err, "failed to rotate log")
+	}
+
+	file, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return errors.Wrap(err, "failed to open FileDB log")
+	}
+	l.file.Close()
+	l.file = file
+	l.count = 0
+	return nil
+}
+
+// Close closes the underlying log file.
+func (l *FileDBLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
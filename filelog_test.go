@@ -0,0 +1,178 @@
+package memoryshare
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFileDBLogReplay(t *testing.T) {
+	dir := newTestConfig(t, GobBackend)
+	path := dir + "/db/test.log"
+
+	log, err := OpenFileDBLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, UUID := range []string{"a", "b", "c"} {
+		if err := log.Append(logEntry{Operation: setFileOp, UUID: UUID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	log.Close()
+
+	// simulate a crash part way through appending an entry
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte{0, 0, 0, 40, 1, 2})
+	file.Close()
+
+	log, err = OpenFileDBLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	var replayed []logEntry
+	if err := log.Replay(1, func(entry logEntry) { replayed = append(replayed, entry) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed) != 2 || replayed[0].UUID != "b" || replayed[1].UUID != "c" {
+		t.Fatalf("expected entries after sequence 1 to be replayed, got %+v", replayed)
+	}
+	if log.Count() != 3 || log.Sequence() != 3 {
+		t.Fatalf("expected 3 entries up to sequence 3, got %v entries up to sequence %v", log.Count(), log.Sequence())
+	}
+
+	// the sequence continues after the log is truncated & the incomplete entry was discarded
+	if err := log.Truncate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := log.Append(logEntry{Operation: deleteFileOp, UUID: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	replayed = nil
+	if err := log.Replay(3, func(entry logEntry) { replayed = append(replayed, entry) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed) != 1 || replayed[0].Sequence != 4 || replayed[0].Operation != deleteFileOp {
+		t.Fatalf("expected the entry appended after truncation to be sequence 4, got %+v", replayed)
+	}
+}
+
+func TestFileDBSnapshotCrashRecovery(t *testing.T) {
+	db, dir := newTestFileDB(t, GobBackend)
+	store := db.store.(*gobFileStore)
+
+	for _, UUID := range []string{"a", "b"} {
+		file := File{UUID: UUID, State: Published}
+		db.Published.Set(UUID, file)
+		db.FileTransactions.Create(Create, "bob", File{UUID: UUID}, file)
+	}
+
+	// the process stops after the snapshot is written but before the log is truncated
+	db.LockAll()
+	err := store.snapshot(db)
+	db.UnlockAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	recovered, err := NewFileDB(dir + "/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(recovered.FileTransactions.Transactions); n != 2 {
+		t.Fatalf("expected logged transactions contained in the snapshot to be replayed once, got %v transactions", n)
+	}
+	if recovered.Published.Count() != 2 {
+		t.Fatalf("expected 2 published files, got %v", recovered.Published.Count())
+	}
+
+	// changes made after recovery are replayed on the next start
+	file := File{UUID: "c", State: Published}
+	recovered.Published.Set("c", file)
+	recovered.FileTransactions.Create(Create, "bob", File{UUID: "c"}, file)
+	recovered.store.Close()
+
+	reopened, err := NewFileDB(dir + "/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if n := len(reopened.FileTransactions.Transactions); n != 3 || reopened.Published.Count() != 3 {
+		t.Fatalf("expected 3 transactions & published files, got %v & %v", n, reopened.Published.Count())
+	}
+}
+
+// writeTestFileDBGenerations creates two snapshot generations, with a file & transaction logged before each snapshot
+// and a third logged after them. The newest snapshot is then corrupted.
+func writeTestFileDBGenerations(t *testing.T) (db *FileDB, dir string) {
+	t.Helper()
+	db, dir = newTestFileDB(t, GobBackend)
+	for _, UUID := range []string{"a", "b", "c"} {
+		file := File{UUID: UUID, State: Published}
+		db.Published.Set(UUID, file)
+		db.FileTransactions.Create(Create, "bob", File{UUID: UUID}, file)
+		if UUID != "c" {
+			if err := db.store.Checkpoint(db, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	db.store.Close()
+
+	if err := ioutil.WriteFile(dir+"/db/file_db.dat", []byte("corrupt"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return db, dir
+}
+
+func TestFileDBBackupRecovery(t *testing.T) {
+	_, dir := writeTestFileDBGenerations(t)
+
+	recovered, err := NewFileDB(dir + "/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.Close()
+
+	// the log generation rotated with the unreadable snapshot brings the backup up to date
+	if n := len(recovered.FileTransactions.Transactions); n != 3 || recovered.Published.Count() != 3 {
+		t.Fatalf("expected 3 transactions & published files, got %v & %v", n, recovered.Published.Count())
+	}
+	warnings := recovered.RecoveryWarnings()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "backup generation 1") {
+		t.Fatalf("expected a warning that backup generation 1 was used, got %v", warnings)
+	}
+}
+
+func TestFileDBBackupRecoveryLostChanges(t *testing.T) {
+	_, dir := writeTestFileDBGenerations(t)
+
+	// without the log generation, the changes made between the snapshots cannot be recovered
+	if err := os.Remove(dir + "/db/file_db.log.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, err := NewFileDB(dir + "/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.Close()
+
+	if _, ok := recovered.Published.Get("b"); ok {
+		t.Fatal("expected the file published between the snapshots to be lost")
+	}
+	if _, ok := recovered.Published.Get("c"); !ok {
+		t.Fatal("expected the file published after the snapshots to be replayed")
+	}
+	warnings := recovered.RecoveryWarnings()
+	if len(warnings) != 2 || !strings.Contains(warnings[1], "changes 3 to 4 were lost") {
+		t.Fatalf("expected a warning naming the lost changes, got %v", warnings)
+	}
+}
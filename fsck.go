@@ -0,0 +1,342 @@
+package memoryshare
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FsckOptions selects the optional checks & actions performed by Fsck.
+type FsckOptions struct {
+	// Verify re-hashes all uploaded & published content to detect bitrot. This reads the entire content store.
+	Verify bool
+	// Quarantine moves orphaned & corrupt content into a db/quarantine sub directory named after the time of the check.
+	Quarantine bool
+	// Repair re-registers orphaned uploads, removes Uploaded entries whose content is missing & restores missing
+	// published content from any intact copy found in db/temp or db/quarantine.
+	Repair bool
+}
+
+// FsckReport describes the problems found by Fsck & the actions taken to resolve them.
+type FsckReport struct {
+	OrphanedUploads []string `json:"orphaned_uploads"` // db/temp files which have no Uploaded entry
+	OrphanedBlobs   []string `json:"orphaned_blobs"`   // content hashes which no memory references
+	MissingUploads  []string `json:"missing_uploads"`  // UUIDs of Uploaded entries whose db/temp file is missing
+	MissingContent  []string `json:"missing_content"`  // UUIDs of memories whose content is missing
+	CorruptUploads  []string `json:"corrupt_uploads"`  // UUIDs of Uploaded entries whose content does not match the hash
+	CorruptContent  []string `json:"corrupt_content"`  // content hashes whose content does not match the hash
+	Actions         []string `json:"actions"`
+	Errors          []string `json:"errors"`
+}
+
+// fail records an error which prevented a check or action from completing.
+func (r *FsckReport) fail(err error) {
+	Critical.Logf("%+v", err)
+	r.Errors = append(r.Errors, err.Error())
+}
+
+// Fsck checks that the FileDB entries, the uploaded files in db/temp & the published content in the BlobStore agree.
+// Orphaned files, entries with missing files & (if opts.Verify is set) content which no longer matches its hash are
+// reported, then quarantined and/or repaired depending on opts. Fsck should be run while the service is idle, as
+// content written by an in-flight upload or publish may appear orphaned.
+func (db *FileDB) Fsck(opts FsckOptions) FsckReport {
+	report := FsckReport{
+		OrphanedUploads: make([]string, 0),
+		OrphanedBlobs:   make([]string, 0),
+		MissingUploads:  make([]string, 0),
+		MissingContent:  make([]string, 0),
+		CorruptUploads:  make([]string, 0),
+		CorruptContent:  make([]string, 0),
+		Actions:         make([]string, 0),
+		Errors:          make([]string, 0),
+	}
+	quarantineDir := db.dir + "/quarantine/" + time.Now().Format("20060102-150405")
+
+	db.fsckUploads(opts, quarantineDir, &report)
+	db.fsckContent(opts, quarantineDir, &report)
+
+	db.Checkpoint()
+	return report
+}
+
+// fsckUploads compares the Uploaded entries with the files in db/temp.
+func (db *FileDB) fsckUploads(opts FsckOptions, quarantineDir string, report *FsckReport) {
+	tempDir := db.dir + "/temp"
+	uploaded := db.Uploaded.PerformFunc(func(m FileMapDB, mapName string) interface{} {
+		files := make(map[string]File, len(m))
+		for UUID, file := range m {
+			files[UUID] = file
+		}
+		return files
+	}).(map[string]File)
+
+	// find orphaned temp files
+	userDirs, err := ioutil.ReadDir(tempDir)
+	if err != nil {
+		report.fail(errors.Wrap(err, "failed to read temp dir"))
+		return
+	}
+	for _, userDir := range userDirs {
+		if !userDir.IsDir() {
+			continue
+		}
+		tempFiles, err := ioutil.ReadDir(filepath.Join(tempDir, userDir.Name()))
+		if err != nil {
+			report.fail(errors.Wrap(err, "failed to read user temp dir"))
+			continue
+		}
+
+		for _, tempFile := range tempFiles {
+			UUID, _ := SplitFileName(tempFile.Name())
+			if file, ok := uploaded[UUID]; ok && file.UploadPath() == filepath.Join(tempDir, userDir.Name(), tempFile.Name()) {
+				continue
+			}
+
+			relPath := userDir.Name() + "/" + tempFile.Name()
+			report.OrphanedUploads = append(report.OrphanedUploads, relPath)
+
+			if opts.Repair {
+				err := db.reregisterUpload(userDir.Name(), tempFile)
+				if err == nil {
+					report.Actions = append(report.Actions, "re-registered orphaned upload "+relPath)
+					continue
+				}
+				Input.Log(errors.Wrapf(err, "could not re-register orphaned upload %v", relPath))
+			}
+			if opts.Quarantine {
+				db.quarantineFile(tempDir+"/"+relPath, quarantineDir+"/temp/"+relPath, report)
+			}
+		}
+	}
+
+	// find Uploaded entries with missing or corrupt content
+	for UUID, file := range uploaded {
+		exists, err := FileOrDirExists(file.UploadPath())
+		if err != nil {
+			report.fail(err)
+			continue
+		}
+
+		if exists {
+			if !opts.Verify {
+				continue
+			}
+			hash, err := GenerateFileHash(file.UploadPath())
+			if err != nil {
+				report.fail(errors.Wrapf(err, "failed to hash upload %v", UUID))
+				continue
+			}
+			if hash == file.Hash {
+				continue
+			}
+
+			report.CorruptUploads = append(report.CorruptUploads, UUID)
+			relPath := file.UploaderUsername + "/" + filepath.Base(file.UploadPath())
+			if !opts.Quarantine || !db.quarantineFile(file.UploadPath(), quarantineDir+"/temp/"+relPath, report) {
+				continue
+			}
+		} else {
+			report.MissingUploads = append(report.MissingUploads, UUID)
+		}
+
+		// the upload cannot be published without its content
+		if opts.Repair {
+			db.Uploaded.Delete(UUID)
+			report.Actions = append(report.Actions, "removed Uploaded entry "+UUID)
+		}
+	}
+}
+
+// reregisterUpload creates an Uploaded entry for an orphaned temp file, provided it is a supported format & its content
+// is not already uploaded or published.
+func (db *FileDB) reregisterUpload(username string, info os.FileInfo) (err error) {
+	file := File{
+		UploadedTimestamp: info.ModTime().UnixNano(),
+		State:             Uploaded,
+		UploaderUsername:  username,
+		Size:              info.Size(),
+	}
+	file.UUID, file.Extension = SplitFileName(info.Name())
+	file.Name = file.UUID
+	if file.UUID == "" || file.Extension == "" {
+		return ErrInvalidFile
+	}
+	if file.MediaType = config.CheckMediaType(file.Extension); file.MediaType == Unsupported {
+		return ErrUnsupportedFormat
+	}
+	if file.Hash, err = GenerateFileHash(file.UploadPath()); err != nil {
+		return err
+	}
+
+	// content already exists in the DB
+	for _, existing := range append(db.ToSliceAll(), db.GetFilesByUser(username, Uploaded)...) {
+		if existing.Hash == file.Hash || existing.UUID == file.UUID {
+			return &FileExistsError{state: existing.State, userIsOwner: existing.UploaderUsername == username}
+		}
+	}
+
+	db.Uploaded.Set(file.UUID, file)
+	return nil
+}
+
+// fsckContent compares the content hashes referenced by memories with the blobs in the BlobStore.
+func (db *FileDB) fsckContent(opts FsckOptions, quarantineDir string, report *FsckReport) {
+	stored, err := db.blobs.List()
+	if err != nil {
+		report.fail(err)
+		return
+	}
+	sort.Strings(stored)
+
+	// purged memories no longer reference their content
+	referenced := make(map[string][]File)
+	for _, file := range db.ToSliceAll() {
+		if file.State != Purged {
+			referenced[file.Hash] = append(referenced[file.Hash], file)
+		}
+	}
+
+	// find orphaned & corrupt blobs
+	missing := make(map[string]bool)
+	for hash := range referenced {
+		missing[hash] = true
+	}
+	for _, hash := range stored {
+		delete(missing, hash)
+
+		if _, ok := referenced[hash]; !ok {
+			report.OrphanedBlobs = append(report.OrphanedBlobs, hash)
+			if opts.Quarantine && !db.hashReferenced(hash) {
+				db.quarantineBlob(hash, quarantineDir, report)
+			}
+			continue
+		}
+
+		if !opts.Verify {
+			continue
+		}
+		if err := db.verifyBlob(hash); err != ErrHashMismatch {
+			if err != nil {
+				report.fail(errors.Wrapf(err, "failed to verify content %v", hash))
+			}
+			continue
+		}
+		report.CorruptContent = append(report.CorruptContent, hash)
+		if opts.Quarantine && db.quarantineBlob(hash, quarantineDir, report) {
+			missing[hash] = true
+		}
+	}
+
+	// find memories with missing content
+	for hash := range missing {
+		for _, file := range referenced[hash] {
+			report.MissingContent = append(report.MissingContent, file.UUID)
+		}
+		if opts.Repair {
+			db.repairBlob(hash, referenced[hash], report)
+		}
+	}
+	sort.Strings(report.MissingContent)
+}
+
+// hashReferenced determines whether any memory currently references the content hash.
+func (db *FileDB) hashReferenced(hash string) bool {
+	for _, file := range db.ToSliceAll() {
+		if file.Hash == hash && file.State != Purged {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyBlob reads a blob in full, returning ErrHashMismatch if its content does not match the hash.
+func (db *FileDB) verifyBlob(hash string) error {
+	blob, err := db.blobs.Open(hash)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	_, err = io.Copy(ioutil.Discard, blob)
+	return err
+}
+
+// quarantineFile moves a file into the quarantine dir, returning whether it was moved.
+func (db *FileDB) quarantineFile(src, dst string, report *FsckReport) bool {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		report.fail(errors.Wrap(err, "failed to create quarantine dir"))
+		return false
+	}
+	if err := MoveFile(src, dst); err != nil {
+		report.fail(errors.Wrapf(err, "failed to quarantine %v", src))
+		return false
+	}
+	report.Actions = append(report.Actions, "quarantined "+src+" to "+dst)
+	return true
+}
+
+// quarantineBlob copies a blob into the quarantine dir & deletes it from the BlobStore, returning whether it was
+// quarantined.
+func (db *FileDB) quarantineBlob(hash string, quarantineDir string, report *FsckReport) bool {
+	dst := quarantineDir + "/blobs/" + hash
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		report.fail(errors.Wrap(err, "failed to create quarantine dir"))
+		return false
+	}
+
+	// corrupt content is quarantined as is, so a hash mismatch is expected
+	err := WriteFileAtomic(dst, 0, func(w io.Writer) error {
+		blob, err := db.blobs.Open(hash)
+		if err != nil {
+			return err
+		}
+		defer blob.Close()
+		if _, err = io.Copy(w, blob); err != ErrHashMismatch {
+			return err
+		}
+		return nil
+	})
+	if err == nil {
+		err = db.blobs.Delete(hash)
+	}
+	if err != nil {
+		report.fail(errors.Wrapf(err, "failed to quarantine content %v", hash))
+		return false
+	}
+
+	report.Actions = append(report.Actions, "quarantined content "+hash+" to "+dst)
+	return true
+}
+
+// repairBlob restores missing content from an intact copy in db/temp or db/quarantine, adding a reference for each
+// memory which uses it.
+func (db *FileDB) repairBlob(hash string, files []File, report *FsckReport) {
+	var src string
+	for _, dir := range []string{db.dir + "/temp", db.dir + "/quarantine"} {
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || src != "" || info.IsDir() || info.Size() != files[0].Size {
+				return nil
+			}
+			if fileHash, err := GenerateFileHash(path); err == nil && fileHash == hash {
+				src = path
+			}
+			return nil
+		})
+	}
+	if src == "" {
+		report.Errors = append(report.Errors, "no intact copy of content "+hash+" found to repair from")
+		return
+	}
+
+	for _, file := range files {
+		if err := db.blobs.Put(hash, src); err != nil {
+			report.fail(errors.Wrapf(err, "failed to restore content of %v", file.UUID))
+			return
+		}
+	}
+	report.Actions = append(report.Actions, "restored content "+hash+" from "+src)
+}
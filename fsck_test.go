@@ -0,0 +1,70 @@
+package memoryshare
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestFsck(t *testing.T) {
+	db, dir := newTestFileDB(t, GobBackend)
+
+	// a db/temp file without an Uploaded entry & an Uploaded entry without a db/temp file
+	if err := os.MkdirAll(dir+"/db/temp/bob", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dir+"/db/temp/bob/orphan.txt", []byte("orphan"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	db.Uploaded.Set("missing", File{UUID: "missing", Extension: "txt", UploaderUsername: "bob", State: Uploaded})
+
+	// published content which has rotted & content which nothing references
+	path, hash := writeTestBlob(t, dir, "hello")
+	if err := db.blobs.Put(hash, path); err != nil {
+		t.Fatal(err)
+	}
+	db.Published.Set("published", File{UUID: "published", Hash: hash, Size: 5, State: Published})
+	if err := ioutil.WriteFile(db.blobs.(*LocalBlobStore).path(hash), []byte("hellx"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	orphanPath, orphanHash := writeTestBlob(t, dir, "world")
+	if err := db.blobs.Put(orphanHash, orphanPath); err != nil {
+		t.Fatal(err)
+	}
+
+	report := db.Fsck(FsckOptions{Verify: true})
+	if len(report.OrphanedUploads) != 1 || len(report.MissingUploads) != 1 || len(report.OrphanedBlobs) != 1 ||
+		len(report.CorruptContent) != 1 || len(report.Actions) != 0 {
+		t.Fatalf("expected each problem to be reported without action, got %v", ToJSON(report, true))
+	}
+
+	// an intact copy of the rotted content is used for repair
+	if err := os.MkdirAll(dir+"/db/temp/eve", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dir+"/db/temp/eve/copy.bin", []byte("hello"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	report = db.Fsck(FsckOptions{Verify: true, Quarantine: true, Repair: true})
+	if len(report.Errors) != 0 {
+		t.Fatalf("expected the repair to succeed, got %v", report.Errors)
+	}
+	if _, ok := db.Uploaded.Get("orphan"); !ok {
+		t.Fatal("expected the orphaned upload to be re-registered")
+	}
+	if _, ok := db.Uploaded.Get("missing"); ok {
+		t.Fatal("expected the missing upload to be removed")
+	}
+	if err := db.verifyBlob(hash); err != nil {
+		t.Fatalf("expected the rotted content to be repaired, got %v", err)
+	}
+	if exists, _ := db.blobs.Exists(orphanHash); exists {
+		t.Fatal("expected the orphaned content to be quarantined")
+	}
+
+	report = db.Fsck(FsckOptions{Verify: true})
+	if len(report.OrphanedUploads)+len(report.MissingUploads)+len(report.OrphanedBlobs)+len(report.CorruptContent)+
+		len(report.MissingContent) != 0 {
+		t.Fatalf("expected no problems after repair, got %v", ToJSON(report, true))
+	}
+}
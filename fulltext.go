@@ -0,0 +1,284 @@
+package memoryshare
+
+import (
+	"strings"
+	"unicode"
+)
+
+// stopWords are common English words which are too frequent to be worth indexing.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true, "for": true,
+	"from": true, "in": true, "is": true, "it": true, "of": true, "on": true, "or": true, "the": true, "to": true,
+	"was": true, "with": true,
+}
+
+// textTerms splits text into lower case words at any character which is not a letter or digit, then stems each word.
+// Stop words are omitted.
+func textTerms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		if !stopWords[word] {
+			terms = append(terms, stem(word))
+		}
+	}
+	return terms
+}
+
+// fileTerms returns the terms of the description, tags, people & original file name of a File, along with the number of
+// times each term occurs weighted by the field it occurs in (see descriptionWeight).
+func fileTerms(file File) map[string]float64 {
+	terms := make(map[string]float64)
+	count := func(text string, weight float64) {
+		for _, term := range textTerms(text) {
+			terms[term] += weight
+		}
+	}
+
+	count(file.Description, descriptionWeight)
+	for _, tag := range file.Tags {
+		count(tag, tagWeight)
+	}
+	for _, person := range file.People {
+		count(person, personWeight)
+	}
+	count(file.Name, fileNameWeight)
+	return terms
+}
+
+// TextSearch finds the visible Files which contain every term of the text in their description, tags, people or file
+// name. Words are matched by their stem, so "beaches" matches "beach". The weighted occurrences of the terms in each
+// matching File are returned by UUID. If the text contains no searchable terms, ok is false.
+func (fm *FileMapMutex) TextSearch(text string) (matches map[string]float64, ok bool) {
+	terms := textTerms(text)
+	if len(terms) == 0 {
+		return nil, false
+	}
+
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+
+	// iterate the UUIDs of the rarest term, checking each contains the other terms
+	rarest := terms[0]
+	for _, term := range terms[1:] {
+		if len(fm.index.terms[term]) < len(fm.index.terms[rarest]) {
+			rarest = term
+		}
+	}
+
+	matches = make(map[string]float64)
+candidates:
+	for UUID := range fm.index.terms[rarest] {
+		var occurrences float64
+		for _, term := range terms {
+			count, found := fm.index.terms[term][UUID]
+			if !found {
+				continue candidates
+			}
+			occurrences += count
+		}
+		matches[UUID] = occurrences
+	}
+	return matches, true
+}
+
+// stem reduces an English word to its stem using the Porter stemming algorithm, i.e. "connected", "connecting" &
+// "connections" are all reduced to "connect". Words which are not entirely lower case ASCII letters are returned as is.
+func stem(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	for _, r := range word {
+		if r < 'a' || r > 'z' {
+			return word
+		}
+	}
+
+	s := &porterStemmer{b: []byte(word)}
+	s.step1a()
+	s.step1b()
+	s.step1c()
+	s.step2()
+	s.step3()
+	s.step4()
+	s.step5()
+	return string(s.b)
+}
+
+// porterStemmer applies the steps of the Porter stemming algorithm to a word. See
+// https://tartarus.org/martin/PorterStemmer/def.txt for the definition of each step & condition.
+type porterStemmer struct {
+	b []byte
+}
+
+// consonant determines whether the letter at i is a consonant. A y is a consonant unless it follows a consonant.
+func (s *porterStemmer) consonant(i int) bool {
+	switch s.b[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !s.consonant(i-1)
+	}
+	return true
+}
+
+// measure counts the vowel-consonant sequences in the first n letters of the word.
+func (s *porterStemmer) measure(n int) (m int) {
+	i := 0
+	for i < n && s.consonant(i) {
+		i++
+	}
+	for i < n {
+		for i < n && !s.consonant(i) {
+			i++
+		}
+		if i == n {
+			break
+		}
+		for i < n && s.consonant(i) {
+			i++
+		}
+		m++
+	}
+	return m
+}
+
+// hasVowel determines whether the first n letters of the word contain a vowel.
+func (s *porterStemmer) hasVowel(n int) bool {
+	for i := 0; i < n; i++ {
+		if !s.consonant(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// doubleConsonant determines whether the first n letters of the word end with a double consonant.
+func (s *porterStemmer) doubleConsonant(n int) bool {
+	return n >= 2 && s.b[n-1] == s.b[n-2] && s.consonant(n-1)
+}
+
+// cvc determines whether the first n letters of the word end consonant-vowel-consonant, where the last consonant is not
+// w, x or y (i.e. hop but not snow).
+func (s *porterStemmer) cvc(n int) bool {
+	if n < 3 || !s.consonant(n-3) || s.consonant(n-2) || !s.consonant(n-1) {
+		return false
+	}
+	last := s.b[n-1]
+	return last != 'w' && last != 'x' && last != 'y'
+}
+
+func (s *porterStemmer) hasSuffix(suffix string) bool {
+	return strings.HasSuffix(string(s.b), suffix)
+}
+
+// replaceSuffix replaces the suffix of the word if the measure of the rest of the word exceeds minMeasure.
+func (s *porterStemmer) replaceSuffix(suffix, replacement string, minMeasure int) {
+	n := len(s.b) - len(suffix)
+	if s.measure(n) > minMeasure {
+		s.b = append(s.b[:n], replacement...)
+	}
+}
+
+// replaceFirstSuffix replaces the first suffix of the rules (pairs of suffix & replacement) which the word ends with, if
+// the measure of the rest of the word exceeds minMeasure. The rules are ordered so that longer suffixes are found first.
+func (s *porterStemmer) replaceFirstSuffix(rules []string, minMeasure int) {
+	for i := 0; i < len(rules); i += 2 {
+		if s.hasSuffix(rules[i]) {
+			s.replaceSuffix(rules[i], rules[i+1], minMeasure)
+			return
+		}
+	}
+}
+
+// step1a removes plurals.
+func (s *porterStemmer) step1a() {
+	switch {
+	case s.hasSuffix("sses"), s.hasSuffix("ies"):
+		s.b = s.b[:len(s.b)-2]
+	case s.hasSuffix("ss"):
+	case s.hasSuffix("s"):
+		s.b = s.b[:len(s.b)-1]
+	}
+}
+
+// step1b removes -ed & -ing, tidying up the stem which remains.
+func (s *porterStemmer) step1b() {
+	if s.hasSuffix("eed") {
+		s.replaceSuffix("eed", "ee", 0)
+		return
+	}
+
+	var n int
+	switch {
+	case s.hasSuffix("ed") && s.hasVowel(len(s.b)-2):
+		n = len(s.b) - 2
+	case s.hasSuffix("ing") && s.hasVowel(len(s.b)-3):
+		n = len(s.b) - 3
+	default:
+		return
+	}
+	s.b = s.b[:n]
+
+	switch {
+	case s.hasSuffix("at"), s.hasSuffix("bl"), s.hasSuffix("iz"):
+		s.b = append(s.b, 'e')
+	case s.doubleConsonant(n) && !s.hasSuffix("l") && !s.hasSuffix("s") && !s.hasSuffix("z"):
+		s.b = s.b[:n-1]
+	case s.measure(n) == 1 && s.cvc(n):
+		s.b = append(s.b, 'e')
+	}
+}
+
+// step1c replaces a terminal y with an i if there is another vowel in the word.
+func (s *porterStemmer) step1c() {
+	if s.hasSuffix("y") && s.hasVowel(len(s.b)-1) {
+		s.b[len(s.b)-1] = 'i'
+	}
+}
+
+// step2 maps double suffixes to single ones.
+func (s *porterStemmer) step2() {
+	s.replaceFirstSuffix([]string{
+		"ational", "ate", "tional", "tion", "enci", "ence", "anci", "ance", "izer", "ize", "bli", "ble", "alli", "al",
+		"entli", "ent", "eli", "e", "ousli", "ous", "ization", "ize", "ation", "ate", "ator", "ate", "alism", "al",
+		"iveness", "ive", "fulness", "ful", "ousness", "ous", "aliti", "al", "iviti", "ive", "biliti", "ble", "logi", "log",
+	}, 0)
+}
+
+// step3 removes or simplifies -ic-, -full, -ness etc.
+func (s *porterStemmer) step3() {
+	s.replaceFirstSuffix([]string{
+		"icate", "ic", "ative", "", "alize", "al", "iciti", "ic", "ical", "ic", "ful", "", "ness", "",
+	}, 0)
+}
+
+// step4 removes -ant, -ence etc. from words with a long enough stem.
+func (s *porterStemmer) step4() {
+	if s.hasSuffix("ion") {
+		if n := len(s.b) - 3; n > 0 && (s.b[n-1] == 's' || s.b[n-1] == 't') {
+			s.replaceSuffix("ion", "", 1)
+		}
+		return
+	}
+	s.replaceFirstSuffix([]string{
+		"al", "", "ance", "", "ence", "", "er", "", "ic", "", "able", "", "ible", "", "ant", "", "ement", "", "ment", "",
+		"ent", "", "ou", "", "ism", "", "ate", "", "iti", "", "ous", "", "ive", "", "ize", "",
+	}, 1)
+}
+
+// step5 removes a final -e & reduces a final -ll to -l in words with a long enough stem.
+func (s *porterStemmer) step5() {
+	if s.hasSuffix("e") {
+		n := len(s.b) - 1
+		if m := s.measure(n); m > 1 || (m == 1 && !s.cvc(n)) {
+			s.b = s.b[:n]
+		}
+	}
+	if s.hasSuffix("ll") && s.measure(len(s.b)) > 1 {
+		s.b = s.b[:len(s.b)-1]
+	}
+}
@@ -0,0 +1,106 @@
+package memoryshare
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStem(t *testing.T) {
+	cases := []struct {
+		word     string
+		expected string
+	}{
+		{"caresses", "caress"},
+		{"ponies", "poni"},
+		{"cats", "cat"},
+		{"agreed", "agre"},
+		{"feed", "feed"},
+		{"running", "run"},
+		{"hopping", "hop"},
+		{"hoping", "hope"},
+		{"controlling", "control"},
+		{"rolled", "roll"},
+		{"sized", "size"},
+		{"happy", "happi"},
+		{"sky", "sky"},
+		{"relational", "relat"},
+		{"generalizations", "gener"},
+		{"connected", "connect"},
+		{"connections", "connect"},
+		{"adoption", "adopt"},
+		{"electricity", "electr"},
+		{"beaches", "beach"},
+		{"skiing", "ski"},
+		{"café", "café"},
+		{"2017", "2017"},
+	}
+	for _, c := range cases {
+		if got := stem(c.word); got != c.expected {
+			t.Errorf("%v: expected %v, got %v", c.word, c.expected, got)
+		}
+	}
+
+	if terms := strings.Join(textTerms("The Beaches of New-York, at SUNSET!"), ","); terms != "beach,new,york,sunset" {
+		t.Errorf("expected stop words to be removed & the remaining words stemmed, got %v", terms)
+	}
+}
+
+func TestTextSearch(t *testing.T) {
+	db, _ := newTestFileDB(t, GobBackend)
+	publish := func(UUID, name, description string, timestamp int64, tags []string) {
+		db.Published.Set(UUID, File{UUID: UUID, Name: name, State: Published, PublishedTimestamp: timestamp,
+			UploaderUsername: "bob", MetaData: MetaData{Description: description, Tags: tags, People: []string{"bob"}}})
+	}
+	publish("a", "IMG_1", "Swimming at the beach", 1, []string{"summer"})
+	publish("b", "holiday_beaches", "Beach beach beaches", 2, []string{"beach"})
+	publish("c", "IMG_3", "Skiing in the mountains", 3, []string{"snow"})
+
+	cases := []struct {
+		description string
+		expected    string
+	}{
+		{"beaches", "b,a"},  // stemmed, most occurrences first
+		{"swim beach", "a"}, // every term must match
+		{"holiday", "b"},    // file names are indexed
+		{"mntns", "c"},      // fuzzy matching is the fallback when no term matches
+	}
+	for _, c := range cases {
+		if got := resultUUIDs(db.Search(SearchRequest{description: c.description})); got != c.expected {
+			t.Errorf("%q: expected %v, got %v", c.description, c.expected, got)
+		}
+	}
+	if matches, _ := db.Published.TextSearch("bob"); len(matches) != 3 {
+		t.Errorf("expected people to be indexed, got %v matches", len(matches))
+	}
+	if _, ok := db.Published.TextSearch("the of"); ok {
+		t.Error("expected a search of only stop words to be unusable")
+	}
+
+	// edits & deletions update the index
+	if err := db.EditFile("c", MetaData{Description: "Snowboarding", Tags: []string{"snow"}, People: []string{"bob"}}, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if matches, _ := db.Published.TextSearch("skiing"); len(matches) != 0 {
+		t.Errorf("expected the old description to be removed from the index, got %v", matches)
+	}
+	if matches, _ := db.Published.TextSearch("snowboard"); len(matches) != 1 {
+		t.Errorf("expected the new description to be indexed, got %v", matches)
+	}
+	if err := db.DeleteFile("b", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if matches, _ := db.Published.TextSearch("beach"); len(matches) != 1 {
+		t.Errorf("expected deleted files to be removed from the index, got %v", matches)
+	}
+
+	// free text terms of a query use the index
+	for query, expected := range map[string]string{"swims -summer": "", "swims OR snowboards": "c,a"} {
+		parsed, err := ParseQuery(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := resultUUIDs(db.Search(SearchRequest{query: parsed})); got != expected {
+			t.Errorf("%q: expected %v, got %v", query, expected, got)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package memoryshare
+
+import (
+	"sort"
+)
+
+// fileIndex holds secondary indexes over the Files in a FileMapMutex. It is maintained by FileMapMutex.Set & Delete
+// under the FileMapMutex lock. Tags, people, dates & terms only index Files which are visible to users (i.e. not
+// deleted).
+type fileIndex struct {
+	hashes map[string]map[string]bool    // content hash -> File UUIDs
+	tags   map[string]map[string]bool    // tag -> File UUIDs
+	people map[string]map[string]bool    // person -> File UUIDs
+	dates  []datedUUID                   // ordered by timestamp, oldest first
+	usage  map[string]Usage              // uploader username -> storage used
+	terms  map[string]map[string]float64 // stemmed full-text term -> File UUID -> weighted occurrences (see fileTerms)
+}
+
+// datedUUID is an entry in the date index.
+type datedUUID struct {
+	timestamp int64
+	UUID      string
+}
+
+// newFileIndex creates an empty fileIndex.
+func newFileIndex() *fileIndex {
+	return &fileIndex{
+		hashes: make(map[string]map[string]bool),
+		tags:   make(map[string]map[string]bool),
+		people: make(map[string]map[string]bool),
+		usage:  make(map[string]Usage),
+		terms:  make(map[string]map[string]float64),
+	}
+}
+
+// indexDate returns the timestamp a File is ordered by in the date index.
+func indexDate(file File) int64 {
+	if file.State == Uploaded {
+		return file.UploadedTimestamp
+	}
+	return file.PublishedTimestamp
+}
+
+// visible determines whether a File is included in the tag, people & date indexes.
+func visible(file File) bool {
+	return file.State == Uploaded || file.State == Published
+}
+
+// addToSet adds a UUID to the set stored under key.
+func addToSet(sets map[string]map[string]bool, key, UUID string) {
+	if sets[key] == nil {
+		sets[key] = make(map[string]bool)
+	}
+	sets[key][UUID] = true
+}
+
+// removeFromSet removes a UUID from the set stored under key, removing the set once it is empty.
+func removeFromSet(sets map[string]map[string]bool, key, UUID string) {
+	delete(sets[key], UUID)
+	if len(sets[key]) == 0 {
+		delete(sets, key)
+	}
+}
+
+// add indexes a File.
+func (i *fileIndex) add(file File) {
+	addToSet(i.hashes, file.Hash, file.UUID)
+	// the content of purged Files is no longer stored
+	if file.State != Purged {
+		usage := i.usage[file.UploaderUsername]
+		usage.Bytes += file.Size
+		usage.Files++
+		i.usage[file.UploaderUsername] = usage
+	}
+	if !visible(file) {
+		return
+	}
+
+	for _, tag := range file.Tags {
+		addToSet(i.tags, tag, file.UUID)
+	}
+	for _, person := range file.People {
+		addToSet(i.people, person, file.UUID)
+	}
+	for term, count := range fileTerms(file) {
+		if i.terms[term] == nil {
+			i.terms[term] = make(map[string]float64)
+		}
+		i.terms[term][file.UUID] = count
+	}
+
+	entry := datedUUID{timestamp: indexDate(file), UUID: file.UUID}
+	pos := i.datePosition(entry)
+	i.dates = append(i.dates, datedUUID{})
+	copy(i.dates[pos+1:], i.dates[pos:])
+	i.dates[pos] = entry
+}
+
+// remove removes a previously indexed File from the index.
+func (i *fileIndex) remove(file File) {
+	removeFromSet(i.hashes, file.Hash, file.UUID)
+	if file.State != Purged {
+		usage := i.usage[file.UploaderUsername]
+		usage.Bytes -= file.Size
+		usage.Files--
+		if usage.Files == 0 {
+			delete(i.usage, file.UploaderUsername)
+		} else {
+			i.usage[file.UploaderUsername] = usage
+		}
+	}
+	if !visible(file) {
+		return
+	}
+
+	for _, tag := range file.Tags {
+		removeFromSet(i.tags, tag, file.UUID)
+	}
+	for _, person := range file.People {
+		removeFromSet(i.people, person, file.UUID)
+	}
+	for term := range fileTerms(file) {
+		delete(i.terms[term], file.UUID)
+		if len(i.terms[term]) == 0 {
+			delete(i.terms, term)
+		}
+	}
+
+	entry := datedUUID{timestamp: indexDate(file), UUID: file.UUID}
+	if pos := i.datePosition(entry); pos < len(i.dates) && i.dates[pos] == entry {
+		i.dates = append(i.dates[:pos], i.dates[pos+1:]...)
+	}
+}
+
+// datePosition finds the position of an entry in the date index, ordering entries with equal timestamps by UUID.
+func (i *fileIndex) datePosition(entry datedUUID) int {
+	return sort.Search(len(i.dates), func(j int) bool {
+		if i.dates[j].timestamp != entry.timestamp {
+			return i.dates[j].timestamp > entry.timestamp
+		}
+		return i.dates[j].UUID >= entry.UUID
+	})
+}
+
+// rebuildIndex indexes every File in the map from scratch. The caller must hold the lock.
+func (fm *FileMapMutex) rebuildIndex() {
+	fm.index = newFileIndex()
+	for _, file := range fm.Files {
+		fm.index.add(file)
+	}
+}
+
+// GetByHash returns a File whose content has the given hash.
+func (fm *FileMapMutex) GetByHash(hash string) (file File, ok bool) {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+	for UUID := range fm.index.hashes[hash] {
+		return fm.Files[UUID], true
+	}
+	return File{}, false
+}
+
+// GetAllByHash returns every File whose content has the given hash.
+func (fm *FileMapMutex) GetAllByHash(hash string) []File {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+	files := make([]File, 0, len(fm.index.hashes[hash]))
+	for UUID := range fm.index.hashes[hash] {
+		files = append(files, fm.Files[UUID])
+	}
+	return files
+}
+
+// Usage returns the storage used by the Files uploaded by a user.
+func (fm *FileMapMutex) Usage(username string) Usage {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+	return fm.index.usage[username]
+}
+
+// Tags returns every tag of the visible Files, sorted alphabetically.
+func (fm *FileMapMutex) Tags() []string {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+	return sortedKeys(fm.index.tags)
+}
+
+// People returns every person of the visible Files, sorted alphabetically.
+func (fm *FileMapMutex) People() []string {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+	return sortedKeys(fm.index.people)
+}
+
+// sortedKeys returns the keys of a set index in alphabetical order.
+func sortedKeys(sets map[string]map[string]bool) []string {
+	keys := make([]string, 0, len(sets))
+	for key := range sets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// DateRange returns the oldest & newest timestamps of the visible Files.
+func (fm *FileMapMutex) DateRange() (min, max int64, ok bool) {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+	if len(fm.index.dates) == 0 {
+		return 0, 0, false
+	}
+	return fm.index.dates[0].timestamp, fm.index.dates[len(fm.index.dates)-1].timestamp, true
+}
+
+// FileQuery selects visible Files by the secondary indexes. Empty fields do not restrict the selection.
+type FileQuery struct {
+	Tags   []string // Files must have every tag
+	People []string // Files must have every person
+	From   int64    // minimum timestamp (inclusive)
+	To     int64    // maximum timestamp (exclusive), 0 for no maximum
+}
+
+// Query returns the visible Files which match the query, newest first. Rather than scanning every File, the date index
+// is range searched & candidates are checked against the smallest tag or people set.
+func (fm *FileMapMutex) Query(query FileQuery) []File {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+
+	// gather the UUID sets which each File must be in, smallest first
+	var sets []map[string]bool
+	for _, tag := range query.Tags {
+		sets = append(sets, fm.index.tags[tag])
+	}
+	for _, person := range query.People {
+		sets = append(sets, fm.index.people[person])
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		return len(sets[i]) < len(sets[j])
+	})
+	if len(sets) > 0 && len(sets[0]) == 0 {
+		return make([]File, 0)
+	}
+
+	// binary search the date range
+	start := sort.Search(len(fm.index.dates), func(i int) bool {
+		return fm.index.dates[i].timestamp >= query.From
+	})
+	end := len(fm.index.dates)
+	if query.To != 0 {
+		end = sort.Search(len(fm.index.dates), func(i int) bool {
+			return fm.index.dates[i].timestamp >= query.To
+		})
+	}
+
+	inSets := func(UUID string) bool {
+		for _, set := range sets {
+			if !set[UUID] {
+				return false
+			}
+		}
+		return true
+	}
+
+	files := make([]File, 0)
+	// when filtering by tags or people, iterate the smallest set if it is smaller than the date range
+	if len(sets) > 0 && len(sets[0]) < end-start {
+		for UUID := range sets[0] {
+			file := fm.Files[UUID]
+			if date := indexDate(file); date >= query.From && (query.To == 0 || date < query.To) && inSets(UUID) {
+				files = append(files, file)
+			}
+		}
+		return SortFilesByDate(files)
+	}
+
+	for i := end - 1; i >= start; i-- {
+		if UUID := fm.index.dates[i].UUID; inSets(UUID) {
+			files = append(files, fm.Files[UUID])
+		}
+	}
+	return files
+}
+
+// QueryDeleted returns the deleted Files which match the query, newest first. Deleted Files are not indexed, so every
+// File is checked against the query.
+func (fm *FileMapMutex) QueryDeleted(query FileQuery) []File {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+
+	files := make([]File, 0)
+candidates:
+	for _, file := range fm.Files {
+		if file.State != Deleted {
+			continue
+		}
+		if date := indexDate(file); date < query.From || (query.To != 0 && date >= query.To) {
+			continue
+		}
+		for _, tag := range query.Tags {
+			if !containsFold(file.Tags, tag) {
+				continue candidates
+			}
+		}
+		for _, person := range query.People {
+			if !containsFold(file.People, person) {
+				continue candidates
+			}
+		}
+		files = append(files, file)
+	}
+	return SortFilesByDate(files)
+}
@@ -0,0 +1,72 @@
+package memoryshare
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileIndex(t *testing.T) {
+	day := func(d int) int64 {
+		return time.Date(2017, 6, d, 12, 0, 0, 0, time.Local).UnixNano()
+	}
+	newFile := func(UUID string, d int, tags, people []string) File {
+		file := File{UUID: UUID, Hash: "hash-" + UUID, State: Published, PublishedTimestamp: day(d)}
+		file.Tags, file.People = tags, people
+		return file
+	}
+
+	for _, backend := range storeBackends {
+		t.Run(backend, func(t *testing.T) {
+			db, _ := newTestFileDB(t, backend)
+			db.Published.Set("a", newFile("a", 1, []string{"x", "y"}, []string{"p"}))
+			db.Published.Set("b", newFile("b", 2, []string{"x"}, nil))
+			db.Published.Set("c", newFile("c", 3, []string{"y"}, []string{"p"}))
+			if err := db.DeleteFile("c", "bob"); err != nil {
+				t.Fatal(err)
+			}
+
+			// deleted files are not indexed for searching
+			if tags := db.GetMetaData("tags"); strings.Join(tags, ",") != "x,y" {
+				t.Fatalf("expected tags x & y, got %v", tags)
+			}
+			if people := db.GetMetaData("people"); strings.Join(people, ",") != "p" {
+				t.Fatalf("expected person p, got %v", people)
+			}
+
+			result := db.Search(SearchRequest{tags: []string{"x"}})
+			if UUIDs := resultUUIDs(result); UUIDs != "b,a" {
+				t.Fatalf("expected b then a to be tagged x, got %v", UUIDs)
+			}
+			result = db.Search(SearchRequest{tags: []string{"x", "y"}, people: []string{"p"}})
+			if UUIDs := resultUUIDs(result); UUIDs != "a" {
+				t.Fatalf("expected only a to match every tag & person, got %v", UUIDs)
+			}
+			result = db.Search(SearchRequest{minDate: day(2) / 1e9, maxDate: day(2) / 1e9})
+			if UUIDs := resultUUIDs(result); UUIDs != "b" {
+				t.Fatalf("expected only b to be published on day 2, got %v", UUIDs)
+			}
+
+			// replacing a file updates the index
+			file, _ := db.Published.Get("b")
+			file.Tags = []string{"z"}
+			db.Published.Set("b", file)
+			if tags := db.GetMetaData("tags"); strings.Join(tags, ",") != "x,y,z" {
+				t.Fatalf("expected tags x, y & z, got %v", tags)
+			}
+
+			// the index is rebuilt when loaded
+			db = reopenTestFileDB(t, db)
+			defer db.store.Close()
+			if dates := db.GetMetaData("dates"); len(dates) != 2 {
+				t.Fatalf("expected a date range, got %v", dates)
+			}
+			if _, ok := db.Published.GetByHash("hash-c"); !ok {
+				t.Fatal("expected deleted files to be indexed by hash")
+			}
+			if result = db.Search(SearchRequest{tags: []string{"z"}}); result.ResultCount != 1 {
+				t.Fatalf("expected 1 file tagged z, got %v", result.ResultCount)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package memoryshare
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jemgunay/logger"
+)
+
+// TestMain starts the logger poller, as logging blocks until each message is written.
+func TestMain(m *testing.M) {
+	go logger.StartPoller()
+	os.Exit(m.Run())
+}
+
+// newTestConfig points the global config at a new temporary root directory containing the directories the service
+// expects, using the given storage backend. The root path is returned.
+func newTestConfig(t *testing.T, backend string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := EnsureDirExists(dir+"/static", dir+"/db", dir+"/config"); err != nil {
+		t.Fatal(err)
+	}
+
+	config = &Config{rootPath: dir}
+	config.Version = "test"
+	config.InstanceName = "test-instance"
+	config.StorageBackend = backend
+	config.SnapshotInterval = 3
+	config.BackupGenerations = 2
+	config.ContentBackend = LocalContentBackend
+	config.fileFormats = map[string]string{"txt": Text, "png": Image, "jpg": Image}
+	return dir
+}
+
+// newTestFileDB creates a FileDB in a new temporary root directory, closing it once the test completes.
+func newTestFileDB(t *testing.T, backend string) (*FileDB, string) {
+	t.Helper()
+	dir := newTestConfig(t, backend)
+	db, err := NewFileDB(dir + "/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return db, dir
+}
+
+// fileUUIDs returns the UUIDs of Files in order.
+func fileUUIDs(files []File) []string {
+	UUIDs := make([]string, 0, len(files))
+	for _, file := range files {
+		UUIDs = append(UUIDs, file.UUID)
+	}
+	return UUIDs
+}
+
+// resultUUIDs returns the UUIDs of the Files in a search result in order, comma separated.
+func resultUUIDs(result FileSearchResult) string {
+	UUIDs := make([]string, 0, len(result.Files))
+	for _, file := range result.Files {
+		UUIDs = append(UUIDs, file.UUID)
+	}
+	return strings.Join(UUIDs, ",")
+}
+
+// writeTestBlob writes content to a file in dir, returning its path & hash.
+func writeTestBlob(t *testing.T, dir string, content string) (path string, hash string) {
+	t.Helper()
+	path = dir + "/" + content + ".txt"
+	if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := GenerateFileHash(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, hash
+}
@@ -0,0 +1,225 @@
+package memoryshare
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Migration is a step which upgrades stored data from the previous schema version to Version. Gob matches fields by
+// name, so a renamed or restructured field should be kept on its struct (marked as deprecated) until a Migration has
+// copied its value to the new field. Each func returns a description of every change it made.
+type Migration struct {
+	Version     int
+	Description string
+	FileDB      func(db *FileDB) []string
+	UserDB      func(db *UserDB) []string
+}
+
+// migrations is the registry of every Migration in ascending Version order. New steps must be appended with the next
+// Version.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "initialise favourites of users which have none",
+		UserDB: func(db *UserDB) (changes []string) {
+			// empty maps are not encoded by gob, so a user without favourites is decoded with a nil map
+			for _, user := range db.GetUsers() {
+				if user.FavouriteFileUUIDs != nil {
+					continue
+				}
+				user.FavouriteFileUUIDs = make(map[string]bool)
+				db.Users.Set(user.Username, user)
+				changes = append(changes, "initialised favourites of user "+user.Username)
+			}
+			return
+		},
+	},
+	{
+		Version:     2,
+		Description: "set deletion time of deleted memories from their delete transaction",
+		FileDB: func(db *FileDB) (changes []string) {
+			deletedAt := make(map[string]int64)
+			for _, transaction := range db.FileTransactions.Transactions {
+				if transaction.Type == Delete {
+					deletedAt[transaction.TargetFileUUID] = transaction.CreationTimestamp * int64(time.Second)
+				}
+			}
+
+			for _, file := range db.ToSliceAll() {
+				if file.State != Deleted || file.DeletedTimestamp != 0 {
+					continue
+				}
+				if file.DeletedTimestamp = deletedAt[file.UUID]; file.DeletedTimestamp == 0 {
+					file.DeletedTimestamp = time.Now().UnixNano()
+				}
+				db.Published.Set(file.UUID, file)
+				changes = append(changes, "set deletion time of memory "+file.UUID)
+			}
+			return
+		},
+	},
+	{
+		// hashing every image delayed startup on large libraries, so this step only bumps the schema version & missing
+		// hashes are computed in the background by ComputePerceptualHashes instead
+		Version:     3,
+		Description: "schema version bump only, perceptual hashes are backfilled at runtime",
+	},
+}
+
+// SchemaVersion is the version of the FileDB & UserDB schema used by this release, i.e. the Version of the newest
+// Migration.
+var SchemaVersion = migrations[len(migrations)-1].Version
+
+// ErrNewerSchema implies the stored data was written by a release with a newer schema, which this release would lose
+// data from.
+var ErrNewerSchema = errors.New("stored data has a newer schema version than this release supports")
+
+// dbEnvelope wraps a gob encoded DB with the schema version it was encoded with.
+type dbEnvelope struct {
+	SchemaVersion  int
+	ServiceVersion string
+	LogSequence    uint64 // sequence of the last FileDBLog entry contained in a FileDB snapshot
+	Payload        []byte
+}
+
+// writeEnvelope gob encodes db wrapped in a dbEnvelope.
+func writeEnvelope(w io.Writer, schemaVersion int, logSequence uint64, db interface{}) error {
+	payload := &bytes.Buffer{}
+	if err := gob.NewEncoder(payload).Encode(db); err != nil {
+		return err
+	}
+	envelope := dbEnvelope{
+		SchemaVersion:  schemaVersion,
+		ServiceVersion: config.Version,
+		LogSequence:    logSequence,
+		Payload:        payload.Bytes(),
+	}
+	return gob.NewEncoder(w).Encode(&envelope)
+}
+
+// readEnvelope decodes a dbEnvelope into db, returning the envelope without its payload. Files written before the
+// envelope was introduced contain only the DB & are treated as schema version 0.
+func readEnvelope(r io.Reader, db interface{}) (envelope dbEnvelope, err error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return envelope, err
+	}
+
+	// gob fails to decode a DB into the envelope as they have no fields in common
+	if err = gob.NewDecoder(bytes.NewReader(data)).Decode(&envelope); err != nil || envelope.Payload == nil {
+		return dbEnvelope{}, gob.NewDecoder(bytes.NewReader(data)).Decode(db)
+	}
+	payload := envelope.Payload
+	envelope.Payload = nil
+	return envelope, gob.NewDecoder(bytes.NewReader(payload)).Decode(db)
+}
+
+// pendingMigrations returns each Migration newer than the stored schema version.
+func pendingMigrations(schemaVersion int) ([]Migration, error) {
+	if schemaVersion > SchemaVersion {
+		return nil, errors.Wrapf(ErrNewerSchema, "stored version %v, supported version %v", schemaVersion, SchemaVersion)
+	}
+
+	var pending []Migration
+	for _, migration := range migrations {
+		if migration.Version > schemaVersion {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// migrate runs each pending FileDB Migration, then records the current schema version in the store. If dryRun is set,
+// the changes are made to the in memory FileDB only.
+func (db *FileDB) migrate(schemaVersion int, dryRun bool) (changes []string, err error) {
+	pending, err := pendingMigrations(schemaVersion)
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+
+	if dryRun {
+		db.setStore(nil)
+	}
+	for _, migration := range pending {
+		if migration.FileDB == nil {
+			continue
+		}
+		for _, change := range migration.FileDB(db) {
+			changes = append(changes, fmt.Sprintf("FileDB v%v (%v): %v", migration.Version, migration.Description, change))
+		}
+	}
+	if dryRun {
+		return changes, nil
+	}
+
+	if err = db.store.SetSchemaVersion(SchemaVersion); err != nil {
+		return nil, errors.Wrap(err, "failed to store FileDB schema version")
+	}
+	return changes, db.store.Checkpoint(db, true)
+}
+
+// migrate runs each pending UserDB Migration, then records the current schema version in the store. If dryRun is set,
+// the changes are made to the in memory UserDB only.
+func (db *UserDB) migrate(schemaVersion int, dryRun bool) (changes []string, err error) {
+	pending, err := pendingMigrations(schemaVersion)
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+
+	if dryRun {
+		db.Users.store = nil
+	}
+	for _, migration := range pending {
+		if migration.UserDB == nil {
+			continue
+		}
+		for _, change := range migration.UserDB(db) {
+			changes = append(changes, fmt.Sprintf("UserDB v%v (%v): %v", migration.Version, migration.Description, change))
+		}
+	}
+	if dryRun {
+		return changes, nil
+	}
+
+	if err = db.store.SetSchemaVersion(SchemaVersion); err != nil {
+		return nil, errors.Wrap(err, "failed to store UserDB schema version")
+	}
+	return changes, db.store.Checkpoint(db)
+}
+
+// Migrate loads the FileDB & UserDB without starting the service and runs any pending migrations, returning a
+// description of each change made. If dryRun is set, the stores are opened read only & the changes are reported but
+// not stored.
+func Migrate(conf *Config, dryRun bool) (changes []string, err error) {
+	config = conf
+	dbDir := config.rootPath + "/db"
+
+	fileDB, fileVersion, err := openFileDB(dbDir, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	defer fileDB.store.Close()
+
+	userDB, userVersion, err := openUserDB(dbDir, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	defer userDB.store.Close()
+
+	fileChanges, err := fileDB.migrate(fileVersion, dryRun)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to migrate FileDB")
+	}
+	userChanges, err := userDB.migrate(userVersion, dryRun)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to migrate UserDB")
+	}
+
+	return append(fileChanges, userChanges...), nil
+}
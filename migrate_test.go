@@ -0,0 +1,231 @@
+package memoryshare
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestReadEnvelope(t *testing.T) {
+	newTestConfig(t, GobBackend)
+	db := &UserDB{Users: UserMapMutex{Users: map[string]User{"bob": {Username: "bob"}}}}
+
+	// a DB encoded with its schema version
+	buf := &bytes.Buffer{}
+	if err := writeEnvelope(buf, 2, 7, db); err != nil {
+		t.Fatal(err)
+	}
+	decoded := &UserDB{}
+	envelope, err := readEnvelope(buf, decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envelope.SchemaVersion != 2 || envelope.LogSequence != 7 || envelope.ServiceVersion != "test" {
+		t.Fatalf("expected schema version 2 at log sequence 7, got %+v", envelope)
+	}
+	if envelope.Payload != nil {
+		t.Fatal("expected the payload to be omitted from the returned envelope")
+	}
+	if _, ok := decoded.Users.Users["bob"]; !ok {
+		t.Fatalf("expected the enveloped DB to be decoded, got %+v", decoded.Users.Users)
+	}
+
+	// a DB encoded before the envelope was introduced
+	buf.Reset()
+	if err := gob.NewEncoder(buf).Encode(db); err != nil {
+		t.Fatal(err)
+	}
+	decoded = &UserDB{}
+	if envelope, err = readEnvelope(buf, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.SchemaVersion != 0 {
+		t.Fatalf("expected a legacy DB to be schema version 0, got %v", envelope.SchemaVersion)
+	}
+	if _, ok := decoded.Users.Users["bob"]; !ok {
+		t.Fatalf("expected the legacy DB to be decoded, got %+v", decoded.Users.Users)
+	}
+}
+
+func TestPendingMigrations(t *testing.T) {
+	pending, err := pendingMigrations(0)
+	if err != nil || len(pending) != len(migrations) {
+		t.Fatalf("expected every migration to be pending, got %v (%v)", len(pending), err)
+	}
+	for i, migration := range pending {
+		if migration.Version != i+1 {
+			t.Fatalf("expected migration %v to be version %v, got %v", i, i+1, migration.Version)
+		}
+	}
+
+	if pending, err = pendingMigrations(SchemaVersion); err != nil || len(pending) != 0 {
+		t.Fatalf("expected no migrations to be pending, got %v (%v)", len(pending), err)
+	}
+	if _, err = pendingMigrations(SchemaVersion + 1); errors.Cause(err) != ErrNewerSchema {
+		t.Fatalf("expected ErrNewerSchema, got %v", err)
+	}
+}
+
+func TestUserDBMigrateFavourites(t *testing.T) {
+	newTestConfig(t, GobBackend)
+	db := &UserDB{Users: UserMapMutex{Users: map[string]User{
+		"bob": {Username: "bob"},
+		"eve": {Username: "eve", FavouriteFileUUIDs: map[string]bool{"a": true}},
+	}}}
+
+	// a dry run makes the changes to the in memory UserDB only
+	changes, err := db.migrate(0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || !strings.Contains(changes[0], "initialised favourites of user bob") {
+		t.Fatalf("expected the favourites of bob only to be initialised, got %v", changes)
+	}
+	if user, _ := db.Users.Get("bob"); user.FavouriteFileUUIDs == nil {
+		t.Fatal("expected the favourites of bob to be initialised")
+	}
+	if user, _ := db.Users.Get("eve"); !user.FavouriteFileUUIDs["a"] {
+		t.Fatalf("expected the favourites of eve to be kept, got %v", user.FavouriteFileUUIDs)
+	}
+}
+
+// newLegacyTestDBs stores a schema version 0 FileDB & UserDB, i.e. as written before any migration, which contain a
+// memory deleted before deletion times were recorded & a user without favourites.
+func newLegacyTestDBs(t *testing.T, backend string) (rootDir string, deletedAt int64) {
+	t.Helper()
+	dir := newTestConfig(t, backend)
+
+	fileDB, _, err := openFileDB(dir+"/db", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	published := File{UUID: "a", State: Published}
+	deleted := published
+	deleted.State = Deleted
+	fileDB.Published.Set("a", deleted)
+	fileDB.FileTransactions.Create(Delete, "bob", published, deleted)
+	deletedAt = fileDB.FileTransactions.Transactions[0].CreationTimestamp * int64(time.Second)
+	if err := fileDB.store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	userDB, _, err := openUserDB(dir+"/db", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userDB.Users.Set("bob", User{Username: "bob"})
+	if err := userDB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return dir, deletedAt
+}
+
+// readTestTree reads the contents of every file below dir, keyed by path.
+func readTestTree(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	tree := make(map[string][]byte)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		tree[path], err = ioutil.ReadFile(path)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func TestMigrate(t *testing.T) {
+	for _, backend := range storeBackends {
+		t.Run(backend, func(t *testing.T) {
+			dir, deletedAt := newLegacyTestDBs(t, backend)
+			if backend == GobBackend {
+				// simulate a crash part way through appending a log entry, which a load would otherwise truncate
+				log, err := os.OpenFile(dir+"/db/file_db.log", os.O_WRONLY|os.O_APPEND, 0666)
+				if err != nil {
+					t.Fatal(err)
+				}
+				log.Write([]byte{0, 0, 0, 40, 1, 2})
+				log.Close()
+			}
+
+			// a dry run reports the changes without modifying anything on disk
+			before := readTestTree(t, dir)
+			changes, err := Migrate(config, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(changes) != 2 {
+				t.Fatalf("expected 2 changes, got %v", changes)
+			}
+			if after := readTestTree(t, dir); !reflect.DeepEqual(before, after) {
+				t.Fatal("expected a dry run not to modify any stored data")
+			}
+
+			if changes, err = Migrate(config, false); err != nil {
+				t.Fatal(err)
+			}
+			if len(changes) != 2 || !strings.HasPrefix(changes[0], "FileDB v2") ||
+				!strings.HasPrefix(changes[1], "UserDB v1") {
+				t.Fatalf("expected the FileDB v2 & UserDB v1 changes, got %v", changes)
+			}
+
+			fileDB, fileVersion, err := openFileDB(dir+"/db", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fileDB.store.Close()
+			if file, _ := fileDB.Published.Get("a"); file.DeletedTimestamp != deletedAt {
+				t.Fatalf("expected the deletion time of the delete transaction %v, got %v", deletedAt,
+					file.DeletedTimestamp)
+			}
+			_, userVersion, err := openUserDB(dir+"/db", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fileVersion != SchemaVersion || userVersion != SchemaVersion {
+				t.Fatalf("expected schema version %v, got %v & %v", SchemaVersion, fileVersion, userVersion)
+			}
+
+			// migrated data has no pending migrations
+			if changes, err = Migrate(config, false); err != nil || len(changes) != 0 {
+				t.Fatalf("expected no changes, got %v (%v)", changes, err)
+			}
+		})
+	}
+}
+
+func TestMigrateNewerSchema(t *testing.T) {
+	for _, backend := range storeBackends {
+		t.Run(backend, func(t *testing.T) {
+			dir := newTestConfig(t, backend)
+			db, _, err := openFileDB(dir+"/db", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := db.store.SetSchemaVersion(SchemaVersion + 1); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.store.Checkpoint(db, true); err != nil {
+				t.Fatal(err)
+			}
+			db.store.Close()
+
+			for _, dryRun := range []bool{true, false} {
+				if _, err := Migrate(config, dryRun); errors.Cause(err) != ErrNewerSchema {
+					t.Fatalf("expected ErrNewerSchema (dry run %v), got %v", dryRun, err)
+				}
+			}
+		})
+	}
+}
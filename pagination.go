@@ -0,0 +1,94 @@
+package memoryshare
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidCursor implies a pagination cursor could not be decoded or was created for a different search order.
+var ErrInvalidCursor = errors.New("invalid pagination cursor")
+
+// searchCursor is the position in the results of a search which a page starts after (or ends before). As it refers to
+// the position of a result rather than an offset, pages are not shifted by memories published or deleted while paging.
+type searchCursor struct {
+	Sort   string    `json:"sort"`
+	Key    searchKey `json:"key"`
+	Before bool      `json:"before,omitempty"` // the page ends before the key rather than starting after it
+}
+
+// encode returns the opaque token which is sent to clients.
+func (c searchCursor) encode() string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		Critical.Log(errors.Wrap(err, "failed to encode search cursor"))
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeSearchCursor decodes a cursor token created for a search in the given order.
+func decodeSearchCursor(token string, order string) (*searchCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	cursor := &searchCursor{}
+	if err := json.Unmarshal(data, cursor); err != nil || cursor.Sort != order {
+		return nil, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// searchPage is a page of sorted search results along with the cursors of the adjacent pages (empty if there are no
+// results on that side).
+type searchPage struct {
+	results    []ScoredFile
+	prevCursor string
+	nextCursor string
+}
+
+// paginate selects the page of sorted results requested by the cursor, or by the page number if there is no cursor. A
+// resultsPerPage of 0 selects every result after (or before) the cursor. Bounds are compared against resultsPerPage
+// rather than offset by it, as a client supplied resultsPerPage may be large enough to overflow.
+func paginate(results []ScoredFile, order string, cursor *searchCursor, pageNum, resultsPerPage int) searchPage {
+	start, end := 0, len(results)
+	switch {
+	case cursor != nil && cursor.Before:
+		end = sort.Search(len(results), func(i int) bool {
+			return !results[i].key.before(cursor.Key, order)
+		})
+		if resultsPerPage > 0 && resultsPerPage < end {
+			start = end - resultsPerPage
+		}
+	case cursor != nil:
+		start = sort.Search(len(results), func(i int) bool {
+			return cursor.Key.before(results[i].key, order)
+		})
+		if resultsPerPage > 0 && resultsPerPage < end-start {
+			end = start + resultsPerPage
+		}
+	case resultsPerPage > 0:
+		start = len(results)
+		if pageNum <= len(results)/resultsPerPage {
+			start = pageNum * resultsPerPage
+		}
+		if resultsPerPage < end-start {
+			end = start + resultsPerPage
+		}
+	}
+
+	page := searchPage{results: results[start:end]}
+	if len(page.results) == 0 {
+		return page
+	}
+	if start > 0 {
+		page.prevCursor = searchCursor{Sort: order, Key: results[start].key, Before: true}.encode()
+	}
+	if end < len(results) {
+		page.nextCursor = searchCursor{Sort: order, Key: results[end-1].key}.encode()
+	}
+	return page
+}
@@ -0,0 +1,118 @@
+package memoryshare
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestSearchCursor(t *testing.T) {
+	db, _ := newTestFileDB(t, GobBackend)
+	publish := func(UUID string, timestamp int64) {
+		db.Published.Set(UUID, File{UUID: UUID, State: Published, PublishedTimestamp: timestamp, MetaData: MetaData{Tags: []string{"x"}}})
+	}
+	// pairs of files share a timestamp, so the cursor must break ties
+	for i := 0; i < 10; i++ {
+		publish(fmt.Sprintf("f%02d", i), int64(i/2))
+	}
+
+	req := SearchRequest{sort: SortNewest, resultsPerPage: 3}
+	first := db.Search(req)
+	if got := resultUUIDs(first); got != "f08,f09,f06" || first.PrevCursor != "" || first.NextCursor == "" || first.state != "ok" {
+		t.Fatalf("unexpected first page %v (previous %q, next %q, state %v)", got, first.PrevCursor, first.NextCursor, first.state)
+	}
+
+	// publishing a newer memory & deleting a shown one must not shift the next page
+	publish("new", 100)
+	if err := db.DeleteFile("f09", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if req.cursor, err = decodeSearchCursor(first.NextCursor, SortNewest); err != nil {
+		t.Fatal(err)
+	}
+	second := db.Search(req)
+	if got := resultUUIDs(second); got != "f07,f04,f05" || second.PrevCursor == "" {
+		t.Fatalf("expected the second page to continue after f06, got %v", got)
+	}
+
+	req.cursor, _ = decodeSearchCursor(second.PrevCursor, SortNewest)
+	if got := resultUUIDs(db.Search(req)); got != "new,f08,f06" {
+		t.Fatalf("expected the previous page to end before f07, got %v", got)
+	}
+
+	req.cursor, _ = decodeSearchCursor(second.NextCursor, SortNewest)
+	third := db.Search(req)
+	req.cursor, _ = decodeSearchCursor(third.NextCursor, SortNewest)
+	last := db.Search(req)
+	if resultUUIDs(third) != "f02,f03,f00" || resultUUIDs(last) != "f01" || last.NextCursor != "" || last.state != "end_of_results" {
+		t.Fatalf("unexpected last pages %v & %v (state %v)", resultUUIDs(third), resultUUIDs(last), last.state)
+	}
+
+	if _, err := decodeSearchCursor(first.NextCursor, SortOldest); err != ErrInvalidCursor {
+		t.Fatalf("expected a cursor to be invalid for another sort order, got %v", err)
+	}
+	if _, err := decodeSearchCursor("!!", SortNewest); err != ErrInvalidCursor {
+		t.Fatalf("expected %v, got %v", ErrInvalidCursor, err)
+	}
+}
+
+func TestSearchPages(t *testing.T) {
+	db, _ := newTestFileDB(t, GobBackend)
+	for i := 0; i < 10; i++ {
+		UUID := fmt.Sprintf("f%02d", i)
+		db.Published.Set(UUID, File{UUID: UUID, State: Published, PublishedTimestamp: int64(i)})
+	}
+
+	pages := []struct {
+		page     int64
+		expected string
+	}{
+		{0, "f09,f08,f07"},
+		{3, "f00"},
+		{4, ""},
+		{1 << 40, ""},
+	}
+	for _, p := range pages {
+		if got := resultUUIDs(db.Search(SearchRequest{sort: SortNewest, resultsPerPage: 3, page: p.page})); got != p.expected {
+			t.Errorf("page %v: expected %v, got %v", p.page, p.expected, got)
+		}
+	}
+
+	if result := db.Search(SearchRequest{sort: SortNewest}); result.ResultCount != 10 || result.state != "end_of_results" {
+		t.Errorf("expected every result without pagination, got %v (%v)", result.ResultCount, result.state)
+	}
+	if result := db.Search(SearchRequest{sort: SortNewest, tags: []string{"none"}}); result.state != "empty_results" {
+		t.Errorf("expected no results, got %v", result.state)
+	}
+}
+
+func TestSearchHugePageSize(t *testing.T) {
+	db, _ := newTestFileDB(t, GobBackend)
+	for i := 0; i < 5; i++ {
+		UUID := fmt.Sprintf("f%02d", i)
+		db.Published.Set(UUID, File{UUID: UUID, State: Published, PublishedTimestamp: int64(i)})
+	}
+
+	// a page size which overflows when added to an offset selects every remaining result
+	req := SearchRequest{sort: SortNewest, resultsPerPage: math.MaxInt64}
+	if got := resultUUIDs(db.Search(req)); got != "f04,f03,f02,f01,f00" {
+		t.Fatalf("expected every result on the first page, got %v", got)
+	}
+
+	first := db.Search(SearchRequest{sort: SortNewest, resultsPerPage: 2})
+	var err error
+	if req.cursor, err = decodeSearchCursor(first.NextCursor, SortNewest); err != nil {
+		t.Fatal(err)
+	}
+	next := db.Search(req)
+	if got := resultUUIDs(next); got != "f02,f01,f00" {
+		t.Fatalf("expected every result after the cursor, got %v", got)
+	}
+	if req.cursor, err = decodeSearchCursor(next.PrevCursor, SortNewest); err != nil {
+		t.Fatal(err)
+	}
+	if got := resultUUIDs(db.Search(req)); got != "f04,f03" {
+		t.Fatalf("expected every result before the cursor, got %v", got)
+	}
+}
@@ -0,0 +1,242 @@
+package memoryshare
+
+import (
+	"image"
+	// register the image formats which perceptual hashes are computed for
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math/bits"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// perceptualHashFormats are the extensions of the image formats which can be decoded to compute a perceptual hash.
+var perceptualHashFormats = map[string]bool{"jpg": true, "jpeg": true, "png": true, "gif": true}
+
+// PerceptualHash computes the difference hash (dHash) of an image. The image is reduced to a 9x8 grid of average
+// luminance, and each bit of the hash records whether a cell is brighter than the cell to its right. Resized &
+// re-encoded copies of an image produce the same or a similar hash, so the Hamming distance between two hashes measures
+// how visually similar the images are.
+func PerceptualHash(r io.Reader) (hash uint64, err error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to decode image")
+	}
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return 0, errors.New("image is empty")
+	}
+
+	// average luminance of each cell, sampling at most 8x8 pixels per cell to bound the cost for large images
+	const cols, rows, samples = 9, 8, 8
+	var grid [rows][cols]float64
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			var sum float64
+			for sy := 0; sy < samples; sy++ {
+				y := bounds.Min.Y + (row*samples+sy)*bounds.Dy()/(rows*samples)
+				for sx := 0; sx < samples; sx++ {
+					x := bounds.Min.X + (col*samples+sx)*bounds.Dx()/(cols*samples)
+					r, g, b, _ := img.At(x, y).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+				}
+			}
+			grid[row][col] = sum / (samples * samples)
+		}
+	}
+
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols-1; col++ {
+			hash <<= 1
+			if grid[row][col] > grid[row][col+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash, nil
+}
+
+// hashDistance returns the Hamming distance between two perceptual hashes.
+func hashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// perceptualHashable determines whether a perceptual hash can be computed for a File.
+func perceptualHashable(file File) bool {
+	return file.MediaType == Image && perceptualHashFormats[file.Extension]
+}
+
+// filePerceptualHash computes the perceptual hash of an uploaded File from its temp file, or of a published File from
+// its content in the BlobStore.
+func (db *FileDB) filePerceptualHash(file File) (uint64, error) {
+	var content io.ReadCloser
+	var err error
+	if file.State == Uploaded {
+		content, err = os.Open(file.UploadPath())
+	} else {
+		content, err = db.blobs.Open(file.Hash)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer content.Close()
+	return PerceptualHash(content)
+}
+
+// nearDuplicate determines whether two Files are visually similar images. An image whose hash is 0 (i.e. a flat image
+// or one whose hash could not be computed) is never a near-duplicate.
+func nearDuplicate(a, b File) bool {
+	if config.NearDuplicateDistance < 0 || a.PerceptualHash == 0 || b.PerceptualHash == 0 || a.UUID == b.UUID {
+		return false
+	}
+	return hashDistance(a.PerceptualHash, b.PerceptualHash) <= config.NearDuplicateDistance
+}
+
+// NearDuplicates returns the published memories which are visually similar to a File, most similar first.
+func (db *FileDB) NearDuplicates(file File) []File {
+	duplicates := make([]File, 0)
+	if file.PerceptualHash == 0 {
+		return duplicates
+	}
+	for _, published := range db.ToSlice() {
+		if nearDuplicate(file, published) {
+			duplicates = append(duplicates, published)
+		}
+	}
+
+	sort.Slice(duplicates, func(i, j int) bool {
+		return hashDistance(file.PerceptualHash, duplicates[i].PerceptualHash) < hashDistance(file.PerceptualHash, duplicates[j].PerceptualHash)
+	})
+	return duplicates
+}
+
+// NearDuplicateGroup is a group of published memories which are visually similar to each other, either directly or
+// through other memories in the group.
+type NearDuplicateGroup struct {
+	Files []File `json:"memories"`
+}
+
+// NearDuplicateReport groups the published memories which are near-duplicates of each other, largest group first. Every
+// pair of images is compared, so the report is expensive for large libraries.
+func (db *FileDB) NearDuplicateReport() []NearDuplicateGroup {
+	var images []File
+	for _, file := range db.ToSlice() {
+		if file.PerceptualHash != 0 {
+			images = append(images, file)
+		}
+	}
+
+	// union-find each pair of near-duplicates into groups
+	parent := make([]int, len(images))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range images {
+		for j := i + 1; j < len(images); j++ {
+			if nearDuplicate(images[i], images[j]) {
+				parent[find(i)] = find(j)
+			}
+		}
+	}
+
+	members := make(map[int][]File)
+	for i, file := range images {
+		root := find(i)
+		members[root] = append(members[root], file)
+	}
+	groups := make([]NearDuplicateGroup, 0)
+	for _, files := range members {
+		if len(files) > 1 {
+			groups = append(groups, NearDuplicateGroup{Files: SortFilesByDate(files)})
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if len(groups[i].Files) != len(groups[j].Files) {
+			return len(groups[i].Files) > len(groups[j].Files)
+		}
+		return groups[i].Files[0].PublishedTimestamp > groups[j].Files[0].PublishedTimestamp
+	})
+	return groups
+}
+
+// perceptualHashProgressInterval is the number of images hashed between progress log messages.
+const perceptualHashProgressInterval = 100
+
+// ComputePerceptualHashes computes the missing perceptual hashes of uploaded & published images until stop is closed,
+// returning the number of hashes computed. It is run in the background at startup, as hashing a large library would
+// delay startup for too long; near-duplicates of an image are not detected until its hash is computed. A hash of 0 is
+// treated as not yet computed, so images whose hash is 0 (i.e. flat images or those which cannot be decoded) are hashed
+// again on each run. Files which are changed while their hash is computed keep their changes.
+func (db *FileDB) ComputePerceptualHashes(stop <-chan struct{}) (computed int) {
+	type pendingHash struct {
+		fm   *FileMapMutex
+		file File
+	}
+	var pending []pendingHash
+	for _, fm := range []*FileMapMutex{&db.Published, &db.Uploaded} {
+		files := fm.PerformFunc(func(m FileMapDB, mapName string) interface{} {
+			files := make([]File, 0)
+			for _, file := range m {
+				if file.State != Purged && file.PerceptualHash == 0 && perceptualHashable(file) {
+					files = append(files, file)
+				}
+			}
+			return files
+		}).([]File)
+		for _, file := range files {
+			pending = append(pending, pendingHash{fm: fm, file: file})
+		}
+	}
+	if len(pending) == 0 {
+		return 0
+	}
+
+	Info.Logf("computing perceptual hashes of %v images", len(pending))
+	for i, p := range pending {
+		select {
+		case <-stop:
+			Info.Logf("stopped computing perceptual hashes after %v of %v images", i, len(pending))
+			return computed
+		default:
+		}
+
+		hash, err := db.filePerceptualHash(p.file)
+		if err != nil {
+			Input.Log(errors.Wrapf(err, "could not compute perceptual hash of %v", p.file.UUID))
+		} else if hash != 0 {
+			// the file may have been published, deleted or edited while its hash was computed
+			updated := p.fm.Update(p.file.UUID, func(file File) (File, bool) {
+				if file.State != p.file.State || file.Hash != p.file.Hash {
+					return file, false
+				}
+				file.PerceptualHash = hash
+				return file, true
+			})
+			if updated {
+				computed++
+			}
+		}
+
+		if (i+1)%perceptualHashProgressInterval == 0 {
+			Info.Logf("computed perceptual hashes of %v of %v images", i+1, len(pending))
+		}
+	}
+
+	if computed > 0 {
+		db.Checkpoint()
+	}
+	Info.Logf("computed %v perceptual hashes", computed)
+	return computed
+}
@@ -0,0 +1,184 @@
+package memoryshare
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+)
+
+// newTestImage draws a smooth gradient, or a repeating pattern if striped is set.
+func newTestImage(width, height int, striped bool) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint8(127 + 120*math.Sin(float64(x)*9/float64(width)+float64(y)*5/float64(height)))
+			if striped {
+				v = uint8(((y*7 + x*3) % 50) * 5)
+			}
+			img.Set(x, y, color.RGBA{v, v / 2, 255 - v, 255})
+		}
+	}
+	return img
+}
+
+// encodeTestImages encodes a gradient as a PNG, a resized copy of it as a low quality JPEG & a different image as a
+// PNG.
+func encodeTestImages(t *testing.T) (original, resized, different []byte) {
+	t.Helper()
+	var originalBuf, resizedBuf, differentBuf bytes.Buffer
+	if err := png.Encode(&originalBuf, newTestImage(400, 300, false)); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&resizedBuf, newTestImage(200, 150, false), &jpeg.Options{Quality: 60}); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(&differentBuf, newTestImage(400, 300, true)); err != nil {
+		t.Fatal(err)
+	}
+	return originalBuf.Bytes(), resizedBuf.Bytes(), differentBuf.Bytes()
+}
+
+// uploadTestImage writes an image to the db/temp directory of bob & registers it as an upload.
+func uploadTestImage(t *testing.T, db *FileDB, UUID, extension string, content []byte) File {
+	t.Helper()
+	if err := os.MkdirAll(db.dir+"/temp/bob", 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := File{UUID: UUID, Name: UUID, Extension: extension, MediaType: Image, UploaderUsername: "bob", State: Uploaded}
+	if err := ioutil.WriteFile(file.UploadPath(), content, 0666); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if file.Hash, err = GenerateFileHash(file.UploadPath()); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.registerUpload(file); err != nil {
+		t.Fatal(err)
+	}
+	file, _ = db.Uploaded.Get(UUID)
+	return file
+}
+
+func TestPerceptualHash(t *testing.T) {
+	original, resized, different := encodeTestImages(t)
+	originalHash, err := PerceptualHash(bytes.NewReader(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resizedHash, _ := PerceptualHash(bytes.NewReader(resized))
+	differentHash, _ := PerceptualHash(bytes.NewReader(different))
+
+	if distance := hashDistance(originalHash, resizedHash); distance > 6 {
+		t.Fatalf("expected a resized copy to have a similar hash, got a distance of %v", distance)
+	}
+	if distance := hashDistance(originalHash, differentHash); distance < 10 {
+		t.Fatalf("expected a different image to have a dissimilar hash, got a distance of %v", distance)
+	}
+}
+
+func TestNearDuplicates(t *testing.T) {
+	db, _ := newTestFileDB(t, GobBackend)
+	config.NearDuplicateDistance = 6
+	original, resized, different := encodeTestImages(t)
+
+	a := uploadTestImage(t, db, "a", "png", original)
+	if a.PerceptualHash == 0 {
+		t.Fatal("expected the perceptual hash of an upload to be computed")
+	}
+	if err := db.PublishFile("a", MetaData{}, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	c := uploadTestImage(t, db, "c", "png", different)
+	if err := db.PublishFile("c", MetaData{}, "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	b := uploadTestImage(t, db, "b", "jpg", resized)
+	if duplicates := db.NearDuplicates(b); len(duplicates) != 1 || duplicates[0].UUID != "a" {
+		t.Fatalf("expected the resized upload to be a near-duplicate of a, got %v", fileUUIDs(duplicates))
+	}
+	if duplicates := db.NearDuplicates(c); len(duplicates) != 0 {
+		t.Fatalf("expected the different image to have no near-duplicates, got %v", fileUUIDs(duplicates))
+	}
+
+	if err := db.PublishFile("b", MetaData{}, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	report := db.NearDuplicateReport()
+	if len(report) != 1 || len(report[0].Files) != 2 {
+		t.Fatalf("expected a single group of 2 near-duplicates, got %v", ToJSON(report, false))
+	}
+}
+
+func TestComputePerceptualHashes(t *testing.T) {
+	db, _ := newTestFileDB(t, GobBackend)
+	original, _, different := encodeTestImages(t)
+
+	published := uploadTestImage(t, db, "published", "png", original)
+	if err := db.PublishFile(published.UUID, MetaData{Description: "d"}, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	uploaded := uploadTestImage(t, db, "uploaded", "png", different)
+
+	// files stored before perceptual hashing existed have no hash
+	for _, fm := range []*FileMapMutex{&db.Published, &db.Uploaded} {
+		for _, file := range fm.PerformFunc(func(m FileMapDB, mapName string) interface{} {
+			files := make([]File, 0)
+			for _, file := range m {
+				files = append(files, file)
+			}
+			return files
+		}).([]File) {
+			file.PerceptualHash = 0
+			fm.Set(file.UUID, file)
+		}
+	}
+
+	stop := make(chan struct{})
+	close(stop)
+	if computed := db.ComputePerceptualHashes(stop); computed != 0 {
+		t.Fatalf("expected no hashes to be computed once stopped, got %v", computed)
+	}
+
+	if computed := db.ComputePerceptualHashes(make(chan struct{})); computed != 2 {
+		t.Fatalf("expected 2 hashes to be computed, got %v", computed)
+	}
+	if file, _ := db.Published.Get(published.UUID); file.PerceptualHash != published.PerceptualHash ||
+		file.Description != "d" {
+		t.Fatalf("expected the hash of the published image to be computed from its content, got %+v", file)
+	}
+	if file, _ := db.Uploaded.Get(uploaded.UUID); file.PerceptualHash != uploaded.PerceptualHash {
+		t.Fatalf("expected the hash of the uploaded image to be computed, got %v", file.PerceptualHash)
+	}
+	if computed := db.ComputePerceptualHashes(make(chan struct{})); computed != 0 {
+		t.Fatalf("expected computed hashes not to be computed again, got %v", computed)
+	}
+}
+
+func TestFileMapUpdate(t *testing.T) {
+	db, _ := newTestFileDB(t, GobBackend)
+	db.Published.Set("a", File{UUID: "a", State: Published})
+
+	if db.Published.Update("missing", func(file File) (File, bool) { return file, true }) {
+		t.Fatal("expected a missing file not to be updated")
+	}
+	if db.Published.Update("a", func(file File) (File, bool) {
+		file.Description = "skipped"
+		return file, false
+	}) {
+		t.Fatal("expected the update to be skipped")
+	}
+	updated := db.Published.Update("a", func(file File) (File, bool) {
+		file.Description = "updated"
+		return file, true
+	})
+	if file, _ := db.Published.Get("a"); !updated || file.Description != "updated" {
+		t.Fatalf("expected the file to be updated, got %+v", file)
+	}
+}
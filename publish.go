@@ -0,0 +1,178 @@
+package memoryshare
+
+import (
+	"encoding/gob"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// ErrPublishInProgress implies the file is already being published by another request.
+var ErrPublishInProgress = errors.New("file is already being published")
+
+// publishIntent is persisted in db/publishing for the duration of a publish, so that a publish interrupted by a crash
+// can be completed or rolled back at startup.
+type publishIntent struct {
+	File       File // the File as it will be published
+	Actor      string
+	Before     File // the Uploaded File
+	BlobStored bool // the content has been stored in the BlobStore
+}
+
+// publishingDir returns the directory containing the intent of each in-flight publish.
+func (db *FileDB) publishingDir() string {
+	return db.dir + "/publishing/"
+}
+
+// writeIntent persists a publishIntent, replacing any previous intent for the same File.
+func (db *FileDB) writeIntent(intent publishIntent) error {
+	if err := EnsureDirExists(db.publishingDir()); err != nil {
+		return err
+	}
+	return WriteFileAtomic(db.publishingDir()+intent.File.UUID, 0, func(w io.Writer) error {
+		return gob.NewEncoder(w).Encode(&intent)
+	})
+}
+
+// removeIntent deletes the persisted publishIntent of a File.
+func (db *FileDB) removeIntent(fileUUID string) {
+	if err := os.Remove(db.publishingDir() + fileUUID); err != nil && !os.IsNotExist(err) {
+		Critical.Log(errors.Wrap(err, "failed to remove publish intent"))
+	}
+}
+
+// claimPublish marks a File as being published, returning ErrPublishInProgress if it already is.
+func (db *FileDB) claimPublish(fileUUID string) error {
+	db.publishingMu.Lock()
+	defer db.publishingMu.Unlock()
+	if db.publishing == nil {
+		db.publishing = make(map[string]bool)
+	}
+	if db.publishing[fileUUID] {
+		return ErrPublishInProgress
+	}
+	db.publishing[fileUUID] = true
+	return nil
+}
+
+// releasePublish unmarks a File claimed by claimPublish.
+func (db *FileDB) releasePublish(fileUUID string) {
+	db.publishingMu.Lock()
+	defer db.publishingMu.Unlock()
+	delete(db.publishing, fileUUID)
+}
+
+// isPublishing determines whether a File is currently being published.
+func (db *FileDB) isPublishing(fileUUID string) bool {
+	db.publishingMu.Lock()
+	defer db.publishingMu.Unlock()
+	return db.publishing[fileUUID]
+}
+
+// commitPublish records the Create Transaction & moves a File from the Uploaded to the Published map, then removes the
+// temp file & the intent. The content must already be stored in the BlobStore. The Transaction is recorded first so that
+// a published File always has one.
+func (db *FileDB) commitPublish(intent publishIntent) {
+	db.FileTransactions.Create(Create, intent.Actor, intent.Before, intent.File)
+	db.Published.Set(intent.File.UUID, intent.File)
+	db.Uploaded.Delete(intent.File.UUID)
+	db.Checkpoint()
+	db.cleanupPublish(intent)
+}
+
+// cleanupPublish removes the temp file & the intent of a committed publish. The publish is complete at this point, so a
+// temp file which cannot be removed is only reported.
+func (db *FileDB) cleanupPublish(intent publishIntent) {
+	if err := os.Remove(intent.Before.UploadPath()); err != nil && !os.IsNotExist(err) {
+		Critical.Log(errors.Wrap(err, "failed to remove published temp file"))
+	}
+	db.removeIntent(intent.File.UUID)
+}
+
+// recorded determines whether a Transaction of the given type has been recorded against a File.
+func (tm *TransactionMutex) recorded(transactionType TransactionType, fileUUID string) bool {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	for i := len(tm.Transactions) - 1; i >= 0; i-- {
+		if tm.Transactions[i].Type == transactionType && tm.Transactions[i].TargetFileUUID == fileUUID {
+			return true
+		}
+	}
+	return false
+}
+
+// recoverPublishes completes or rolls back each publish which was interrupted before its intent was removed. A publish
+// is completed if its content was stored or its File was already published, otherwise it is rolled back & the upload is
+// left in place to be published again.
+func (db *FileDB) recoverPublishes() error {
+	intentFiles, err := ioutil.ReadDir(db.publishingDir())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to read publishing dir")
+	}
+
+	for _, intentFile := range intentFiles {
+		path := filepath.Join(db.publishingDir(), intentFile.Name())
+		// an intent which was never renamed into place was never acted upon
+		if filepath.Ext(intentFile.Name()) != "" {
+			os.Remove(path)
+			continue
+		}
+
+		var intent publishIntent
+		f, err := os.Open(path)
+		if err != nil {
+			return errors.Wrap(err, "failed to open publish intent")
+		}
+		err = gob.NewDecoder(f).Decode(&intent)
+		f.Close()
+		if err != nil {
+			return errors.Wrapf(err, "failed to decode publish intent %v", intentFile.Name())
+		}
+
+		fileUUID := intent.File.UUID
+		_, published := db.Published.Get(fileUUID)
+		_, uploaded := db.Uploaded.Get(fileUUID)
+
+		if !published && !(uploaded && intent.BlobStored) {
+			db.rollbackPublish(intent)
+			Info.Log("rolled back interrupted publish of " + fileUUID)
+			continue
+		}
+
+		// complete each step of commitPublish which had not been applied
+		if !db.FileTransactions.recorded(Create, fileUUID) {
+			db.FileTransactions.Create(Create, intent.Actor, intent.Before, intent.File)
+		}
+		if !published {
+			db.Published.Set(fileUUID, intent.File)
+		}
+		if uploaded {
+			db.Uploaded.Delete(fileUUID)
+		}
+		db.Checkpoint()
+		db.cleanupPublish(intent)
+		Info.Log("completed interrupted publish of " + fileUUID)
+	}
+	return nil
+}
+
+// rollbackPublish removes content which an interrupted publish may have stored, then removes its intent. Duplicate
+// uploads are rejected, so content which no published File references can only have been stored by this publish.
+func (db *FileDB) rollbackPublish(intent publishIntent) {
+	referenced := false
+	for _, file := range db.Published.GetAllByHash(intent.File.Hash) {
+		referenced = referenced || file.State != Purged
+	}
+	if stored, _ := db.blobs.Exists(intent.File.Hash); stored && !referenced {
+		if err := db.blobs.Delete(intent.File.Hash); err != nil {
+			Critical.Log(errors.Wrap(err, "failed to remove content of rolled back publish"))
+		}
+	}
+	db.removeIntent(intent.File.UUID)
+}
@@ -0,0 +1,133 @@
+package memoryshare
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// uploadTestFile writes content to the db/temp directory of bob & registers it as an Uploaded File.
+func uploadTestFile(t *testing.T, db *FileDB, UUID, content string) File {
+	t.Helper()
+	if err := os.MkdirAll(db.dir+"/temp/bob", 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := File{UUID: UUID, Name: UUID, Extension: "txt", UploaderUsername: "bob", State: Uploaded, MediaType: Text}
+	if err := ioutil.WriteFile(file.UploadPath(), []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := GenerateFileHash(file.UploadPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Hash, file.Size = hash, int64(len(content))
+	db.Uploaded.Set(UUID, file)
+	return file
+}
+
+func TestPublishFile(t *testing.T) {
+	db, _ := newTestFileDB(t, GobBackend)
+
+	a := uploadTestFile(t, db, "a", "aaa")
+	if err := db.PublishFile("a", MetaData{Description: "d"}, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := db.Uploaded.Get("a"); ok {
+		t.Fatal("expected the published file to no longer be uploaded")
+	}
+	if exists, _ := FileOrDirExists(a.UploadPath()); exists {
+		t.Fatal("expected the uploaded content to be removed once published")
+	}
+	if file, ok := db.Published.Get("a"); !ok || file.State != Published || file.Description != "d" {
+		t.Fatalf("expected a to be published, got %+v", file)
+	}
+
+	// content which no longer matches its hash is not published, & the upload is kept
+	b := uploadTestFile(t, db, "b", "bbb")
+	if err := ioutil.WriteFile(b.UploadPath(), []byte("xxx"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PublishFile("b", MetaData{}, "bob"); err == nil {
+		t.Fatal("expected corrupt content not to be published")
+	}
+	if _, ok := db.Uploaded.Get("b"); !ok {
+		t.Fatal("expected the upload to be kept after a failed publish")
+	}
+	if exists, _ := FileOrDirExists(b.UploadPath()); !exists {
+		t.Fatal("expected the uploaded content to be kept after a failed publish")
+	}
+
+	// an upload being published can be neither published nor deleted concurrently
+	c := uploadTestFile(t, db, "c", "ccc")
+	if err := db.claimPublish(c.UUID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PublishFile(c.UUID, MetaData{}, "bob"); err != ErrPublishInProgress {
+		t.Fatalf("expected %v, got %v", ErrPublishInProgress, err)
+	}
+	if err := db.DeleteFile(c.UUID, "bob"); err != ErrPublishInProgress {
+		t.Fatalf("expected %v, got %v", ErrPublishInProgress, err)
+	}
+	db.releasePublish(c.UUID)
+	if err := db.PublishFile(c.UUID, MetaData{}, "bob"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublishIntentRecovery(t *testing.T) {
+	dir := newTestConfig(t, GobBackend)
+	db, err := NewFileDB(dir + "/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the content was stored before the crash, so publishing is rolled forward
+	a := uploadTestFile(t, db, "a", "aaa")
+	published := a
+	published.State = Published
+	if err := db.blobs.Put(a.Hash, a.UploadPath()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.writeIntent(publishIntent{File: published, Actor: "bob", Before: a, BlobStored: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the content may not have been stored completely, so publishing is rolled back
+	b := uploadTestFile(t, db, "b", "bbb")
+	published = b
+	published.State = Published
+	if err := db.blobs.Put(b.Hash, b.UploadPath()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.writeIntent(publishIntent{File: published, Actor: "bob", Before: b}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = NewFileDB(dir + "/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if file, ok := db.Published.Get("a"); !ok || file.State != Published {
+		t.Fatal("expected a to be published by recovery")
+	}
+	if !db.FileTransactions.recorded(Create, "a") {
+		t.Fatal("expected the recovered publish to be recorded")
+	}
+	if _, ok := db.Uploaded.Get("b"); !ok {
+		t.Fatal("expected b to remain uploaded")
+	}
+	if exists, _ := db.blobs.Exists(b.Hash); exists {
+		t.Fatal("expected the content stored by the rolled back publish to be released")
+	}
+	if files, _ := ioutil.ReadDir(db.dir + "/publishing"); len(files) != 0 {
+		t.Fatalf("expected every publish intent to be resolved, got %v", len(files))
+	}
+	if err := db.PublishFile("b", MetaData{}, "bob"); err != nil {
+		t.Fatal(err)
+	}
+}
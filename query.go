@@ -0,0 +1,489 @@
+package memoryshare
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// QueryError is a structured error describing why a search query could not be parsed.
+type QueryError struct {
+	Message  string `json:"message"`
+	Position int    `json:"position"` // character offset into the query at which the error was found
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("%v at position %d", e.Message, e.Position)
+}
+
+// queryFields maps each field name (and its aliases) which can prefix a query term to the field it searches.
+var queryFields = map[string]string{
+	"desc":        "desc",
+	"description": "desc",
+	"tag":         "tag",
+	"tags":        "tag",
+	"person":      "person",
+	"people":      "person",
+	"type":        "type",
+	"date":        "date",
+	"uploader":    "uploader",
+	"ext":         "ext",
+	"extension":   "ext",
+}
+
+// queryNode is a node of a parsed search query which determines whether a File matches.
+type queryNode interface {
+	match(file File) bool
+}
+
+type queryAnd []queryNode
+
+func (n queryAnd) match(file File) bool {
+	for _, node := range n {
+		if !node.match(file) {
+			return false
+		}
+	}
+	return true
+}
+
+type queryOr []queryNode
+
+func (n queryOr) match(file File) bool {
+	for _, node := range n {
+		if node.match(file) {
+			return true
+		}
+	}
+	return false
+}
+
+type queryNot struct {
+	node queryNode
+}
+
+func (n queryNot) match(file File) bool {
+	return !n.node.match(file)
+}
+
+// queryTerm matches Files whose field contains the value. Descriptions match if they contain the value anywhere, whereas
+// tags, people, media types, uploaders & extensions must match the value exactly. Comparisons are case insensitive.
+type queryTerm struct {
+	field string
+	value string
+}
+
+func (n queryTerm) match(file File) bool {
+	switch n.field {
+	case "desc":
+		return strings.Contains(strings.ToLower(file.Description), n.value)
+	case "tag":
+		return containsFold(file.Tags, n.value)
+	case "person":
+		return containsFold(file.People, n.value)
+	case "type":
+		return strings.EqualFold(file.MediaType, n.value)
+	case "uploader":
+		return strings.EqualFold(file.UploaderUsername, n.value)
+	case "ext":
+		return strings.EqualFold(file.Extension, strings.TrimPrefix(n.value, "."))
+	}
+	return false
+}
+
+// queryText matches Files which contain every full-text term (see fileTerms).
+type queryText []string
+
+func (n queryText) match(file File) bool {
+	terms := fileTerms(file)
+	for _, term := range n {
+		if terms[term] == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// queryDate matches Files dated within a range.
+type queryDate struct {
+	from int64 // minimum timestamp (inclusive)
+	to   int64 // maximum timestamp (exclusive), 0 for no maximum
+}
+
+func (n queryDate) match(file File) bool {
+	date := indexDate(file)
+	return date >= n.from && (n.to == 0 || date < n.to)
+}
+
+// containsFold determines whether a list contains an item, ignoring case.
+func containsFold(list []string, item string) bool {
+	for _, listItem := range list {
+		if strings.EqualFold(listItem, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// Query is a parsed search query. The grammar is:
+//
+//	query   = or
+//	or      = and { "OR" and }
+//	and     = unary { ["AND"] unary }
+//	unary   = "-" unary | "(" or ")" | term
+//	term    = [field ":"] (word | "quoted phrase")
+//
+// Words without a field are matched by their stem against the description, tags, people & file name (see TextSearch),
+// whereas quoted phrases without a field search descriptions. The fields are desc, tag, person, type, uploader, ext &
+// date. A date is a year, month or day (i.e. 2017, 2017-06 or 2017-06-15) or a range of them separated by "..", either
+// side of which may be omitted (i.e. date:2017-06..2017-08 or date:..2016).
+type Query struct {
+	root queryNode
+}
+
+// ParseQuery parses a search query, returning a *QueryError if it is invalid. An empty query matches every File.
+func ParseQuery(query string) (*Query, error) {
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return &Query{}, nil
+	}
+
+	p := &queryParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, &QueryError{fmt.Sprintf("unexpected %q", tok.text), tok.pos}
+	}
+	return &Query{root: root}, nil
+}
+
+// Matches determines whether a File matches the query.
+func (q *Query) Matches(file File) bool {
+	return q == nil || q.root == nil || q.root.match(file)
+}
+
+// criteria returns the index selection which every File matching the query must satisfy, i.e. the tags, people & date
+// range of the terms which are required at the top level of the query.
+func (q *Query) criteria() (criteria FileQuery) {
+	if q == nil || q.root == nil {
+		return
+	}
+	required := queryAnd{q.root}
+	if and, ok := q.root.(queryAnd); ok {
+		required = and
+	}
+
+	for _, node := range required {
+		switch n := node.(type) {
+		case queryTerm:
+			if n.field == "tag" {
+				criteria.Tags = append(criteria.Tags, n.value)
+			} else if n.field == "person" {
+				criteria.People = append(criteria.People, n.value)
+			}
+		case queryDate:
+			criteria = criteria.restrictDates(n.from, n.to)
+		}
+	}
+	return
+}
+
+// restrictDates narrows the date range of a FileQuery to within the given range.
+func (fq FileQuery) restrictDates(from, to int64) FileQuery {
+	if from > fq.From {
+		fq.From = from
+	}
+	if to != 0 && (fq.To == 0 || to < fq.To) {
+		fq.To = to
+	}
+	return fq
+}
+
+type queryTokenKind int
+
+const (
+	tokenEOF queryTokenKind = iota
+	tokenTerm
+	tokenOr
+	tokenAnd
+	tokenNot
+	tokenOpen
+	tokenClose
+)
+
+type queryToken struct {
+	kind   queryTokenKind
+	pos    int
+	text   string
+	field  string // term field, empty for a full-text term
+	value  string // term value
+	phrase bool   // the value is a quoted phrase
+}
+
+// lexQuery splits a query into tokens.
+func lexQuery(query string) (tokens []queryToken, err error) {
+	runes := []rune(query)
+	isTermEnd := func(r rune) bool {
+		return unicode.IsSpace(r) || r == '(' || r == ')' || r == '"'
+	}
+
+	// readPhrase reads a quoted phrase starting at the opening quote
+	readPhrase := func(start int) (string, int, error) {
+		for i := start + 1; i < len(runes); i++ {
+			if runes[i] == '"' {
+				return string(runes[start+1 : i]), i + 1, nil
+			}
+		}
+		return "", 0, &QueryError{"unterminated quoted phrase", start}
+	}
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, queryToken{kind: tokenOpen, pos: i, text: "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, queryToken{kind: tokenClose, pos: i, text: ")"})
+			i++
+		case r == '-':
+			tokens = append(tokens, queryToken{kind: tokenNot, pos: i, text: "-"})
+			i++
+		case r == '"':
+			phrase, end, err := readPhrase(i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, queryToken{kind: tokenTerm, pos: i, text: string(runes[i:end]), value: phrase, phrase: true})
+			i = end
+		default:
+			start := i
+			for i < len(runes) && !isTermEnd(runes[i]) {
+				i++
+			}
+			word := string(runes[start:i])
+
+			if word == "OR" {
+				tokens = append(tokens, queryToken{kind: tokenOr, pos: start, text: word})
+				continue
+			}
+			if word == "AND" {
+				tokens = append(tokens, queryToken{kind: tokenAnd, pos: start, text: word})
+				continue
+			}
+
+			token := queryToken{kind: tokenTerm, pos: start, value: word}
+			if colon := strings.IndexRune(word, ':'); colon > 0 {
+				field, ok := queryFields[strings.ToLower(word[:colon])]
+				if !ok {
+					return nil, &QueryError{fmt.Sprintf("unknown field %q", word[:colon]), start}
+				}
+				token.field, token.value = field, word[colon+1:]
+
+				// the value of a field may be a quoted phrase
+				if token.value == "" && i < len(runes) && runes[i] == '"' {
+					if token.value, i, err = readPhrase(i); err != nil {
+						return nil, err
+					}
+				}
+				if token.value == "" {
+					return nil, &QueryError{fmt.Sprintf("missing value for field %q", word[:colon]), start}
+				}
+			}
+			token.text = string(runes[start:i])
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+// queryParser is a recursive descent parser of query tokens.
+type queryParser struct {
+	tokens []queryToken
+	next   int
+}
+
+func (p *queryParser) peek() queryToken {
+	if p.next >= len(p.tokens) {
+		end := 0
+		if len(p.tokens) > 0 {
+			last := p.tokens[len(p.tokens)-1]
+			end = last.pos + len([]rune(last.text))
+		}
+		return queryToken{kind: tokenEOF, pos: end}
+	}
+	return p.tokens[p.next]
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	var nodes queryOr
+	for {
+		node, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+
+		if p.peek().kind != tokenOr {
+			break
+		}
+		p.next++
+	}
+
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	var nodes queryAnd
+	for {
+		tok := p.peek()
+		if tok.kind == tokenEOF || tok.kind == tokenClose || tok.kind == tokenOr {
+			break
+		}
+		if tok.kind == tokenAnd {
+			if len(nodes) == 0 {
+				return nil, &QueryError{"unexpected \"AND\"", tok.pos}
+			}
+			p.next++
+		}
+
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	if len(nodes) == 0 {
+		tok := p.peek()
+		if tok.kind == tokenEOF {
+			return nil, &QueryError{"expected a search term", tok.pos}
+		}
+		return nil, &QueryError{fmt.Sprintf("unexpected %q", tok.text), tok.pos}
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case tokenNot:
+		p.next++
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return queryNot{node}, nil
+
+	case tokenOpen:
+		p.next++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != tokenClose {
+			return nil, &QueryError{"missing closing parenthesis", tok.pos}
+		}
+		p.next++
+		return node, nil
+
+	case tokenTerm:
+		p.next++
+		return newQueryTerm(tok)
+
+	case tokenEOF:
+		return nil, &QueryError{"expected a search term", tok.pos}
+	}
+	return nil, &QueryError{fmt.Sprintf("unexpected %q", tok.text), tok.pos}
+}
+
+// newQueryTerm creates the node which matches a term token.
+func newQueryTerm(tok queryToken) (queryNode, error) {
+	switch tok.field {
+	case "":
+		// words consisting only of stop words are matched against descriptions instead
+		if terms := textTerms(tok.value); !tok.phrase && len(terms) > 0 {
+			return queryText(terms), nil
+		}
+		return queryTerm{field: "desc", value: strings.ToLower(tok.value)}, nil
+	case "date":
+		from, to, err := parseDateRange(tok.value)
+		if err != nil {
+			return nil, &QueryError{err.Error(), tok.pos}
+		}
+		return queryDate{from: from, to: to}, nil
+	}
+	return queryTerm{field: tok.field, value: strings.ToLower(tok.value)}, nil
+}
+
+// queryDateLayouts are the layouts of a date in a query, from least to most precise.
+var queryDateLayouts = []string{"2006", "2006-01", "2006-01-02"}
+
+// parseDateRange parses a year, month or day, or a range of them separated by "..", into the timestamp range from the
+// start of the first period (inclusive) to the end of the last (exclusive). Either side of a range may be omitted.
+func parseDateRange(value string) (from, to int64, err error) {
+	first, last := value, value
+	if parts := strings.SplitN(value, "..", 2); len(parts) == 2 {
+		first, last = parts[0], parts[1]
+		if first == "" && last == "" {
+			return 0, 0, errors.Errorf("invalid date range %q", value)
+		}
+	}
+
+	if first != "" {
+		start, _, err := parseQueryDate(first)
+		if err != nil {
+			return 0, 0, err
+		}
+		from = start.UnixNano()
+	}
+	if last != "" {
+		_, end, err := parseQueryDate(last)
+		if err != nil {
+			return 0, 0, err
+		}
+		to = end.UnixNano()
+	}
+	if to != 0 && from >= to {
+		return 0, 0, errors.Errorf("date range %q ends before it starts", value)
+	}
+	return from, to, nil
+}
+
+// parseQueryDate parses a year, month or day in local time, returning the start of the period & the start of the next.
+func parseQueryDate(value string) (start, end time.Time, err error) {
+	for i, layout := range queryDateLayouts {
+		if len(value) != len(layout) {
+			continue
+		}
+		if start, err = time.ParseInLocation(layout, value, time.Local); err != nil {
+			break
+		}
+		switch i {
+		case 0:
+			end = start.AddDate(1, 0, 0)
+		case 1:
+			end = start.AddDate(0, 1, 0)
+		default:
+			end = start.AddDate(0, 0, 1)
+		}
+		return start, end, nil
+	}
+	return start, end, errors.Errorf("invalid date %q, expected YYYY, YYYY-MM or YYYY-MM-DD", value)
+}
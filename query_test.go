@@ -0,0 +1,69 @@
+package memoryshare
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	db, _ := newTestFileDB(t, GobBackend)
+	publish := func(UUID, description, date, mediaType string, tags, people []string) {
+		published, err := time.ParseInLocation("2006-01-02", date, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Published.Set(UUID, File{UUID: UUID, State: Published, PublishedTimestamp: published.UnixNano(),
+			MetaData: MetaData{Description: description, MediaType: mediaType, Tags: tags, People: people}})
+	}
+	publish("a", "Day at the beach in summer", "2017-06-10", Image, []string{"beach", "summer"}, []string{"bob"})
+	publish("b", "Skiing trip", "2017-12-01", Video, []string{"snow"}, []string{"bob", "eve"})
+	publish("c", "New York beach", "2017-08-31", Image, []string{"beach", "new york"}, []string{"eve"})
+	publish("d", "Christmas dinner", "2016-12-25", Text, []string{"xmas"}, []string{"al"})
+
+	cases := []struct {
+		query    string
+		expected string // sorted UUIDs of the matching files, or the error
+	}{
+		{"", "a,b,c,d"},
+		{"beach", "a,c"},
+		{"tag:beach -person:eve", "a"},
+		{`tag:"new york"`, "c"},
+		{`"at the beach"`, "a"},
+		{"tag:snow OR tag:xmas", "b,d"},
+		{"(tag:snow OR tag:xmas) person:bob", "b"},
+		{"date:2017-06..2017-08", "a,c"},
+		{"date:2017", "a,b,c"},
+		{"date:..2016", "d"},
+		{"date:2017-12.. OR type:text", "b,d"},
+		{"-(tag:beach OR type:video)", "d"},
+		{"beach AND person:bob", "a"},
+		{"Tag:BEACH", "a,c"},
+		{"foo:bar", `unknown field "foo" at position 0`},
+		{"tag:", `missing value for field "tag" at position 0`},
+		{`"open`, "unterminated quoted phrase at position 0"},
+		{"(a OR b", "missing closing parenthesis at position 0"},
+		{"a)", `unexpected ")" at position 1`},
+		{"a OR", "expected a search term at position 4"},
+		{"OR a", `unexpected "OR" at position 0`},
+		{"a -", "expected a search term at position 3"},
+		{"date:2017-13", `invalid date "2017-13", expected YYYY, YYYY-MM or YYYY-MM-DD at position 0`},
+		{"date:2018..2017", `date range "2018..2017" ends before it starts at position 0`},
+	}
+	for _, c := range cases {
+		query, err := ParseQuery(c.query)
+		if err != nil {
+			if err.Error() != c.expected {
+				t.Errorf("%q: expected %v, got error %v", c.query, c.expected, err)
+			}
+			continue
+		}
+
+		UUIDs := strings.Split(resultUUIDs(db.Search(SearchRequest{query: query})), ",")
+		sort.Strings(UUIDs)
+		if got := strings.Join(UUIDs, ","); got != c.expected {
+			t.Errorf("%q: expected %v, got %v", c.query, c.expected, got)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package memoryshare
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ErrQuotaExceeded implies an upload would take a user over their storage quota.
+var ErrQuotaExceeded = errors.New("upload would exceed the storage quota of the user")
+
+// ErrInvalidQuota implies a quota limit was negative.
+var ErrInvalidQuota = errors.New("quota limits must not be negative")
+
+// Quota limits the storage used by the uploaded & published memories of a user. A limit of 0 implies unlimited.
+type Quota struct {
+	Bytes int64 `json:"bytes"`
+	Files int   `json:"files"`
+}
+
+// Usage is the storage used by the memories of a user.
+type Usage struct {
+	Bytes int64 `json:"bytes"`
+	Files int   `json:"files"`
+}
+
+// EffectiveQuota returns the quota overridden for the User by an admin, or otherwise the default quota of their UserType.
+// Guests cannot upload, so have no storage.
+func (u User) EffectiveQuota() Quota {
+	if u.QuotaOverride != nil {
+		return *u.QuotaOverride
+	}
+
+	switch u.Type {
+	case Standard:
+		return Quota{Bytes: int64(config.StandardQuotaSize) * 1024 * 1024, Files: config.StandardQuotaFiles}
+	case Admin, SuperAdmin:
+		return Quota{Bytes: int64(config.AdminQuotaSize) * 1024 * 1024, Files: config.AdminQuotaFiles}
+	}
+	return Quota{Bytes: -1, Files: -1}
+}
+
+// allows determines whether a new file of the given size can be stored on top of the current usage.
+func (q Quota) allows(usage Usage, size int64) bool {
+	if q.Bytes < 0 || q.Files < 0 {
+		return false
+	}
+	if q.Bytes > 0 && usage.Bytes+size > q.Bytes {
+		return false
+	}
+	if q.Files > 0 && usage.Files+1 > q.Files {
+		return false
+	}
+	return true
+}
+
+// Usage returns the storage used by the uploaded & published memories of a user. Deleted memories count towards the
+// usage until their content is purged from the trash.
+func (db *FileDB) Usage(username string) Usage {
+	uploaded := db.Uploaded.Usage(username)
+	published := db.Published.Usage(username)
+	return Usage{Bytes: uploaded.Bytes + published.Bytes, Files: uploaded.Files + published.Files}
+}
+
+// checkQuota returns ErrQuotaExceeded if a new file of the given size would take the user over their quota.
+func (db *FileDB) checkQuota(user User, size int64) error {
+	if !user.EffectiveQuota().allows(db.Usage(user.Username), size) {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// UserQuota describes the storage usage & quota of a user.
+type UserQuota struct {
+	Username string   `json:"username"`
+	Type     UserType `json:"type"`
+	Usage    Usage    `json:"usage"`
+	Quota    Quota    `json:"quota"`
+	Override bool     `json:"override"` // the quota has been overridden by an admin
+}
+
+// GetQuotas returns the storage usage & quota of each user, most recently created first.
+func (db *FileDB) GetQuotas(userDB *UserDB) []UserQuota {
+	users := userDB.GetUsers()
+	quotas := make([]UserQuota, 0, len(users))
+	for _, user := range users {
+		quotas = append(quotas, UserQuota{
+			Username: user.Username,
+			Type:     user.Type,
+			Usage:    db.Usage(user.Username),
+			Quota:    user.EffectiveQuota(),
+			Override: user.QuotaOverride != nil,
+		})
+	}
+	return quotas
+}
+
+// SetQuotaOverride overrides the quota of a user, or restores the default quota of their UserType if quota is nil.
+func (db *UserDB) SetQuotaOverride(username string, quota *Quota) error {
+	user, ok := db.Users.Get(username)
+	if !ok {
+		return ErrUserNotFound
+	}
+	if quota != nil && (quota.Bytes < 0 || quota.Files < 0) {
+		return ErrInvalidQuota
+	}
+
+	user.QuotaOverride = quota
+	db.Users.Set(username, user)
+	db.Checkpoint()
+	return nil
+}
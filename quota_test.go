@@ -0,0 +1,57 @@
+package memoryshare
+
+import (
+	"testing"
+)
+
+func TestQuota(t *testing.T) {
+	db, dir := newTestFileDB(t, GobBackend)
+	config.StandardQuotaSize, config.StandardQuotaFiles = 1, 2
+	bob := User{Username: "bob", Type: Standard}
+
+	db.Uploaded.Set("a", File{UUID: "a", Hash: "hash-a", UploaderUsername: "bob", Size: 1000, State: Uploaded})
+	path, hash := writeTestBlob(t, dir, "published")
+	if err := db.blobs.Put(hash, path); err != nil {
+		t.Fatal(err)
+	}
+	db.Published.Set("b", File{UUID: "b", Hash: hash, UploaderUsername: "bob", Size: 500, State: Published})
+	if usage := db.Usage("bob"); usage.Bytes != 1500 || usage.Files != 2 {
+		t.Fatalf("expected uploaded & published files to count, got %+v", usage)
+	}
+	if err := db.checkQuota(bob, 1); err != ErrQuotaExceeded {
+		t.Fatalf("expected the file limit to be exceeded, got %v", err)
+	}
+
+	// deleted files count until they are purged from the trash
+	if err := db.DeleteFile("b", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if usage := db.Usage("bob"); usage.Files != 2 {
+		t.Fatalf("expected files in the trash to count, got %+v", usage)
+	}
+	if _, err := db.PurgeTrash(0); err != nil {
+		t.Fatal(err)
+	}
+	if usage := db.Usage("bob"); usage.Bytes != 1000 || usage.Files != 1 {
+		t.Fatalf("expected purged files not to count, got %+v", usage)
+	}
+
+	if err := db.checkQuota(bob, 1024*1024); err != ErrQuotaExceeded {
+		t.Fatalf("expected the size limit to be exceeded, got %v", err)
+	}
+	if err := db.checkQuota(bob, 1000); err != nil {
+		t.Fatalf("expected an upload within the quota to be allowed, got %v", err)
+	}
+	if _, err := db.CreateResumableUpload(bob, "a.txt", 1024*1024, 1<<30); err != ErrQuotaExceeded {
+		t.Fatalf("expected resumable uploads to be limited by the quota, got %v", err)
+	}
+
+	// a zero override is unlimited
+	bob.QuotaOverride = &Quota{}
+	if err := db.checkQuota(bob, 1<<40); err != nil {
+		t.Fatalf("expected an unlimited quota override, got %v", err)
+	}
+	if err := db.checkQuota(User{Username: "guest", Type: Guest}, 1); err != ErrQuotaExceeded {
+		t.Fatalf("expected guests to be unable to upload, got %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package memoryshare
+
+import (
+	"sort"
+	"strings"
+)
+
+// Weights of a search hit in each field of a File, used to score search results by relevance. A full-text term is
+// weighted by the field it occurs in, whereas a tag or person hit is an exact match of a tag or person searched for.
+const (
+	descriptionWeight = 1.0
+	tagWeight         = 3.0
+	personWeight      = 2.0
+	fileNameWeight    = 0.5
+)
+
+// The orders which search results can be sorted by.
+const (
+	// SortRelevance orders by relevance score, most relevant first.
+	SortRelevance = "relevance"
+	// SortNewest orders by date, newest first.
+	SortNewest = "newest"
+	// SortOldest orders by date, oldest first.
+	SortOldest = "oldest"
+	// SortLargest orders by file size, largest first.
+	SortLargest = "largest"
+	// SortSmallest orders by file size, smallest first.
+	SortSmallest = "smallest"
+	// SortName orders alphabetically by original file name.
+	SortName = "name"
+	// SortFavourites orders by the number of users who have favourited a memory, most favourited first.
+	SortFavourites = "favourites"
+)
+
+// searchSorts are the valid search result orders.
+var searchSorts = map[string]bool{
+	SortRelevance: true, SortNewest: true, SortOldest: true, SortLargest: true, SortSmallest: true, SortName: true,
+	SortFavourites: true,
+}
+
+// ScoredFile is a File search result along with its relevance score.
+type ScoredFile struct {
+	File
+	Score float64 `json:"score"`
+	key   searchKey
+}
+
+// searchScoring holds the terms, tags & people searched for which relevance is scored against.
+type searchScoring struct {
+	terms  []string // stemmed full-text terms
+	tags   []string
+	people []string
+}
+
+// newSearchScoring collects the terms, tags & people searched for by a SearchRequest, including those which a File
+// must (or may) match in the query language. Terms which are negated by the query are not scored.
+func newSearchScoring(searchReq SearchRequest) searchScoring {
+	scoring := searchScoring{
+		terms:  textTerms(searchReq.description),
+		tags:   searchReq.tags,
+		people: searchReq.people,
+	}
+	if searchReq.query != nil {
+		scoring.collect(searchReq.query.root)
+	}
+	return scoring
+}
+
+// collect adds the terms, tags & people of a query node which are not negated.
+func (s *searchScoring) collect(node queryNode) {
+	switch n := node.(type) {
+	case queryAnd:
+		for _, child := range n {
+			s.collect(child)
+		}
+	case queryOr:
+		for _, child := range n {
+			s.collect(child)
+		}
+	case queryText:
+		s.terms = append(s.terms, n...)
+	case queryTerm:
+		if n.field == "tag" {
+			s.tags = append(s.tags, n.value)
+		} else if n.field == "person" {
+			s.people = append(s.people, n.value)
+		}
+	}
+}
+
+// score returns the relevance of a File: the weighted occurrences of each full-text term, plus a weighted hit for each
+// tag & person searched for which the File has.
+func (s searchScoring) score(file File) (score float64) {
+	if len(s.terms) > 0 {
+		terms := fileTerms(file)
+		for _, term := range s.terms {
+			score += terms[term]
+		}
+	}
+	for _, tag := range s.tags {
+		if containsFold(file.Tags, tag) {
+			score += tagWeight
+		}
+	}
+	for _, person := range s.people {
+		if containsFold(file.People, person) {
+			score += personWeight
+		}
+	}
+	return score
+}
+
+// searchKey is the position of a search result in each of the search orders. Results which are equal in the chosen
+// order are ordered newest first & then by UUID, so that every result has a distinct position which pagination cursors
+// can refer to.
+type searchKey struct {
+	Score      float64 `json:"score,omitempty"`
+	Date       int64   `json:"date"`
+	Size       int64   `json:"size,omitempty"`
+	Name       string  `json:"name,omitempty"` // lower case file name including extension
+	Favourites int     `json:"favourites,omitempty"`
+	UUID       string  `json:"uuid"`
+}
+
+// newSearchKey returns the position of a search result in each of the search orders.
+func newSearchKey(result ScoredFile, favourites map[string]int) searchKey {
+	return searchKey{
+		Score:      result.Score,
+		Date:       indexDate(result.File),
+		Size:       result.Size,
+		Name:       strings.ToLower(result.Name + "." + result.Extension),
+		Favourites: favourites[result.UUID],
+		UUID:       result.UUID,
+	}
+}
+
+// before determines whether the key k is ordered before the key other in the given search order.
+func (k searchKey) before(other searchKey, order string) bool {
+	switch order {
+	case SortNewest:
+		// ordered by the tie break
+	case SortOldest:
+		if k.Date != other.Date {
+			return k.Date < other.Date
+		}
+	case SortLargest:
+		if k.Size != other.Size {
+			return k.Size > other.Size
+		}
+	case SortSmallest:
+		if k.Size != other.Size {
+			return k.Size < other.Size
+		}
+	case SortName:
+		if k.Name != other.Name {
+			return k.Name < other.Name
+		}
+	case SortFavourites:
+		if k.Favourites != other.Favourites {
+			return k.Favourites > other.Favourites
+		}
+	default:
+		if k.Score != other.Score {
+			return k.Score > other.Score
+		}
+	}
+
+	if k.Date != other.Date {
+		return k.Date > other.Date
+	}
+	return k.UUID < other.UUID
+}
+
+// sortResults orders search results, first setting the searchKey of each result.
+func sortResults(results []ScoredFile, order string, favourites map[string]int) {
+	for i := range results {
+		results[i].key = newSearchKey(results[i], favourites)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].key.before(results[j].key, order)
+	})
+}
@@ -0,0 +1,68 @@
+package memoryshare
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// scoredUUIDs returns the UUIDs & scores of the Files in a search result in order, i.e. "a:1,b:0".
+func scoredUUIDs(result FileSearchResult) string {
+	scored := make([]string, 0, len(result.Files))
+	for _, file := range result.Files {
+		scored = append(scored, fmt.Sprintf("%v:%v", file.UUID, file.Score))
+	}
+	return strings.Join(scored, ",")
+}
+
+func TestSearchRanking(t *testing.T) {
+	db, _ := newTestFileDB(t, GobBackend)
+	publish := func(UUID, name, description string, timestamp, size int64, tags, people []string) {
+		db.Published.Set(UUID, File{UUID: UUID, Name: name, Extension: "jpg", Size: size, State: Published,
+			PublishedTimestamp: timestamp, MetaData: MetaData{Description: description, Tags: tags, People: people}})
+	}
+	publish("a", "zeta", "beach day", 1, 30, []string{"summer"}, []string{"bob"})
+	publish("b", "alpha", "a walk", 2, 10, []string{"beach"}, []string{"eve"})
+	publish("c", "Mid", "beach beach beach", 3, 20, []string{"snow"}, []string{"bob"})
+
+	// equal scores are ordered newest first
+	if got := scoredUUIDs(db.Search(SearchRequest{description: "beach", sort: SortRelevance})); got != "c:3,b:3,a:1" {
+		t.Errorf("expected text matches & tags to be scored, got %v", got)
+	}
+	query, _ := ParseQuery("person:bob OR tag:beach")
+	if got := scoredUUIDs(db.Search(SearchRequest{query: query, sort: SortRelevance})); got != "b:3,c:2,a:2" {
+		t.Errorf("expected tag matches to outscore people matches, got %v", got)
+	}
+	query, _ = ParseQuery("-person:bob")
+	if got := scoredUUIDs(db.Search(SearchRequest{query: query, sort: SortRelevance})); got != "b:0" {
+		t.Errorf("expected excluded terms not to be scored, got %v", got)
+	}
+
+	favourites := map[string]int{"a": 2, "c": 1}
+	orders := []struct {
+		sort     string
+		expected string
+	}{
+		{SortNewest, "c:0,b:0,a:0"},
+		{SortOldest, "a:0,b:0,c:0"},
+		{SortLargest, "a:0,c:0,b:0"},
+		{SortSmallest, "b:0,c:0,a:0"},
+		{SortName, "b:0,c:0,a:0"},
+		{SortFavourites, "a:0,c:0,b:0"},
+	}
+	for _, order := range orders {
+		if got := scoredUUIDs(db.Search(SearchRequest{sort: order.sort, favourites: favourites})); got != order.expected {
+			t.Errorf("%v: expected %v, got %v", order.sort, order.expected, got)
+		}
+	}
+
+	// the score is included in search results
+	result, err := json.Marshal(db.Search(SearchRequest{description: "walk"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(result), `"score":1`) || !strings.Contains(string(result), `"UUID":"b"`) {
+		t.Errorf("expected b to be returned with its score, got %s", result)
+	}
+}
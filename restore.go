@@ -0,0 +1,142 @@
+package memoryshare
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RestoreReport describes the memories changed by restoring the Published FileDB to a point in time.
+type RestoreReport struct {
+	TransactionCount int            `json:"transaction_count"` // number of transactions up to the restore point
+	DryRun           bool           `json:"dry_run"`
+	Files            []RestoredFile `json:"memories"`
+}
+
+// RestoredFile describes the changes made to a memory by a restore.
+type RestoredFile struct {
+	UUID    string        `json:"uuid"`
+	Name    string        `json:"name"`
+	Changes []FieldChange `json:"changes"`
+}
+
+// ErrInvalidRestorePoint implies a restore point was neither a transaction UUID nor a valid time.
+var ErrInvalidRestorePoint = errors.New("restore point must be a transaction UUID, a unix timestamp or a date")
+
+// restorePoint determines the number of Transactions made up to & including the restore point. The target is either a
+// transaction UUID, a unix timestamp in seconds, an RFC 3339 time or a date (YYYY-MM-DD) in local time.
+func restorePoint(transactions []Transaction, target string) (count int, err error) {
+	for i, transaction := range transactions {
+		if transaction.UUID == target {
+			return i + 1, nil
+		}
+	}
+
+	var timestamp int64
+	if timestamp, err = strconv.ParseInt(target, 10, 64); err != nil {
+		t, err := time.Parse(time.RFC3339, target)
+		if err != nil {
+			if t, err = time.ParseInLocation("2006-01-02", target, time.Local); err != nil {
+				return 0, ErrInvalidRestorePoint
+			}
+		}
+		timestamp = t.Unix()
+	}
+
+	for count < len(transactions) && transactions[count].CreationTimestamp <= timestamp {
+		count++
+	}
+	return count, nil
+}
+
+// revision returns the revision of a File after the Transaction was applied. Transactions recorded before revisions
+// were stored only imply the State, so the current MetaData of the File is used.
+func (t Transaction) revision(current File) FileRevision {
+	if t.After.State != Uploaded {
+		return t.After
+	}
+
+	revision := FileRevision{State: Published, MetaData: current.MetaData}
+	if t.Type == Delete {
+		revision.State = Deleted
+	}
+	return revision
+}
+
+// Restore rebuilds the Published FileDB as it was at the restore point (see restorePoint) by replaying the revisions
+// recorded by each Transaction. Memories published after the restore point are marked as deleted. Each restored memory
+// is recorded as an Edit Transaction by the actor. If dryRun is set, the memories which would change are reported but
+// not changed.
+func (db *FileDB) Restore(target string, actor string, dryRun bool) (report RestoreReport, err error) {
+	db.FileTransactions.mu.RLock()
+	transactions := append([]Transaction{}, db.FileTransactions.Transactions...)
+	db.FileTransactions.mu.RUnlock()
+
+	count, err := restorePoint(transactions, target)
+	if err != nil {
+		return report, err
+	}
+	report = RestoreReport{TransactionCount: count, DryRun: dryRun, Files: make([]RestoredFile, 0)}
+
+	// only memories changed after the restore point need restoring, in the order they were first changed
+	var changedUUIDs []string
+	changed := make(map[string]bool)
+	for _, transaction := range transactions[count:] {
+		if !changed[transaction.TargetFileUUID] {
+			changed[transaction.TargetFileUUID] = true
+			changedUUIDs = append(changedUUIDs, transaction.TargetFileUUID)
+		}
+	}
+
+	for _, fileUUID := range changedUUIDs {
+		// the content of purged memories no longer exists
+		file, ok := db.Published.Get(fileUUID)
+		if !ok || file.State == Purged {
+			continue
+		}
+
+		// memories which did not exist at the restore point are deleted
+		restored := FileRevision{State: Deleted, MetaData: file.MetaData}
+		for _, transaction := range transactions[:count] {
+			if transaction.TargetFileUUID == fileUUID {
+				restored = transaction.revision(file)
+			}
+		}
+
+		transaction := Transaction{
+			UUID:              NewUUID(),
+			CreationTimestamp: time.Now().Unix(),
+			Type:              Edit,
+			TargetFileUUID:    fileUUID,
+			Version:           config.Version,
+			ActorUsername:     actor,
+			SourceInstance:    config.InstanceName,
+			Before:            FileRevision{State: file.State, MetaData: file.MetaData},
+			After:             restored,
+		}
+		changes := transaction.Changes()
+		if len(changes) == 0 {
+			continue
+		}
+		report.Files = append(report.Files, RestoredFile{UUID: fileUUID, Name: file.Name + "." + file.Extension, Changes: changes})
+		if dryRun {
+			continue
+		}
+
+		// restored deletions start their trash retention period now, rather than being purged as soon as possible
+		if restored.State != Deleted {
+			file.DeletedTimestamp = 0
+		} else if file.State != Deleted || file.DeletedTimestamp == 0 {
+			file.DeletedTimestamp = time.Now().UnixNano()
+		}
+		file.State, file.MetaData = restored.State, restored.MetaData
+		db.Published.Set(fileUUID, file)
+		db.FileTransactions.add(transaction)
+	}
+
+	if !dryRun && len(report.Files) > 0 {
+		db.Checkpoint()
+	}
+	return report, nil
+}
@@ -0,0 +1,85 @@
+package memoryshare
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestore(t *testing.T) {
+	db, _ := newTestFileDB(t, GobBackend)
+
+	a := File{UUID: "a", Name: "a", Extension: "jpg", State: Published, MetaData: MetaData{Description: "d", Tags: []string{"x"}}}
+	db.Published.Set(a.UUID, a)
+	db.FileTransactions.Create(Create, "bob", File{UUID: a.UUID}, a)
+	mark := db.FileTransactions.Transactions[0].UUID
+
+	edited := a
+	edited.Tags = []string{"x", "y"}
+	db.Published.Set(a.UUID, edited)
+	db.FileTransactions.Create(Edit, "bob", a, edited)
+
+	b := File{UUID: "b", Name: "b", Extension: "jpg", State: Published}
+	db.Published.Set(b.UUID, b)
+	db.FileTransactions.Create(Create, "bob", File{UUID: b.UUID}, b)
+	if err := db.DeleteFile(a.UUID, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := db.Restore(mark, "admin", true)
+	if err != nil || len(report.Files) != 2 || report.TransactionCount != 1 {
+		t.Fatalf("expected a dry run to report 2 memories, got %+v (%v)", report, err)
+	}
+	if file, _ := db.Published.Get(a.UUID); file.State != Deleted {
+		t.Fatal("expected a dry run not to change any memories")
+	}
+
+	if _, err = db.Restore(mark, "admin", false); err != nil {
+		t.Fatal(err)
+	}
+	restoredA, _ := db.Published.Get(a.UUID)
+	if restoredA.State != Published || len(restoredA.Tags) != 1 || restoredA.DeletedTimestamp != 0 {
+		t.Fatalf("expected a to be restored to its original revision, got %+v", restoredA)
+	}
+	restoredB, _ := db.Published.Get(b.UUID)
+	if restoredB.State != Deleted || restoredB.DeletedTimestamp == 0 {
+		t.Fatalf("expected b to be deleted, as it was published after the restore point, got %+v", restoredB)
+	}
+
+	if _, err := db.Restore("nope", "admin", true); err != ErrInvalidRestorePoint {
+		t.Fatalf("expected %v, got %v", ErrInvalidRestorePoint, err)
+	}
+	if report, _ = db.Restore("2000-01-01", "admin", true); report.TransactionCount != 0 {
+		t.Fatalf("expected no transactions before 2000, got %v", report.TransactionCount)
+	}
+}
+
+func TestRestoreThenPurgeTrash(t *testing.T) {
+	db, dir := newTestFileDB(t, GobBackend)
+
+	a := File{UUID: "a", State: Published}
+	db.Published.Set(a.UUID, a)
+	db.FileTransactions.Create(Create, "bob", File{UUID: a.UUID}, a)
+	mark := db.FileTransactions.Transactions[0].UUID
+
+	// b is published after the restore point, so is restored into the trash
+	path, hash := writeTestBlob(t, dir, "published later")
+	if err := db.blobs.Put(hash, path); err != nil {
+		t.Fatal(err)
+	}
+	b := File{UUID: "b", Hash: hash, State: Published}
+	db.Published.Set(b.UUID, b)
+	db.FileTransactions.Create(Create, "bob", File{UUID: b.UUID}, b)
+
+	if _, err := db.Restore(mark, "admin", false); err != nil {
+		t.Fatal(err)
+	}
+	if purged, err := db.PurgeTrash(time.Hour); purged != 0 || err != nil {
+		t.Fatalf("expected restored deletions to be kept for the retention period, got %v purged (%v)", purged, err)
+	}
+	if exists, _ := db.blobs.Exists(hash); !exists {
+		t.Fatal("expected the content of the restored deletion to remain")
+	}
+	if err := db.RestoreFile(b.UUID, "bob"); err != nil {
+		t.Fatalf("expected the restored deletion to be recoverable from the trash, got %v", err)
+	}
+}
@@ -0,0 +1,481 @@
+package memoryshare
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// S3BlobStore is a BlobStore which stores blobs as objects in an S3 compatible bucket (i.e. AWS S3 or MinIO). Requests
+// are signed with AWS Signature Version 4. Like the LocalBlobStore, objects are keyed by hash & sharded by prefix, with
+// the reference count of each blob kept in a sidecar .refs object.
+type S3BlobStore struct {
+	endpoint      *url.URL
+	region        string
+	bucket        string
+	accessKey     string
+	secretKey     string
+	pathStyle     bool // address the bucket as part of the path (required by MinIO) rather than the host name
+	presign       bool // redirect content requests to presigned URLs rather than streaming through the server
+	presignExpiry time.Duration
+	client        *http.Client
+	mu            sync.Mutex // serialises reference count updates
+}
+
+// NewS3BlobStore creates an S3BlobStore from the content settings in the config.
+func NewS3BlobStore(settings ContentSettings) (*S3BlobStore, error) {
+	endpoint, err := url.Parse(settings.S3Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, errors.Errorf("invalid S3 endpoint %q", settings.S3Endpoint)
+	}
+	if settings.S3Bucket == "" {
+		return nil, errors.New("no S3 bucket configured")
+	}
+
+	return &S3BlobStore{
+		endpoint:      endpoint,
+		region:        settings.S3Region,
+		bucket:        settings.S3Bucket,
+		accessKey:     settings.S3AccessKey,
+		secretKey:     settings.S3SecretKey,
+		pathStyle:     settings.S3PathStyle,
+		presign:       settings.S3Presign,
+		presignExpiry: time.Duration(settings.S3PresignExpiry) * time.Second,
+		client:        &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
+
+// key determines the object key of the blob stored under hash.
+func (s *S3BlobStore) key(hash string) string {
+	return "blobs/" + hash[0:2] + "/" + hash[2:4] + "/" + hash
+}
+
+// objectURL determines the URL of an object in the bucket.
+func (s *S3BlobStore) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	if s.pathStyle {
+		u.Path = "/" + s.bucket + "/" + key
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	return &u
+}
+
+// do signs & performs a request on an object. A 404 response is returned as ErrBlobNotFound & any other non 2xx response
+// is returned as an error.
+func (s *S3BlobStore) do(method string, key string, body io.Reader, size int64, payloadHash string, header http.Header) (*http.Response, error) {
+	return s.doURL(method, s.objectURL(key), body, size, payloadHash, header)
+}
+
+// doURL signs & performs a request on a bucket URL, handling responses in the same way as do.
+func (s *S3BlobStore) doURL(method string, u *url.URL, body io.Reader, size int64, payloadHash string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create S3 request")
+	}
+	req.ContentLength = size
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	s.sign(req, payloadHash, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "S3 request failed")
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrBlobNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, errors.Errorf("S3 %v %v failed with status %v: %s", method, u.Path, resp.StatusCode, msg)
+	}
+	return resp, nil
+}
+
+// emptyPayloadHash is the SHA-256 hash of an empty request body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// refs reads the reference count of a blob.
+func (s *S3BlobStore) refs(hash string) (int, error) {
+	resp, err := s.do(http.MethodGet, s.key(hash)+".refs", nil, 0, emptyPayloadHash, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read reference count")
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// setRefs writes the reference count of a blob.
+func (s *S3BlobStore) setRefs(hash string, count int) error {
+	data := []byte(strconv.Itoa(count))
+	sum := sha256.Sum256(data)
+
+	resp, err := s.do(http.MethodPut, s.key(hash)+".refs", bytes.NewReader(data), int64(len(data)), hex.EncodeToString(sum[:]), nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Put uploads the file at src to the bucket. The hash is sent as the payload hash, so S3 rejects the upload if the
+// content does not match it.
+func (s *S3BlobStore) Put(hash string, src string) error {
+	if !hashRegex(hash) {
+		return ErrInvalidHash
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// content already stored
+	count, err := s.refs(hash)
+	if err == nil {
+		return s.setRefs(hash, count+1)
+	}
+	if err != ErrBlobNotFound {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "failed to open src file")
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return errors.Wrap(err, "failed to stat src file")
+	}
+
+	resp, err := s.do(http.MethodPut, s.key(hash), in, info.Size(), hash, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to upload blob")
+	}
+	resp.Body.Close()
+
+	return s.setRefs(hash, 1)
+}
+
+// Open downloads a blob, verifying the content against hash as it is read.
+func (s *S3BlobStore) Open(hash string) (io.ReadCloser, error) {
+	if !hashRegex(hash) {
+		return nil, ErrInvalidHash
+	}
+
+	resp, err := s.do(http.MethodGet, s.key(hash), nil, 0, emptyPayloadHash, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &verifyingReader{ReadCloser: resp.Body, hash: hash, h: sha256.New()}, nil
+}
+
+// Serve either redirects the client to a presigned URL for the blob or streams the blob through the server, depending
+// on the s3_presign setting. Range requests are passed through to S3 when streaming.
+func (s *S3BlobStore) Serve(w http.ResponseWriter, r *http.Request, hash string, name string) error {
+	if !hashRegex(hash) {
+		return ErrInvalidHash
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+
+	if s.presign {
+		query := url.Values{}
+		if contentType != "" {
+			query.Set("response-content-type", contentType)
+		}
+		http.Redirect(w, r, s.presignURL(s.key(hash), query, time.Now()), http.StatusFound)
+		return nil
+	}
+
+	header := http.Header{}
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		header.Set("Range", rangeHeader)
+	}
+	method := http.MethodGet
+	if r.Method == http.MethodHead {
+		method = http.MethodHead
+	}
+	resp, err := s.do(method, s.key(hash), nil, 0, emptyPayloadHash, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	for _, name := range []string{"Content-Length", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified"} {
+		if value := resp.Header.Get(name); value != "" {
+			w.Header().Set(name, value)
+		}
+	}
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	if _, err = io.Copy(w, resp.Body); err != nil {
+		Output.Log(errors.Wrap(err, "failed to stream blob"))
+	}
+	return nil
+}
+
+// Exists determines whether a blob is stored.
+func (s *S3BlobStore) Exists(hash string) (bool, error) {
+	if !hashRegex(hash) {
+		return false, ErrInvalidHash
+	}
+
+	resp, err := s.do(http.MethodHead, s.key(hash), nil, 0, emptyPayloadHash, nil)
+	if err == ErrBlobNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// Release decrements the reference count of a blob, deleting the object once it is no longer referenced.
+func (s *S3BlobStore) Release(hash string) error {
+	if !hashRegex(hash) {
+		return ErrInvalidHash
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count, err := s.refs(hash)
+	if err != nil {
+		return err
+	}
+	if count > 1 {
+		return s.setRefs(hash, count-1)
+	}
+	return s.remove(hash)
+}
+
+// Delete removes a blob & its reference count regardless of the number of references.
+func (s *S3BlobStore) Delete(hash string) error {
+	if !hashRegex(hash) {
+		return ErrInvalidHash
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.remove(hash)
+}
+
+// remove deletes a blob object & its reference count object. The caller must hold the lock.
+func (s *S3BlobStore) remove(hash string) error {
+	for _, key := range []string{s.key(hash), s.key(hash) + ".refs"} {
+		resp, err := s.do(http.MethodDelete, key, nil, 0, emptyPayloadHash, nil)
+		if err != nil && err != ErrBlobNotFound {
+			return errors.Wrap(err, "failed to delete blob")
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+	return nil
+}
+
+// listBucketResult is the subset of an S3 ListObjectsV2 response used by List.
+type listBucketResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key string
+	}
+}
+
+// List pages through the blob objects in the bucket with ListObjectsV2, returning the hash of every stored blob.
+func (s *S3BlobStore) List() (hashes []string, err error) {
+	query := url.Values{"list-type": {"2"}, "prefix": {"blobs/"}}
+	for {
+		u := s.objectURL("")
+		u.RawQuery = canonicalQueryString(query)
+
+		resp, err := s.doURL(http.MethodGet, u, nil, 0, emptyPayloadHash, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list blobs")
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode blob list")
+		}
+
+		for _, object := range result.Contents {
+			if hash := object.Key[strings.LastIndex(object.Key, "/")+1:]; hashRegex(hash) {
+				hashes = append(hashes, hash)
+			}
+		}
+
+		if !result.IsTruncated {
+			return hashes, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// sign adds AWS Signature Version 4 authorization headers to a request. All headers set on the request are signed.
+func (s *S3BlobStore) sign(req *http.Request, payloadHash string, t time.Time) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL),
+		canonicalQueryString(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope, signature := s.signature(amzDate, canonicalRequest)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+// presignURL creates a URL which grants temporary GET access to an object without any further authorization.
+func (s *S3BlobStore) presignURL(key string, query url.Values, t time.Time) string {
+	u := s.objectURL(key)
+	amzDate := t.UTC().Format("20060102T150405Z")
+
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.accessKey+"/"+s.scope(amzDate[:8]))
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(s.presignExpiry.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		canonicalURI(u),
+		canonicalQueryString(query),
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	_, signature := s.signature(amzDate, canonicalRequest)
+	query.Set("X-Amz-Signature", signature)
+	u.RawQuery = canonicalQueryString(query)
+	return u.String()
+}
+
+// scope determines the credential scope of a request made on the given date (YYYYMMDD).
+func (s *S3BlobStore) scope(date string) string {
+	return date + "/" + s.region + "/s3/aws4_request"
+}
+
+// signature signs a canonical request, returning the credential scope & hex encoded signature.
+func (s *S3BlobStore) signature(amzDate string, canonicalRequest string) (scope string, signature string) {
+	date := amzDate[:8]
+	scope = s.scope(date)
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return scope, hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// hmacSHA256 computes the HMAC-SHA256 of data with the given key.
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalURI encodes each segment of the path of a URL, as required by AWS Signature Version 4.
+func canonicalURI(u *url.URL) string {
+	if u.Path == "" {
+		return "/"
+	}
+	segments := strings.Split(u.Path, "/")
+	for i, segment := range segments {
+		segments[i] = awsURIEncode(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQueryString encodes query parameters sorted by key, as required by AWS Signature Version 4.
+func canonicalQueryString(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, awsURIEncode(key)+"="+awsURIEncode(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// awsURIEncode percent encodes every byte other than the unreserved characters A-Z, a-z, 0-9, '-', '.', '_' & '~'.
+func awsURIEncode(s string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '-' || c == '.' || c == '_' || c == '~' {
+			buf.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&buf, "%%%02X", c)
+	}
+	return buf.String()
+}
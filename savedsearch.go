@@ -0,0 +1,177 @@
+package memoryshare
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrSavedSearchNotFound implies no saved search matched the ID, or it belongs to another user & is not shared.
+var ErrSavedSearchNotFound = errors.New("saved search not found")
+
+// ErrInvalidSearchName implies the name of a saved search was empty or too long.
+var ErrInvalidSearchName = errors.New("invalid saved search name")
+
+// maxSearchNameLength is the maximum length of the name of a saved search.
+const maxSearchNameLength = 60
+
+// savedSearchParams are the /search URL params which make up the criteria of a saved search. Pagination & format
+// params are not saved, as they are chosen each time a saved search is opened. The mine & favourites params apply to the
+// user who opens a saved search rather than its owner.
+var savedSearchParams = []string{
+	"desc", "min_date", "max_date", "tags", "people", "file_types", "q", "sort", "uploader", "extension", "min_size",
+	"max_size", "favourites", "mine", "include_deleted",
+}
+
+// SavedSearch is a named set of search criteria. Opening a saved search runs its criteria against the current memories,
+// so it acts as a smart album which updates as new memories match. A shared saved search can be listed & opened by all
+// users, but only changed by its owner. Saved searches are stored in the SavedSearches of their owner, keyed by ID.
+type SavedSearch struct {
+	ID               string            `json:"id"`
+	Name             string            `json:"name"`
+	OwnerUsername    string            `json:"owner"`
+	Criteria         map[string]string `json:"criteria"` // /search URL param -> value
+	Shared           bool              `json:"shared"`
+	CreatedTimestamp int64             `json:"created"`
+	UpdatedTimestamp int64             `json:"updated"`
+}
+
+// savedSearchCriteria extracts the criteria of a saved search from /search URL params, omitting empty params.
+func savedSearchCriteria(params url.Values) map[string]string {
+	criteria := make(map[string]string)
+	for _, param := range savedSearchParams {
+		if value := params.Get(param); value != "" {
+			criteria[param] = value
+		}
+	}
+	return criteria
+}
+
+// apply replaces the criteria params of /search URL params with the criteria of the saved search.
+func (s SavedSearch) apply(params url.Values) {
+	for _, param := range savedSearchParams {
+		params.Del(param)
+		if value, ok := s.Criteria[param]; ok {
+			params.Set(param, value)
+		}
+	}
+}
+
+// SaveSearch saves search criteria under a name for a user. Saving under the name of an existing saved search of the
+// user replaces its criteria.
+func (db *UserDB) SaveSearch(username string, name string, criteria map[string]string) (SavedSearch, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxSearchNameLength {
+		return SavedSearch{}, ErrInvalidSearchName
+	}
+	user, ok := db.Users.Get(username)
+	if !ok {
+		return SavedSearch{}, ErrUserNotFound
+	}
+	user.SavedSearches = copySavedSearches(user.SavedSearches)
+
+	now := time.Now().UnixNano()
+	search := SavedSearch{ID: NewUUID(), Name: name, OwnerUsername: username, CreatedTimestamp: now}
+	for _, existing := range user.SavedSearches {
+		if strings.EqualFold(existing.Name, name) {
+			search = existing
+			break
+		}
+	}
+	search.Criteria = criteria
+	search.UpdatedTimestamp = now
+
+	user.SavedSearches[search.ID] = search
+	db.Users.Set(username, user)
+	db.Checkpoint()
+	return search, nil
+}
+
+// DeleteSavedSearch deletes a saved search of a user.
+func (db *UserDB) DeleteSavedSearch(username string, ID string) error {
+	user, ok := db.Users.Get(username)
+	if !ok {
+		return ErrUserNotFound
+	}
+	if _, ok := user.SavedSearches[ID]; !ok {
+		return ErrSavedSearchNotFound
+	}
+
+	user.SavedSearches = copySavedSearches(user.SavedSearches)
+	delete(user.SavedSearches, ID)
+	db.Users.Set(username, user)
+	db.Checkpoint()
+	return nil
+}
+
+// ShareSavedSearch shares a saved search of a user with all other users, or stops sharing it.
+func (db *UserDB) ShareSavedSearch(username string, ID string, shared bool) error {
+	user, ok := db.Users.Get(username)
+	if !ok {
+		return ErrUserNotFound
+	}
+	search, ok := user.SavedSearches[ID]
+	if !ok {
+		return ErrSavedSearchNotFound
+	}
+
+	search.Shared = shared
+	user.SavedSearches = copySavedSearches(user.SavedSearches)
+	user.SavedSearches[ID] = search
+	db.Users.Set(username, user)
+	db.Checkpoint()
+	return nil
+}
+
+// copySavedSearches copies the saved searches of a User, so that they can be changed without modifying the map which
+// is shared with the User stored in the UserDB.
+func copySavedSearches(searches map[string]SavedSearch) map[string]SavedSearch {
+	copied := make(map[string]SavedSearch, len(searches)+1)
+	for ID, search := range searches {
+		copied[ID] = search
+	}
+	return copied
+}
+
+// GetSavedSearches returns the saved searches of a user along with those shared by other users, alphabetically by name.
+func (db *UserDB) GetSavedSearches(username string) []SavedSearch {
+	visibleSearches := func(m UserMapDB) interface{} {
+		searches := make([]SavedSearch, 0)
+		for _, user := range m {
+			for _, search := range user.SavedSearches {
+				if user.Username == username || search.Shared {
+					searches = append(searches, search)
+				}
+			}
+		}
+		return searches
+	}
+	searches := db.Users.PerformFunc(visibleSearches).([]SavedSearch)
+
+	sort.Slice(searches, func(i, j int) bool {
+		if searches[i].Name != searches[j].Name {
+			return strings.ToLower(searches[i].Name) < strings.ToLower(searches[j].Name)
+		}
+		return searches[i].ID < searches[j].ID
+	})
+	return searches
+}
+
+// GetSavedSearch returns a saved search which is either owned by the user or shared.
+func (db *UserDB) GetSavedSearch(ID string, username string) (SavedSearch, error) {
+	findSearch := func(m UserMapDB) interface{} {
+		for _, user := range m {
+			if search, ok := user.SavedSearches[ID]; ok && (user.Username == username || search.Shared) {
+				return search
+			}
+		}
+		return nil
+	}
+	if search, ok := db.Users.PerformFunc(findSearch).(SavedSearch); ok {
+		return search, nil
+	}
+	return SavedSearch{}, ErrSavedSearchNotFound
+}
@@ -0,0 +1,111 @@
+package memoryshare
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSavedSearches(t *testing.T) {
+	for _, backend := range storeBackends {
+		t.Run(backend, func(t *testing.T) {
+			dir := newTestConfig(t, backend)
+			db, _, err := openUserDB(dir+"/db", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			db.Users.Set("bob", User{Username: "bob", FavouriteFileUUIDs: map[string]bool{}})
+			db.Users.Set("eve", User{Username: "eve"})
+
+			// only search criteria are saved
+			params := url.Values{"tags": {"grandma,christmas"}, "q": {"date:2017"}, "format": {"json"}, "desc": {""}}
+			criteria := savedSearchCriteria(params)
+			if len(criteria) != 2 || criteria["tags"] != "grandma,christmas" || criteria["q"] != "date:2017" {
+				t.Fatalf("expected the tags & query criteria, got %v", criteria)
+			}
+
+			saved, err := db.SaveSearch("bob", " Xmas ", criteria)
+			if err != nil || saved.Name != "Xmas" {
+				t.Fatalf("expected the name to be trimmed, got %q (%v)", saved.Name, err)
+			}
+			// saving with the same name replaces the search
+			replaced, err := db.SaveSearch("bob", "xmas", map[string]string{"tags": "christmas"})
+			if err != nil || replaced.ID != saved.ID || replaced.CreatedTimestamp != saved.CreatedTimestamp ||
+				len(db.GetSavedSearches("bob")) != 1 {
+				t.Fatalf("expected the search to be replaced, got %+v (%v)", replaced, err)
+			}
+			if _, err := db.SaveSearch("bob", "  ", criteria); err != ErrInvalidSearchName {
+				t.Fatalf("expected %v, got %v", ErrInvalidSearchName, err)
+			}
+
+			// saved searches are private until shared by their owner
+			if searches := db.GetSavedSearches("eve"); len(searches) != 0 {
+				t.Fatalf("expected eve to see no saved searches, got %v", len(searches))
+			}
+			if _, err := db.GetSavedSearch(saved.ID, "eve"); err != ErrSavedSearchNotFound {
+				t.Fatalf("expected %v, got %v", ErrSavedSearchNotFound, err)
+			}
+			if err := db.ShareSavedSearch("eve", saved.ID, true); err != ErrSavedSearchNotFound {
+				t.Fatalf("expected only the owner to be able to share, got %v", err)
+			}
+			if err := db.ShareSavedSearch("bob", saved.ID, true); err != nil {
+				t.Fatal(err)
+			}
+			if shared, err := db.GetSavedSearch(saved.ID, "eve"); err != nil || shared.OwnerUsername != "bob" ||
+				len(db.GetSavedSearches("eve")) != 1 {
+				t.Fatalf("expected eve to see the shared search, got %+v (%v)", shared, err)
+			}
+
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if db, _, err = openUserDB(dir+"/db", false); err != nil {
+				t.Fatal(err)
+			}
+			shared, err := db.GetSavedSearch(saved.ID, "eve")
+			if err != nil || shared.Criteria["tags"] != "christmas" || !shared.Shared {
+				t.Fatalf("expected the shared search to persist, got %+v (%v)", shared, err)
+			}
+
+			// reopening a search replaces the criteria of the request, but not its pagination
+			params = url.Values{"tags": {"other"}, "desc": {"x"}, "results_per_page": {"5"}}
+			shared.apply(params)
+			if params.Get("tags") != "christmas" || params.Get("desc") != "" || params.Get("results_per_page") != "5" {
+				t.Fatalf("unexpected params %v", params)
+			}
+
+			if err := db.DeleteSavedSearch("eve", saved.ID); err != ErrSavedSearchNotFound {
+				t.Fatalf("expected only the owner to be able to delete, got %v", err)
+			}
+			if err := db.DeleteSavedSearch("bob", saved.ID); err != nil {
+				t.Fatal(err)
+			}
+			if searches := db.GetSavedSearches("bob"); len(searches) != 0 {
+				t.Fatalf("expected the search to be deleted, got %v", len(searches))
+			}
+		})
+	}
+}
+
+func TestSavedSearchesNotSerialisedWithUser(t *testing.T) {
+	dir := newTestConfig(t, GobBackend)
+	db, _, err := openUserDB(dir+"/db", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Users.Set("bob", User{Username: "bob", FavouriteFileUUIDs: map[string]bool{}})
+	if _, err := db.SaveSearch("bob", "Private", map[string]string{"q": "secret query"}); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := db.GetUserByUsername("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(user.SavedSearches) != 1 {
+		t.Fatal("expected the saved search to be stored on the user")
+	}
+	if encoded := ToJSON(user, false); strings.Contains(encoded, "secret query") || strings.Contains(encoded, "Private") {
+		t.Fatalf("expected saved searches to be omitted from the user, got %v", encoded)
+	}
+}
@@ -3,16 +3,20 @@ package memoryshare
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"html"
 	"html/template"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -31,6 +35,8 @@ type Server struct {
 	fileDB            *FileDB
 	maxFileUploadSize int
 	userDB            *UserDB
+	stop              chan struct{}  // closed to stop background jobs
+	jobs              sync.WaitGroup // running background jobs
 	*http.Server
 }
 
@@ -60,6 +66,7 @@ func NewServer(conf *Config) (httpServer *Server, err error) {
 		fileDB:            fileDB,
 		maxFileUploadSize: config.MaxFileUploadSize,
 		userDB:            userDB,
+		stop:              make(chan struct{}),
 	}
 
 	// preload html templates
@@ -75,6 +82,16 @@ func NewServer(conf *Config) (httpServer *Server, err error) {
 		httpServer.host = "0.0.0.0"
 	}
 
+	// start background jobs
+	httpServer.startJob(fileDB.runTrashPurger)
+	httpServer.startJob(fileDB.runResumableUploadPurger)
+	httpServer.startJob(func(stop <-chan struct{}) {
+		fileDB.runUploadJanitor(stop, httpServer.sendUploadExpiryEmail)
+	})
+	httpServer.startJob(func(stop <-chan struct{}) {
+		fileDB.ComputePerceptualHashes(stop)
+	})
+
 	httpServer.Start()
 	return
 }
@@ -99,11 +116,20 @@ func (s *Server) Start() {
 	// memory/file data viewing
 	router.HandleFunc("/", s.authHandler(s.viewMemoriesHandler)).Methods(http.MethodGet)
 	router.HandleFunc("/memory/{fileUUID}", s.authHandler(s.viewMemoriesHandler)).Methods(http.MethodGet) // passive route, JS utilises fileUUID
+	router.HandleFunc("/memory/{fileUUID}", s.authHandler(s.editMemoryHandler)).Methods(http.MethodPost)
 	router.HandleFunc("/search", s.authHandler(s.searchMemoriesHandler)).Methods(http.MethodGet)
+	router.HandleFunc("/searches", s.authHandler(s.savedSearchesHandler)).Methods(http.MethodGet)
 	router.HandleFunc("/data", s.authHandler(s.getDataHandler)).Methods(http.MethodGet, http.MethodPost)
+	router.HandleFunc("/history", s.authHandler(s.historyHandler)).Methods(http.MethodGet)
+	router.HandleFunc("/trash", s.authHandler(s.trashHandler)).Methods(http.MethodGet)
+	router.HandleFunc("/trash/{type}", s.authHandler(s.trashHandler)).Methods(http.MethodPost)
 	// upload
 	router.HandleFunc("/upload", s.authHandler(s.uploadHandler)).Methods(http.MethodGet)
+	router.HandleFunc("/upload/resumable", s.authHandler(s.resumableUploadHandler)).Methods(http.MethodOptions, http.MethodPost)
+	router.HandleFunc("/upload/resumable/{id}", s.authHandler(s.resumableUploadHandler)).Methods(http.MethodHead, http.MethodPatch, http.MethodDelete)
 	router.HandleFunc("/upload/{type}", s.authHandler(s.uploadHandler)).Methods(http.MethodPost)
+	// published file content server
+	router.Handle(`/static/content/{file:[a-zA-Z0-9\-._]+}`, s.fileServerAuthHandler(http.HandlerFunc(s.contentHandler)))
 	// static uploaded file server
 	staticFileHandler := http.StripPrefix("/static/", http.FileServer(http.Dir(config.rootPath+"/static/")))
 	router.Handle(`/static/{rest:[a-zA-Z0-9=\-\/._]+}`, s.fileServerAuthHandler(staticFileHandler))
@@ -240,6 +266,35 @@ func (s *Server) fileServerAuthHandler(h http.Handler) http.Handler {
 	})
 }
 
+// contentHandler is a HTTP handler which serves the content of published files from the BlobStore.
+func (s *Server) contentHandler(w http.ResponseWriter, r *http.Request) {
+	fileUUID, _ := SplitFileName(mux.Vars(r)["file"])
+
+	file, ok := s.fileDB.Published.Get(fileUUID)
+	if !ok || (file.State != Published && file.State != Deleted) {
+		s.RespondStatus(w, r, "404 page not found", http.StatusNotFound)
+		return
+	}
+
+	// memories in the trash are only viewable by the uploader & admins
+	if file.State == Deleted {
+		sessionUser, err := s.userDB.GetSessionUser(r)
+		if err != nil || (sessionUser.Username != file.UploaderUsername && sessionUser.Type < Admin) {
+			s.RespondStatus(w, r, "404 page not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	if err := s.fileDB.blobs.Serve(w, r, file.Hash, file.ContentName()); err != nil {
+		if err == ErrBlobNotFound {
+			s.RespondStatus(w, r, "404 page not found", http.StatusNotFound)
+			return
+		}
+		Critical.Logf("%+v", err)
+		s.RespondStatus(w, r, "error", http.StatusInternalServerError)
+	}
+}
+
 // ServerError is an error type which contains a sensitive error message and a user friendly error message which can
 // be safely returned to the user client.
 type ServerError struct {
@@ -359,6 +414,39 @@ func (s *Server) sendPasswordResetEmail(recipientEmail string) {
 	}
 }
 
+// sendUploadExpiryEmail warns a user that their unpublished uploads will be deleted if they are not published before
+// they expire.
+func (s *Server) sendUploadExpiryEmail(username string, files []File, expires time.Time) error {
+	user, ok := s.userDB.Users.Get(username)
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	msgBody := uploadExpiryEmailBody(user, files, expires)
+
+	msg := gomail.NewMessage()
+	msg.SetAddressHeader("From", config.EmailDisplayAddr, "Memory Share")
+	msg.SetHeader("To", user.Email)
+	msg.SetHeader("Subject", config.ServiceName+": Unpublished Uploads Expiring")
+	msg.SetBody("text/html", msgBody)
+
+	d := gomail.NewPlainDialer(config.EmailServer, config.EmailPort, config.EmailAddr, config.EmailPass)
+
+	// send email
+	return errors.Wrap(d.DialAndSend(msg), "failed to send upload expiry email")
+}
+
+// uploadExpiryEmailBody creates the HTML body of an upload expiry warning email. User names & file names are escaped.
+func uploadExpiryEmailBody(user User, files []File, expires time.Time) string {
+	msgBody := fmt.Sprintf("<html><body><p>Hi %v,<br><br>The following memories you uploaded to %v have not been published:<br><ul>", html.EscapeString(user.Forename), html.EscapeString(config.ServiceName))
+	for _, file := range files {
+		msgBody += fmt.Sprintf("<li>%v (uploaded %v)</li>", html.EscapeString(file.Name+"."+file.Extension), time.Unix(0, file.UploadedTimestamp).Format("02/01/2006"))
+	}
+	msgBody += fmt.Sprintf("</ul>Publish them from the upload page before %v or they will be deleted!", expires.Format("02/01/2006 [15:04]"))
+	msgBody += "<br><br><3</p></body></html>"
+	return msgBody
+}
+
 // loginHandler is a HTTP handler which manages user logins.
 func (s *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
@@ -608,10 +696,70 @@ func (s *Server) manageUserHandler(w http.ResponseWriter, r *http.Request) {
 			} else {
 				s.Respond(w, r, "favourite_successfully_removed")
 			}
+
+		// save the /search criteria params of the form under a name
+		case "save_search":
+			criteria := savedSearchCriteria(r.Form)
+			if _, err := s.parseSearchRequest(r.Form, sessionUser); err != nil {
+				s.respondSearchRequestError(w, r, err)
+				return
+			}
+
+			savedSearch, err := s.userDB.SaveSearch(sessionUser.Username, r.Form.Get("name"), criteria)
+			if err != nil {
+				switch err {
+				case ErrInvalidSearchName:
+					s.RespondStatus(w, r, JSONResponse{WarningStatus, "invalid_name"}, http.StatusBadRequest)
+				default:
+					Critical.Logf("%+v", err)
+					s.RespondStatus(w, r, JSONResponse{ErrorStatus, "save_search_error"}, http.StatusInternalServerError)
+				}
+				return
+			}
+			s.Respond(w, r, ToJSON(savedSearch, false))
+
+		case "delete_search":
+			if err := s.userDB.DeleteSavedSearch(sessionUser.Username, r.Form.Get("id")); err != nil {
+				Input.Log(err)
+				s.RespondStatus(w, r, JSONResponse{WarningStatus, "saved_search_not_found"}, http.StatusBadRequest)
+				return
+			}
+			s.Respond(w, r, JSONResponse{SuccessStatus, "saved_search_deleted"})
+
+		// share a saved search with all users, or stop sharing it
+		case "share_search":
+			state, _ := strconv.ParseBool(r.Form.Get("state"))
+			if err := s.userDB.ShareSavedSearch(sessionUser.Username, r.Form.Get("id"), state); err != nil {
+				Input.Log(err)
+				s.RespondStatus(w, r, JSONResponse{WarningStatus, "saved_search_not_found"}, http.StatusBadRequest)
+				return
+			}
+			if state {
+				s.Respond(w, r, JSONResponse{SuccessStatus, "saved_search_shared"})
+			} else {
+				s.Respond(w, r, JSONResponse{SuccessStatus, "saved_search_unshared"})
+			}
 		}
 	}
 }
 
+// savedSearchesHandler is a HTTP handler which lists the saved searches of the session user along with those shared by
+// other users. Each can be opened as a smart album via /search?saved={id}. URL params: {
+//     pretty = [true, false],
+// }
+func (s *Server) savedSearchesHandler(w http.ResponseWriter, r *http.Request) {
+	// get session user
+	sessionUser, err := s.userDB.GetSessionUser(r)
+	if err != nil {
+		Critical.Log(err)
+		s.Respond(w, r, "error")
+		return
+	}
+
+	prettyPrint, _ := strconv.ParseBool(r.URL.Query().Get("pretty"))
+	s.Respond(w, r, ToJSON(s.userDB.GetSavedSearches(sessionUser.Username), prettyPrint))
+}
+
 // UserCreationDetails represents a user creation request.
 type UserCreationDetails struct {
 	Forename    string `json:"forename"`
@@ -642,13 +790,14 @@ func (s *Server) adminHandler(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		// HTML template data
 		templateData := struct {
-			Title       string
-			BrandName   string
-			SessionUser User
-			NavbarHTML  template.HTML
-			NavbarFocus string
-			FooterHTML  template.HTML
-			ContentHTML template.HTML
+			Title            string
+			BrandName        string
+			SessionUser      User
+			NavbarHTML       template.HTML
+			NavbarFocus      string
+			FooterHTML       template.HTML
+			ContentHTML      template.HTML
+			RecoveryWarnings []string
 		}{
 			"Admin",
 			config.ServiceName,
@@ -657,6 +806,7 @@ func (s *Server) adminHandler(w http.ResponseWriter, r *http.Request) {
 			"admin",
 			"",
 			"",
+			s.fileDB.RecoveryWarnings(),
 		}
 
 		templateData.NavbarHTML = s.CompleteTemplate("/dynamic/templates/navbar.html", templateData)
@@ -712,6 +862,72 @@ func (s *Server) adminHandler(w http.ResponseWriter, r *http.Request) {
 		case "stats":
 			s.Respond(w, r, "ok")
 
+		// restore published memories to a transaction UUID or time (dry_run reports the changes without making them)
+		case "restore":
+			if s.ParseFormBody(w, r) != nil {
+				return
+			}
+			dryRun, _ := strconv.ParseBool(r.Form.Get("dry_run"))
+
+			report, err := s.fileDB.Restore(r.Form.Get("target"), sessionUser.Username, dryRun)
+			if err != nil {
+				Input.Log(err)
+				s.Respond(w, r, JSONResponse{WarningStatus, "invalid_restore_point"})
+				return
+			}
+			s.Respond(w, r, ToJSON(report, false))
+
+		// storage usage & quota of each user
+		case "quotas":
+			s.Respond(w, r, ToJSON(s.fileDB.GetQuotas(s.userDB), false))
+
+		// override the quota of a user (size in MB & files, 0 = unlimited), or restore the default quota of their
+		// account type if reset is set
+		case "setquota":
+			if s.ParseFormBody(w, r) != nil {
+				return
+			}
+
+			var quota *Quota
+			if reset, _ := strconv.ParseBool(r.Form.Get("reset")); !reset {
+				size, sizeErr := strconv.ParseInt(r.Form.Get("size"), 10, 64)
+				files, filesErr := strconv.Atoi(r.Form.Get("files"))
+				if sizeErr != nil || filesErr != nil {
+					s.Respond(w, r, JSONResponse{WarningStatus, "invalid_quota"})
+					return
+				}
+				quota = &Quota{Bytes: size * 1024 * 1024, Files: files}
+			}
+
+			switch err := s.userDB.SetQuotaOverride(r.Form.Get("username"), quota); err {
+			case nil:
+				s.Respond(w, r, JSONResponse{SuccessStatus, r.Form.Get("username")})
+			case ErrUserNotFound:
+				s.Respond(w, r, JSONResponse{WarningStatus, "user_not_found"})
+			case ErrInvalidQuota:
+				s.Respond(w, r, JSONResponse{WarningStatus, "invalid_quota"})
+			}
+
+		// data loss detected when the FileDB was loaded, i.e. after recovering from a backup snapshot
+		case "recovery":
+			s.Respond(w, r, ToJSON(s.fileDB.RecoveryWarnings(), false))
+
+		// groups of visually similar published images
+		case "duplicates":
+			s.Respond(w, r, ToJSON(s.fileDB.NearDuplicateReport(), false))
+
+		// check the integrity of stored memories (verify, quarantine & repair are optional booleans)
+		case "fsck":
+			if s.ParseFormBody(w, r) != nil {
+				return
+			}
+			var opts FsckOptions
+			opts.Verify, _ = strconv.ParseBool(r.Form.Get("verify"))
+			opts.Quarantine, _ = strconv.ParseBool(r.Form.Get("quarantine"))
+			opts.Repair, _ = strconv.ParseBool(r.Form.Get("repair"))
+
+			s.Respond(w, r, ToJSON(s.fileDB.Fsck(opts), false))
+
 		default:
 			Input.Log("invalid request type")
 			s.Respond(w, r, "invalid request type")
@@ -730,45 +946,72 @@ type SearchRequest struct {
 	fileTypes      []string
 	resultsPerPage int64
 	page           int64
+
+	query      *Query         // parsed q param, applied on top of the other criteria
+	sort       string         // result order, see sortResults
+	favourites map[string]int // number of users who have favourited each file UUID
+	cursor     *searchCursor  // position the page starts after (or ends before), used instead of page if set
+
+	uploaders      []string        // usernames, any of which a File must be uploaded by
+	uploadedBy     string          // username which a File must be uploaded by, set to only include the session user's
+	extensions     []string        // lower case file extensions, excluding the dot
+	minSize        int64           // bytes (inclusive)
+	maxSize        int64           // bytes (inclusive), 0 for no maximum
+	onlyFavourites bool            // only include the session user's favourites
+	favouriteUUIDs map[string]bool // the session user's favourite file UUIDs
+	includeDeleted bool            // also include deleted files, admins only
 }
 
 // searchMemoriesHandler is a HTTP handler which processes & validates input search criteria then writes formatted
 // search results. URL params: {
-//     desc,
+//     desc (full-text search of descriptions, tags, people & file names),
 //     start_date,
 //     end_date,
 //     file_types (comma separated list),
 //     tags (comma separated list),
 //     people (comma separated list),
+//     q (query language, see Query),
+//     uploader (comma separated list of usernames),
+//     extension (comma separated list, i.e. "jpg,png"),
+//     min_size (bytes),
+//     max_size (bytes),
+//     favourites = [true, false] (only the session user's favourites),
+//     mine = [true, false] (only memories uploaded by the session user),
+//     include_deleted = [true, false] (admins only),
+//     sort = ["relevance", "newest", "oldest", "largest", "smallest", "name", "favourites"],
 //     format = ["json", "html_tiled", "html_detailed"],
 //     pretty = [true, false],
-//     results_per_page (0=all memories)
+//     results_per_page (0=all memories),
+//     cursor (next_cursor or prev_cursor of a previous page, preferred over page as pages are not shifted by memories
+//         published or deleted while paging),
+//     page,
+//     saved (ID of a saved search, whose criteria replace the criteria params above)
 // }
 func (s *Server) searchMemoriesHandler(w http.ResponseWriter, r *http.Request) {
-	q := r.URL.Query()
-	// construct search query from url params
-	searchReq := SearchRequest{
-		description: q.Get("desc"),
-		minDate:     0,
-		maxDate:     0,
-		tags:        ProcessInputList(q.Get("tags"), ",", true),
-		people:      ProcessInputList(q.Get("people"), ",", true),
-		fileTypes:   ProcessInputList(q.Get("file_types"), ",", true),
+	// get session user
+	sessionUser, err := s.userDB.GetSessionUser(r)
+	if err != nil {
+		Critical.Log(err)
+		s.Respond(w, r, "error")
+		return
 	}
+	q := r.URL.Query()
 
-	// parse date to int unix timestamp
-	if formattedDate, err := strconv.ParseInt(q.Get("min_date"), 10, 64); err == nil {
-		searchReq.minDate = formattedDate
-	}
-	if formattedDate, err := strconv.ParseInt(q.Get("max_date"), 10, 64); err == nil {
-		searchReq.maxDate = formattedDate
-	}
-	// parse pagination fields
-	if formattedResultsCount, err := strconv.ParseInt(q.Get("results_per_page"), 10, 64); err == nil {
-		searchReq.resultsPerPage = formattedResultsCount
+	// open a saved search as a smart album by replacing the search criteria with those saved
+	if savedID := q.Get("saved"); savedID != "" {
+		savedSearch, err := s.userDB.GetSavedSearch(savedID, sessionUser.Username)
+		if err != nil {
+			Input.Log(err)
+			s.RespondStatus(w, r, JSONResponse{WarningStatus, "saved_search_not_found"}, http.StatusBadRequest)
+			return
+		}
+		savedSearch.apply(q)
 	}
-	if formattedResultsPage, err := strconv.ParseInt(q.Get("page"), 10, 64); err == nil {
-		searchReq.page = formattedResultsPage
+
+	searchReq, err := s.parseSearchRequest(q, sessionUser)
+	if err != nil {
+		s.respondSearchRequestError(w, r, err)
+		return
 	}
 
 	// perform search
@@ -778,11 +1021,13 @@ func (s *Server) searchMemoriesHandler(w http.ResponseWriter, r *http.Request) {
 	if q.Get("format") == "html_tiled" || q.Get("format") == "html_detailed" {
 		// HTML formatted response
 		templateData := struct {
-			Files  []File
-			Status string
+			Files      []ScoredFile
+			Status     string
+			NextCursor string
 		}{
 			fileResults.Files,
 			fileResults.state,
+			fileResults.NextCursor,
 		}
 		// determine which template format to use
 		templateFile := "/dynamic/templates/files_list_detailed.html"
@@ -806,6 +1051,127 @@ func (s *Server) searchMemoriesHandler(w http.ResponseWriter, r *http.Request) {
 	s.Respond(w, r, filesJSON)
 }
 
+// ErrInvalidSort implies a search was requested in an unknown order.
+var ErrInvalidSort = errors.New("invalid search sort order")
+
+// ErrInvalidPage implies a negative search page or page size was requested.
+var ErrInvalidPage = errors.New("invalid search page")
+
+// ErrInvalidSize implies a negative search file size or a minimum file size greater than the maximum was requested.
+var ErrInvalidSize = errors.New("invalid search file size")
+
+// parseSearchRequest constructs a SearchRequest from /search URL params on behalf of the session user. A *QueryError is
+// returned if the q param is invalid.
+func (s *Server) parseSearchRequest(q url.Values, sessionUser User) (searchReq SearchRequest, err error) {
+	// construct search query from url params
+	searchReq = SearchRequest{
+		description: q.Get("desc"),
+		minDate:     0,
+		maxDate:     0,
+		tags:        ProcessInputList(q.Get("tags"), ",", true),
+		people:      ProcessInputList(q.Get("people"), ",", true),
+		fileTypes:   ProcessInputList(q.Get("file_types"), ",", true),
+	}
+
+	// parse query language
+	if searchReq.query, err = ParseQuery(q.Get("q")); err != nil {
+		return searchReq, err
+	}
+
+	// order by relevance by default
+	searchReq.sort = q.Get("sort")
+	if searchReq.sort == "" {
+		searchReq.sort = SortRelevance
+	}
+	if !searchSorts[searchReq.sort] {
+		return searchReq, ErrInvalidSort
+	}
+	if searchReq.sort == SortFavourites {
+		searchReq.favourites = s.userDB.FavouriteCounts()
+	}
+	if token := q.Get("cursor"); token != "" {
+		if searchReq.cursor, err = decodeSearchCursor(token, searchReq.sort); err != nil {
+			return searchReq, err
+		}
+	}
+
+	// parse date to int unix timestamp
+	if formattedDate, err := strconv.ParseInt(q.Get("min_date"), 10, 64); err == nil {
+		searchReq.minDate = formattedDate
+	}
+	if formattedDate, err := strconv.ParseInt(q.Get("max_date"), 10, 64); err == nil {
+		searchReq.maxDate = formattedDate
+	}
+	// parse pagination fields
+	if formattedResultsCount, err := strconv.ParseInt(q.Get("results_per_page"), 10, 64); err == nil {
+		searchReq.resultsPerPage = formattedResultsCount
+	}
+	if formattedResultsPage, err := strconv.ParseInt(q.Get("page"), 10, 64); err == nil {
+		searchReq.page = formattedResultsPage
+	}
+	if searchReq.page < 0 || searchReq.resultsPerPage < 0 {
+		return searchReq, ErrInvalidPage
+	}
+
+	// parse uploader, extension & size filters
+	searchReq.uploaders = ProcessInputList(q.Get("uploader"), ",", true)
+	for _, extension := range ProcessInputList(q.Get("extension"), ",", true) {
+		searchReq.extensions = append(searchReq.extensions, strings.TrimPrefix(extension, "."))
+	}
+	if minSize, err := strconv.ParseInt(q.Get("min_size"), 10, 64); err == nil {
+		searchReq.minSize = minSize
+	}
+	if maxSize, err := strconv.ParseInt(q.Get("max_size"), 10, 64); err == nil {
+		searchReq.maxSize = maxSize
+	}
+	if searchReq.minSize < 0 || searchReq.maxSize < 0 || (searchReq.maxSize != 0 && searchReq.minSize > searchReq.maxSize) {
+		return searchReq, ErrInvalidSize
+	}
+
+	// parse filters relative to the session user, deleted memories are only included for admins
+	if mine, _ := strconv.ParseBool(q.Get("mine")); mine {
+		searchReq.uploadedBy = sessionUser.Username
+	}
+	if favourites, _ := strconv.ParseBool(q.Get("favourites")); favourites {
+		searchReq.onlyFavourites = true
+		searchReq.favouriteUUIDs = sessionUser.FavouriteFileUUIDs
+	}
+	if includeDeleted, _ := strconv.ParseBool(q.Get("include_deleted")); includeDeleted && sessionUser.Type >= Admin {
+		searchReq.includeDeleted = true
+	}
+
+	return searchReq, nil
+}
+
+// respondSearchRequestError responds with the cause of a parseSearchRequest error. Query language errors include the
+// position & cause of the syntax error.
+func (s *Server) respondSearchRequestError(w http.ResponseWriter, r *http.Request, err error) {
+	Input.Log(err)
+	switch err {
+	case ErrInvalidSort:
+		s.RespondStatus(w, r, JSONResponse{WarningStatus, "invalid_sort"}, http.StatusBadRequest)
+	case ErrInvalidCursor:
+		s.RespondStatus(w, r, JSONResponse{WarningStatus, "invalid_cursor"}, http.StatusBadRequest)
+	case ErrInvalidPage:
+		s.RespondStatus(w, r, JSONResponse{WarningStatus, "invalid_page"}, http.StatusBadRequest)
+	case ErrInvalidSize:
+		s.RespondStatus(w, r, JSONResponse{WarningStatus, "invalid_size"}, http.StatusBadRequest)
+	default:
+		queryErr, ok := err.(*QueryError)
+		if !ok {
+			Critical.Logf("%+v", err)
+			s.RespondStatus(w, r, "error", http.StatusInternalServerError)
+			return
+		}
+		response := struct {
+			Status ResponseStatus `json:"status"`
+			Value  string         `json:"value"`
+			Error  *QueryError    `json:"error"`
+		}{ErrorStatus, "invalid_query", queryErr}
+		s.RespondStatus(w, r, ToJSON(response, false), http.StatusBadRequest)
+	}
+}
+
 // getDataHandler is a HTTP handler which retrieves specific JSON metadata or specific memory data.
 // GET URL params: {
 //     fetch = tags,people,file_types,dates (comma separated list, each is optional),
@@ -862,6 +1228,212 @@ func (s *Server) getDataHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// historyHandler is a HTTP handler which pages through the Transactions which changed a memory or which were made on a
+// service instance, newest first. The history of a memory can only be seen by its uploader & admins, whereas the
+// history of an instance can only be seen by admins. URL params: {
+//     file (a file UUID) or instance (a service instance name, defaults to this instance),
+//     page,
+//     results_per_page,
+//     pretty = [true, false],
+// }
+func (s *Server) historyHandler(w http.ResponseWriter, r *http.Request) {
+	// get session user
+	sessionUser, err := s.userDB.GetSessionUser(r)
+	if err != nil {
+		Critical.Log(err)
+		s.Respond(w, r, "error")
+		return
+	}
+	q := r.URL.Query()
+
+	// parse pagination fields
+	page, _ := strconv.Atoi(q.Get("page"))
+	resultsPerPage, _ := strconv.Atoi(q.Get("results_per_page"))
+	if page < 0 || resultsPerPage < 0 {
+		s.RespondStatus(w, r, "invalid_page", http.StatusBadRequest)
+		return
+	}
+
+	var history TransactionHistory
+	if fileUUID := q.Get("file"); fileUUID != "" {
+		if !s.fileHistoryVisible(fileUUID, sessionUser) {
+			s.RespondStatus(w, r, "file_not_found", http.StatusBadRequest)
+			return
+		}
+		history = s.fileDB.FileHistory(fileUUID, page, resultsPerPage)
+	} else {
+		// instance history contains the changes made to the memories of every user
+		if sessionUser.Type < Admin {
+			s.RespondStatus(w, r, "unauthorised", http.StatusUnauthorized)
+			return
+		}
+		instance := q.Get("instance")
+		if instance == "" {
+			instance = config.InstanceName
+		}
+		history = s.fileDB.InstanceHistory(instance, page, resultsPerPage)
+	}
+
+	prettyPrint, _ := strconv.ParseBool(q.Get("pretty"))
+	s.Respond(w, r, ToJSON(history, prettyPrint))
+}
+
+// fileHistoryVisible determines if a user can see the history of a memory, which includes the metadata of every
+// revision, i.e. while it was unpublished or after it was deleted. Only the uploader of a published or uploaded memory
+// & admins can see its history.
+func (s *Server) fileHistoryVisible(fileUUID string, user User) bool {
+	if user.Type >= Admin {
+		return true
+	}
+	file, ok := s.fileDB.Published.Get(fileUUID)
+	if !ok {
+		file, ok = s.fileDB.Uploaded.Get(fileUUID)
+	}
+	return ok && file.UploaderUsername == user.Username
+}
+
+// trashHandler is a HTTP handler which lists & restores deleted memories. Users can only see & restore the memories
+// they uploaded, whereas admins can see & restore all deleted memories.
+// GET URL params: {
+//     all = [true, false] (admins only, list memories deleted from all users),
+//     format = ["json", "html_tiled", "html_detailed"],
+//     pretty = [true, false],
+// }
+// POST /trash/restore form params: {
+//     fileUUID,
+// }
+func (s *Server) trashHandler(w http.ResponseWriter, r *http.Request) {
+	// get session user
+	sessionUser, err := s.userDB.GetSessionUser(r)
+	if err != nil {
+		Critical.Log(err)
+		s.Respond(w, r, "error")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+
+		username := sessionUser.Username
+		if all, _ := strconv.ParseBool(q.Get("all")); all && sessionUser.Type >= Admin {
+			username = ""
+		}
+		files := s.fileDB.GetTrash(username)
+
+		// respond with JSON or HTML?
+		if q.Get("format") == "html_tiled" || q.Get("format") == "html_detailed" {
+			templateData := struct {
+				Files      []File
+				Status     string
+				NextCursor string
+			}{
+				files,
+				"end_of_results",
+				"",
+			}
+			// determine which template format to use
+			templateFile := "/dynamic/templates/files_list_detailed.html"
+			if q.Get("format") == "html_tiled" {
+				templateFile = "/dynamic/templates/files_list_tiled.html"
+			}
+			if len(files) == 0 {
+				templateFile = "/dynamic/templates/no_match.html"
+			}
+
+			s.Respond(w, r, s.CompleteTemplate(templateFile, templateData))
+			return
+		}
+
+		prettyPrint, _ := strconv.ParseBool(q.Get("pretty"))
+		s.Respond(w, r, ToJSON(files, prettyPrint))
+
+	case http.MethodPost:
+		if mux.Vars(r)["type"] != "restore" {
+			s.RespondStatus(w, r, "invalid request type", http.StatusBadRequest)
+			return
+		}
+		if s.ParseFormBody(w, r) != nil {
+			return
+		}
+
+		// only the uploader or an admin can restore a memory
+		fileUUID := r.Form.Get("fileUUID")
+		file, ok := s.fileDB.Published.Get(fileUUID)
+		if !ok || (file.UploaderUsername != sessionUser.Username && sessionUser.Type < Admin) {
+			s.RespondStatus(w, r, "file_not_found", http.StatusBadRequest)
+			return
+		}
+
+		if err := s.fileDB.RestoreFile(fileUUID, sessionUser.Username); err != nil {
+			switch err {
+			case ErrFileNotFound:
+				s.RespondStatus(w, r, "file_not_found", http.StatusBadRequest)
+			case ErrFileNotDeleted:
+				s.RespondStatus(w, r, "file_not_deleted", http.StatusBadRequest)
+			case ErrFilePurged:
+				s.RespondStatus(w, r, "file_purged", http.StatusBadRequest)
+			default:
+				Critical.Logf("%+v", err)
+				s.RespondStatus(w, r, "restore_error", http.StatusInternalServerError)
+				return
+			}
+			Input.Log(err)
+			return
+		}
+
+		s.Respond(w, r, "success")
+	}
+}
+
+// editMemoryHandler is a HTTP handler which replaces the description, tags & people of a published memory. The MetaData
+// is validated as it is when publishing, and only the uploader or an admin can edit a memory.
+func (s *Server) editMemoryHandler(w http.ResponseWriter, r *http.Request) {
+	// get session user
+	sessionUser, err := s.userDB.GetSessionUser(r)
+	if err != nil {
+		Critical.Log(err)
+		s.Respond(w, r, "error")
+		return
+	}
+	if s.ParseFormBody(w, r) != nil {
+		return
+	}
+
+	fileUUID := mux.Vars(r)["fileUUID"]
+	file, ok := s.fileDB.Published.Get(fileUUID)
+	if !ok || (file.UploaderUsername != sessionUser.Username && sessionUser.Type < Admin) {
+		s.RespondStatus(w, r, "file_not_found", http.StatusBadRequest)
+		return
+	}
+
+	// process tags and people fields
+	metaData := MetaData{
+		Description: r.Form.Get("description-input"),
+		Tags:        ProcessInputList(r.Form.Get("tags-input"), ",", true),
+		People:      ProcessInputList(r.Form.Get("people-input"), ",", true),
+	}
+	if validationIssue := validateMetaData(metaData); validationIssue != "" {
+		s.RespondStatus(w, r, validationIssue, http.StatusBadRequest)
+		return
+	}
+
+	if err := s.fileDB.EditFile(fileUUID, metaData, sessionUser.Username); err != nil {
+		switch err {
+		case ErrFileNotFound:
+			s.RespondStatus(w, r, "file_not_found", http.StatusBadRequest)
+		default:
+			Critical.Logf("%+v", err)
+			s.RespondStatus(w, r, "edit_error", http.StatusInternalServerError)
+			return
+		}
+		Input.Log(err)
+		return
+	}
+
+	s.Respond(w, r, "success")
+}
+
 // processMetadataRequest processes a MetaData fetch request.
 func (s *Server) processMetadataRequest(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
@@ -1043,9 +1615,11 @@ func (s *Server) uploadHandler(w http.ResponseWriter, r *http.Request) {
 		// generate upload description forms for each unpublished image
 		for _, f := range files {
 			uploadTemplateData := struct {
-				UploadedFile File
+				UploadedFile   File
+				NearDuplicates []File
 			}{
 				f,
+				s.fileDB.NearDuplicates(f),
 			}
 
 			result := s.CompleteTemplate("/dynamic/templates/upload_form.html", uploadTemplateData)
@@ -1090,6 +1664,8 @@ func (s *Server) uploadHandler(w http.ResponseWriter, r *http.Request) {
 					s.RespondStatus(w, r, "invalid_file", http.StatusBadRequest)
 				case ErrUnsupportedFormat:
 					s.RespondStatus(w, r, "format_not_supported", http.StatusBadRequest)
+				case ErrQuotaExceeded:
+					s.RespondStatus(w, r, "quota_exceeded", http.StatusBadRequest)
 				default:
 					Critical.Logf("%+v", err)
 					s.RespondStatus(w, r, "upload_error", http.StatusInternalServerError)
@@ -1105,9 +1681,11 @@ func (s *Server) uploadHandler(w http.ResponseWriter, r *http.Request) {
 
 			// html details form response
 			templateData := struct {
-				UploadedFile File
+				UploadedFile   File
+				NearDuplicates []File
 			}{
 				uploadedFile,
+				s.fileDB.NearDuplicates(uploadedFile),
 			}
 			result := s.CompleteTemplate("/dynamic/templates/upload_form.html", templateData)
 			if result == "" {
@@ -1123,12 +1701,14 @@ func (s *Server) uploadHandler(w http.ResponseWriter, r *http.Request) {
 			}
 
 			// remove file
-			if err := s.fileDB.DeleteFile(r.Form.Get("fileUUID")); err != nil {
+			if err := s.fileDB.DeleteFile(r.Form.Get("fileUUID"), sessionUser.Username); err != nil {
 				switch err {
 				case ErrFileNotFound:
 					s.RespondStatus(w, r, "file_not_found", http.StatusBadRequest)
 				case ErrFileAlreadyDeleted:
 					s.RespondStatus(w, r, "file_already_deleted", http.StatusBadRequest)
+				case ErrPublishInProgress:
+					s.RespondStatus(w, r, "publish_in_progress", http.StatusConflict)
 				default:
 					Critical.Logf("%+v", err)
 					s.RespondStatus(w, r, "delete_error", http.StatusInternalServerError)
@@ -1147,39 +1727,23 @@ func (s *Server) uploadHandler(w http.ResponseWriter, r *http.Request) {
 			}
 
 			// process tags and people fields
-			var (
-				desc            = r.Form.Get("description-input")
-				tags            = ProcessInputList(r.Form.Get("tags-input"), ",", true)
-				people          = ProcessInputList(r.Form.Get("people-input"), ",", true)
-				metaData        = MetaData{Description: desc, Tags: tags, People: people}
-				validationIssue = ""
-			)
-
-			// validate form field lengths
-			switch {
-			case len(desc) == 0:
-				validationIssue = "no_description"
-			case len(desc) > config.MaxDescriptionLength:
-				validationIssue = "max_description"
-			case len(tags) == 0:
-				validationIssue = "no_tags"
-			case len(tags) > config.MaxTagsCount:
-				validationIssue = "max_tags"
-			case len(people) == 0:
-				validationIssue = "no_people"
-			case len(people) > config.MaxPeopleCount:
-				validationIssue = "max_people"
+			metaData := MetaData{
+				Description: r.Form.Get("description-input"),
+				Tags:        ProcessInputList(r.Form.Get("tags-input"), ",", true),
+				People:      ProcessInputList(r.Form.Get("people-input"), ",", true),
 			}
-			if validationIssue != "" {
+			if validationIssue := validateMetaData(metaData); validationIssue != "" {
 				s.Respond(w, r, validationIssue)
 				return
 			}
 
 			// add file to DB & move from db/temp dir to db/content dir
-			if err := s.fileDB.PublishFile(r.Form.Get("fileUUID"), metaData); err != nil {
+			if err := s.fileDB.PublishFile(r.Form.Get("fileUUID"), metaData, sessionUser.Username); err != nil {
 				switch err {
 				case ErrFileNotFound:
 					s.Respond(w, r, "file_not_found")
+				case ErrPublishInProgress:
+					s.Respond(w, r, "publish_in_progress")
 				default:
 					Critical.Logf("%+v", err)
 					s.RespondStatus(w, r, "publish_error", http.StatusInternalServerError)
@@ -1195,8 +1759,332 @@ func (s *Server) uploadHandler(w http.ResponseWriter, r *http.Request) {
 
 			// success
 			s.Respond(w, r, "success")
+
+		// publish many files with shared metadata & per file overrides
+		case "bulk_publish":
+			bulkReq, err := decodeBulkPublishRequest(w, r)
+			if err != nil {
+				Input.Log(errors.Wrap(err, "invalid bulk_publish request"))
+				if _, ok := errors.Cause(err).(*http.MaxBytesError); ok {
+					s.RespondStatus(w, r, JSONResponse{WarningStatus, "request_too_large"}, http.StatusRequestEntityTooLarge)
+					return
+				}
+				if err == ErrTooManyFiles {
+					s.RespondStatus(w, r, JSONResponse{WarningStatus, "too_many_files"}, http.StatusBadRequest)
+					return
+				}
+				s.Respond(w, r, JSONResponse{WarningStatus, "invalid request"})
+				return
+			}
+			if len(bulkReq.Files) == 0 {
+				s.Respond(w, r, JSONResponse{WarningStatus, "no_files"})
+				return
+			}
+
+			report := BulkPublishReport{Files: make([]BulkPublishResult, 0, len(bulkReq.Files))}
+			for _, file := range bulkReq.Files {
+				result := BulkPublishResult{UUID: file.UUID, Status: s.bulkPublishFile(file, bulkReq.MetaData, sessionUser)}
+				if result.Status == "success" {
+					report.PublishedCount++
+				} else {
+					report.FailedCount++
+				}
+				report.Files = append(report.Files, result)
+			}
+
+			// increment published count for user
+			if report.PublishedCount > 0 {
+				sessionUser.PublishedCount += report.PublishedCount
+				s.userDB.Users.Set(sessionUser.Username, sessionUser)
+			}
+
+			s.Respond(w, r, ToJSON(report, false))
+		}
+	}
+}
+
+// validateMetaData returns the response code of the first issue which prevents a file being published or edited with
+// the MetaData, or an empty string if it is valid.
+func validateMetaData(metaData MetaData) string {
+	switch {
+	case len(metaData.Description) == 0:
+		return "no_description"
+	case len(metaData.Description) > config.MaxDescriptionLength:
+		return "max_description"
+	case len(metaData.Tags) == 0:
+		return "no_tags"
+	case len(metaData.Tags) > config.MaxTagsCount:
+		return "max_tags"
+	case len(metaData.People) == 0:
+		return "no_people"
+	case len(metaData.People) > config.MaxPeopleCount:
+		return "max_people"
+	}
+	return ""
+}
+
+// BulkMetaData is the MetaData of a bulk publish request. Fields which are not set (i.e. are null) are not applied.
+type BulkMetaData struct {
+	Description *string  `json:"description"`
+	Tags        []string `json:"tags"`
+	People      []string `json:"people"`
+}
+
+// BulkPublishFile is a file to be published by a bulk publish request. Any MetaData fields set on the file override the
+// shared MetaData of the request.
+type BulkPublishFile struct {
+	UUID string `json:"uuid"`
+	BulkMetaData
+}
+
+// BulkPublishRequest represents a request to publish many uploaded files at once.
+type BulkPublishRequest struct {
+	MetaData BulkMetaData      `json:"metadata"`
+	Files    []BulkPublishFile `json:"files"`
+}
+
+// bulkPublishBytesPerFile is the request body size allowed for each file of a bulk publish request, plus one for the
+// shared MetaData.
+const bulkPublishBytesPerFile = 16 * 1024
+
+// ErrTooManyFiles implies a bulk publish request contains more files than the configured maximum.
+var ErrTooManyFiles = errors.New("bulk publish request exceeds the maximum number of files")
+
+// decodeBulkPublishRequest decodes the JSON body of a bulk publish request. Each file is published in turn, so the
+// number of files & the size of the body are limited to prevent a single request occupying the FileDB.
+func decodeBulkPublishRequest(w http.ResponseWriter, r *http.Request) (bulkReq BulkPublishRequest, err error) {
+	r.Body = http.MaxBytesReader(w, r.Body, int64(config.MaxBulkPublishFiles+1)*bulkPublishBytesPerFile)
+	if err = json.NewDecoder(r.Body).Decode(&bulkReq); err != nil {
+		return bulkReq, err
+	}
+	if len(bulkReq.Files) > config.MaxBulkPublishFiles {
+		return bulkReq, ErrTooManyFiles
+	}
+	return bulkReq, nil
+}
+
+// BulkPublishResult is the outcome of publishing a single file of a bulk publish request. The status is "success" or
+// the response code of the failure, as returned by the single file publish request.
+type BulkPublishResult struct {
+	UUID   string `json:"uuid"`
+	Status string `json:"status"`
+}
+
+// BulkPublishReport reports the outcome of a bulk publish request for each file.
+type BulkPublishReport struct {
+	PublishedCount int                 `json:"published_count"`
+	FailedCount    int                 `json:"failed_count"`
+	Files          []BulkPublishResult `json:"files"`
+}
+
+// resolve applies the overrides of a file to the shared MetaData of a bulk publish request, processing the tags & people
+// in the same way as the single file publish request.
+func (f BulkPublishFile) resolve(shared BulkMetaData) (metaData MetaData) {
+	description, tags, people := shared.Description, shared.Tags, shared.People
+	if f.Description != nil {
+		description = f.Description
+	}
+	if f.Tags != nil {
+		tags = f.Tags
+	}
+	if f.People != nil {
+		people = f.People
+	}
+
+	if description != nil {
+		metaData.Description = *description
+	}
+	metaData.Tags = ProcessInputList(strings.Join(tags, ","), ",", true)
+	metaData.People = ProcessInputList(strings.Join(people, ","), ",", true)
+	return
+}
+
+// bulkPublishFile publishes a single file of a bulk publish request, returning "success" or the response code of the
+// failure. Only files uploaded by the session user can be published.
+func (s *Server) bulkPublishFile(file BulkPublishFile, shared BulkMetaData, sessionUser User) string {
+	metaData := file.resolve(shared)
+	if validationIssue := validateMetaData(metaData); validationIssue != "" {
+		return validationIssue
+	}
+
+	if uploaded, ok := s.fileDB.Uploaded.Get(file.UUID); !ok || uploaded.UploaderUsername != sessionUser.Username {
+		return "file_not_found"
+	}
+
+	switch err := s.fileDB.PublishFile(file.UUID, metaData, sessionUser.Username); err {
+	case nil:
+		return "success"
+	case ErrFileNotFound:
+		Input.Log(err)
+		return "file_not_found"
+	case ErrPublishInProgress:
+		Input.Log(err)
+		return "publish_in_progress"
+	default:
+		Critical.Logf("%+v", err)
+		return "publish_error"
+	}
+}
+
+// tusVersion is the version of the tus resumable upload protocol supported by resumableUploadHandler.
+const tusVersion = "1.0.0"
+
+// resumableUploadHandler implements the core, creation, expiration & termination extensions of the tus resumable upload
+// protocol (https://tus.io/protocols/resumable-upload.html). The file name is provided by the "filename" key of the
+// Upload-Metadata header on creation. Each chunk must complete within the server's read timeout, so clients should send
+// chunks of a few MB. Once the final chunk has been received, the upload is processed in the same way as /upload/temp
+// and the UUID of the new upload is set in the Memory-UUID header. The UUIDs of any visually similar published memories
+// are set in the Memory-Near-Duplicates header as a comma separated list.
+func (s *Server) resumableUploadHandler(w http.ResponseWriter, r *http.Request) {
+	// get session user
+	sessionUser, err := s.userDB.GetSessionUser(r)
+	if err != nil {
+		Critical.Log(err)
+		s.Respond(w, r, "error")
+		return
+	}
+	if sessionUser.Type == Guest {
+		s.RespondStatus(w, r, "unauthorised", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", "creation,expiration,termination")
+		w.Header().Set("Tus-Max-Size", strconv.Itoa(s.maxFileUploadSize))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		s.RespondStatus(w, r, "unsupported_tus_version", http.StatusPreconditionFailed)
+		return
+	}
+
+	var upload ResumableUpload
+	var uploadedFile File
+	ID := mux.Vars(r)["id"]
+
+	switch r.Method {
+	// create a new upload
+	case http.MethodPost:
+		length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil {
+			s.RespondStatus(w, r, "invalid_upload_length", http.StatusBadRequest)
+			return
+		}
+		upload, err = s.fileDB.CreateResumableUpload(sessionUser, parseUploadMetadata(r.Header.Get("Upload-Metadata"))["filename"], length, int64(s.maxFileUploadSize))
+
+	// get the offset to resume from
+	case http.MethodHead:
+		upload, err = s.fileDB.GetResumableUpload(ID, sessionUser.Username)
+
+	// append a chunk
+	case http.MethodPatch:
+		if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+			s.RespondStatus(w, r, "invalid_content_type", http.StatusUnsupportedMediaType)
+			return
 		}
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil {
+			s.RespondStatus(w, r, "invalid_upload_offset", http.StatusBadRequest)
+			return
+		}
+		upload, uploadedFile, err = s.fileDB.WriteResumableUpload(ID, sessionUser, offset, r.Body)
+
+	// terminate an upload
+	case http.MethodDelete:
+		err = s.fileDB.DeleteResumableUpload(ID, sessionUser.Username)
 	}
+
+	if err != nil {
+		if err, ok := err.(*FileExistsError); ok {
+			s.RespondStatus(w, r, err.ConstructResponse(), http.StatusBadRequest)
+			return
+		}
+
+		switch err {
+		case ErrUploadNotFound:
+			s.RespondStatus(w, r, "upload_not_found", http.StatusNotFound)
+		case ErrUploadOffsetMismatch:
+			s.RespondStatus(w, r, "offset_mismatch", http.StatusConflict)
+		case ErrInvalidUploadLength:
+			s.RespondStatus(w, r, "invalid_upload_length", http.StatusBadRequest)
+		case ErrUploadTooLarge:
+			s.RespondStatus(w, r, "upload_too_large", http.StatusRequestEntityTooLarge)
+		case ErrUploadLocked:
+			s.RespondStatus(w, r, "upload_locked", http.StatusLocked)
+		case ErrInvalidFile:
+			s.RespondStatus(w, r, "invalid_file", http.StatusBadRequest)
+		case ErrUnsupportedFormat:
+			s.RespondStatus(w, r, "format_not_supported", http.StatusBadRequest)
+		case ErrQuotaExceeded:
+			s.RespondStatus(w, r, "quota_exceeded", http.StatusBadRequest)
+		default:
+			Critical.Logf("%+v", err)
+			s.RespondStatus(w, r, "upload_error", http.StatusInternalServerError)
+			return
+		}
+		Input.Log(err)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	if expires, ok := upload.Expires(); ok && upload.Offset < upload.Length {
+		w.Header().Set("Upload-Expires", expires.UTC().Format(http.TimeFormat))
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		w.Header().Set("Location", "/upload/resumable/"+upload.ID)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPatch:
+		if uploadedFile.UUID != "" {
+			// increment uploads count for user
+			sessionUser.UploadsCount++
+			s.userDB.Users.Set(sessionUser.Username, sessionUser)
+			w.Header().Set("Memory-UUID", uploadedFile.UUID)
+			// warn of visually similar published memories
+			var duplicateUUIDs []string
+			for _, duplicate := range s.fileDB.NearDuplicates(uploadedFile) {
+				duplicateUUIDs = append(duplicateUUIDs, duplicate.UUID)
+			}
+			if len(duplicateUUIDs) > 0 {
+				w.Header().Set("Memory-Near-Duplicates", strings.Join(duplicateUUIDs, ","))
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// parseUploadMetadata decodes a tus Upload-Metadata header, which is a comma separated list of keys & base64 encoded
+// values separated by a space.
+func parseUploadMetadata(header string) map[string]string {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 {
+			continue
+		}
+		value := ""
+		if len(fields) > 1 {
+			if decoded, err := base64.StdEncoding.DecodeString(fields[1]); err == nil {
+				value = string(decoded)
+			}
+		}
+		metadata[fields[0]] = value
+	}
+	return metadata
 }
 
 // Respond writes a HTTP response to a ResponseWriter with a status code of 200.
@@ -1309,16 +2197,46 @@ func (s *Server) CompleteTemplate(filePath string, data interface{}) (result tem
 	return template.HTML(buffer.String())
 }
 
+// startJob runs a background job until the stop channel is closed. Stop waits for every job to return before the
+// stores are closed.
+func (s *Server) startJob(job func(stop <-chan struct{})) {
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+		job(s.stop)
+	}()
+}
+
 // Stop gracefully stops the HTTP server.
 func (s *Server) Stop() context.CancelFunc {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	if err := s.Shutdown(ctx); err != nil {
 		Info.Log(err)
 	}
+	close(s.stop)
+	s.jobs.Wait()
+
+	// allow the stores to compact their data so that the next startup is quicker
+	if err := s.fileDB.Close(); err != nil {
+		Critical.Log(err)
+	}
+	if err := s.userDB.Close(); err != nil {
+		Critical.Log(err)
+	}
 
 	return cancel
 }
 
+// Restore restores the published memories to a point in time on behalf of the actor. See FileDB.Restore.
+func (s *Server) Restore(target string, actor string, dryRun bool) (RestoreReport, error) {
+	return s.fileDB.Restore(target, actor, dryRun)
+}
+
+// Fsck checks the integrity of the stored memories. See FileDB.Fsck.
+func (s *Server) Fsck(opts FsckOptions) FsckReport {
+	return s.fileDB.Fsck(opts)
+}
+
 // ParseFormBody parses a request's form based body.
 func (s *Server) ParseFormBody(w http.ResponseWriter, r *http.Request) (err error) {
 	if err = r.ParseForm(); err != nil {
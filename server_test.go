@@ -0,0 +1,186 @@
+package memoryshare
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBulkPublishFile(t *testing.T) {
+	db, _ := newTestFileDB(t, GobBackend)
+	config.MaxDescriptionLength, config.MaxTagsCount, config.MaxPeopleCount = 100, 2, 2
+	s := &Server{fileDB: db}
+
+	for _, UUID := range []string{"a", "b", "c"} {
+		uploadTestFile(t, db, UUID, "content of "+UUID)
+	}
+	other := uploadTestFile(t, db, "d", "content of d")
+	other.UploaderUsername = "eve"
+	db.Uploaded.Set(other.UUID, other)
+
+	var req BulkPublishRequest
+	body := `{
+		"metadata": {"description": "trip", "tags": ["Beach", " sea "], "people": ["Bob"]},
+		"files": [
+			{"uuid": "a"},
+			{"uuid": "b", "description": "own", "tags": ["x", "y", "z"]},
+			{"uuid": "c", "people": ["Eve", "Al"], "tags": ["only"]},
+			{"uuid": "d"},
+			{"uuid": "missing"}
+		]
+	}`
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+
+	var statuses []string
+	for _, file := range req.Files {
+		statuses = append(statuses, s.bulkPublishFile(file, req.MetaData, User{Username: "bob"}))
+	}
+	if got := strings.Join(statuses, ","); got != "success,max_tags,success,file_not_found,file_not_found" {
+		t.Fatalf("expected each file to be published or rejected independently, got %v", got)
+	}
+
+	// the shared metadata is processed like that of a single file publish request
+	a, _ := db.Published.Get("a")
+	sort.Strings(a.Tags)
+	if a.Description != "trip" || strings.Join(a.Tags, ",") != "beach,sea" || strings.Join(a.People, ",") != "bob" {
+		t.Fatalf("expected a to be published with the shared metadata, got %+v", a.MetaData)
+	}
+	// overrides replace the shared metadata
+	c, _ := db.Published.Get("c")
+	if c.Description != "trip" || strings.Join(c.Tags, ",") != "only" || len(c.People) != 2 {
+		t.Fatalf("expected c to be published with its overrides, got %+v", c.MetaData)
+	}
+	if _, ok := db.Uploaded.Get("b"); !ok {
+		t.Fatal("expected b to remain uploaded")
+	}
+	if _, ok := db.Uploaded.Get("d"); !ok {
+		t.Fatal("expected another user's upload to remain uploaded")
+	}
+}
+
+func TestDecodeBulkPublishRequest(t *testing.T) {
+	newTestConfig(t, GobBackend)
+	config.MaxBulkPublishFiles = 2
+
+	decode := func(body string) error {
+		r := httptest.NewRequest(http.MethodPost, "/upload/bulk_publish", strings.NewReader(body))
+		_, err := decodeBulkPublishRequest(httptest.NewRecorder(), r)
+		return err
+	}
+
+	if err := decode(`{"files": [{"uuid": "a"}, {"uuid": "b"}]}`); err != nil {
+		t.Fatalf("expected the maximum number of files to be accepted, got %v", err)
+	}
+	if err := decode(`{"files": [{"uuid": "a"}, {"uuid": "b"}, {"uuid": "c"}]}`); err != ErrTooManyFiles {
+		t.Fatalf("expected %v, got %v", ErrTooManyFiles, err)
+	}
+
+	// a body larger than the allowance for the maximum number of files is not read in full
+	huge := fmt.Sprintf(`{"metadata": {"description": %q}, "files": [{"uuid": "a"}]}`,
+		strings.Repeat("x", 3*bulkPublishBytesPerFile))
+	if _, ok := decode(huge).(*http.MaxBytesError); !ok {
+		t.Fatalf("expected the body size to be limited, got %v", decode(huge))
+	}
+}
+
+func TestParseSearchRequestFilters(t *testing.T) {
+	s := &Server{}
+	params, err := url.ParseQuery("include_deleted=true&mine=1&favourites=true&extension=.JPG,png&uploader=Bob&min_size=5&max_size=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := User{Username: "eve", Type: Standard, FavouriteFileUUIDs: map[string]bool{"x": true}}
+	req, err := s.parseSearchRequest(params, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(req.extensions)
+	if strings.Join(req.extensions, ",") != "jpg,png" || strings.Join(req.uploaders, ",") != "bob" {
+		t.Errorf("expected lower case extensions without dots & uploaders, got %v & %v", req.extensions, req.uploaders)
+	}
+	if req.minSize != 5 || req.maxSize != 10 {
+		t.Errorf("expected sizes 5 to 10, got %v to %v", req.minSize, req.maxSize)
+	}
+	if req.uploadedBy != "eve" || !req.onlyFavourites || !req.favouriteUUIDs["x"] {
+		t.Errorf("expected the uploads & favourites of the session user, got %q & %v", req.uploadedBy, req.favouriteUUIDs)
+	}
+	if req.includeDeleted {
+		t.Error("expected only admins to be able to include deleted memories")
+	}
+	if req, _ = s.parseSearchRequest(params, User{Username: "admin", Type: Admin}); !req.includeDeleted {
+		t.Error("expected admins to be able to include deleted memories")
+	}
+
+	for _, invalid := range []string{"min_size=-1", "max_size=-1", "min_size=10&max_size=5"} {
+		params, _ = url.ParseQuery(invalid)
+		if _, err = s.parseSearchRequest(params, user); err != ErrInvalidSize {
+			t.Errorf("%v: expected %v, got %v", invalid, ErrInvalidSize, err)
+		}
+	}
+	// a maximum size of 0 is no maximum
+	params, _ = url.ParseQuery("min_size=10&max_size=0")
+	if _, err = s.parseSearchRequest(params, user); err != nil {
+		t.Errorf("expected no maximum size, got %v", err)
+	}
+}
+
+func TestServerStopWaitsForJobs(t *testing.T) {
+	db, dir := newTestFileDB(t, GobBackend)
+	userDB, _, err := openUserDB(dir+"/db", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{fileDB: db, userDB: userDB, stop: make(chan struct{}), Server: &http.Server{}}
+
+	// a job which is part way through a pass when the server is stopped
+	var finished int32
+	s.startJob(func(stop <-chan struct{}) {
+		<-stop
+		time.Sleep(50 * time.Millisecond)
+		atomic.StoreInt32(&finished, 1)
+	})
+
+	s.Stop()()
+	if atomic.LoadInt32(&finished) != 1 {
+		t.Fatal("expected the stores to be closed only once background jobs have returned")
+	}
+}
+
+func TestFileHistoryVisible(t *testing.T) {
+	db, _ := newTestFileDB(t, GobBackend)
+	s := &Server{fileDB: db}
+
+	uploaded := uploadTestFile(t, db, "uploaded", "uploaded content")
+	deleted := uploadTestFile(t, db, "deleted", "deleted content")
+	db.Uploaded.Delete(deleted.UUID)
+	deleted.State = Deleted
+	db.Published.Set(deleted.UUID, deleted)
+
+	bob, eve, admin := User{Username: "bob"}, User{Username: "eve"}, User{Username: "admin", Type: Admin}
+	for _, fileUUID := range []string{uploaded.UUID, deleted.UUID} {
+		if !s.fileHistoryVisible(fileUUID, bob) {
+			t.Errorf("expected the uploader to see the history of %v", fileUUID)
+		}
+		if s.fileHistoryVisible(fileUUID, eve) {
+			t.Errorf("expected other users not to see the history of %v", fileUUID)
+		}
+		if !s.fileHistoryVisible(fileUUID, admin) {
+			t.Errorf("expected admins to see the history of %v", fileUUID)
+		}
+	}
+
+	// the history of a discarded upload remains visible to admins only
+	if s.fileHistoryVisible("discarded", bob) || !s.fileHistoryVisible("discarded", admin) {
+		t.Error("expected only admins to see the history of a memory which no longer exists")
+	}
+}
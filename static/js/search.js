@@ -1,5 +1,5 @@
 var maxAutoCompleteSuggestions = 5; // Number of autocomplete results to show under tokenfield inputs.
-var currentPage = 0; // Current pagination page (start at 0th page).
+var nextCursor = ""; // Pagination cursor of the page following the last page of results shown.
 var tokenfieldTargetTrigger = true; // Prevents window resizing from triggering performSearch() by temporarily ignoring event listeners.
 var preventSearches = true; // used to set up UI without change() events triggering a search.
 
@@ -9,6 +9,8 @@ $(document).ready(function() {
     if (window.location.pathname === "/" || memoryUUIDSpecified) {
         // init search/filter inputs
         $("#desc-search-input").val("").on("input", performSearch);
+        $("#uploader-search-input, #extension-search-input, #min-size-search-input, #max-size-search-input").val("").on("input", performSearch);
+        $("#favourites-search-input, #mine-search-input, #deleted-search-input").prop("checked", false).change(performSearch);
 
         // init pagination dropdown
         $("#count-search-input").change(performSearch);
@@ -215,10 +217,15 @@ function performSearch(append) {
     var scrollY = $(window).scrollTop();
 
     if (append !== true && append !== false) append = false;
-    if (append === true) {
-        currentPage++;
-    } else {
-        currentPage = 0;
+    if (append === false) {
+        nextCursor = "";
+    }
+
+    // a minimum size greater than the maximum size is rejected by the server (a maximum of 0 is no maximum)
+    var sizes = [sizeInputBytes("#min-size-search-input"), sizeInputBytes("#max-size-search-input")];
+    if (sizes[0] !== "" && sizes[1] > 0 && sizes[0] > sizes[1]) {
+        notifier.queueAlert("The min size must not be greater than the max size.", "warning");
+        return;
     }
 
     var request = constructSearchURL();
@@ -243,6 +250,7 @@ function performSearch(append) {
 
             // extract response state which states whether there are more results
             var state = $("#response-status").attr("data-value");
+            nextCursor = $("#response-status").attr("data-next-cursor") || "";
             $("#response-status").remove();
 
             if (state === "empty_results") {
@@ -284,10 +292,23 @@ function constructSearchURL() {
     var resultsPerPage = $("#count-search-input :selected").val();
 
     var request = "/search?desc=" + $("#desc-search-input").val() + "&min_date=" + dates[0] + "&max_date=" + dates[1] + "&tags=" + tokenfieldTags[0] + "&people=" + tokenfieldTags[1];
-    request += "&file_types=" + tokenfieldTags[2] + "&format=" + format + "&results_per_page=" + resultsPerPage + "&page=" + currentPage;
+    request += "&file_types=" + tokenfieldTags[2] + "&format=" + format + "&results_per_page=" + resultsPerPage + "&cursor=" + nextCursor;
+    request += "&uploader=" + encodeURIComponent($("#uploader-search-input").val()) + "&extension=" + encodeURIComponent($("#extension-search-input").val());
+    request += "&min_size=" + sizeInputBytes("#min-size-search-input") + "&max_size=" + sizeInputBytes("#max-size-search-input");
+    request += "&favourites=" + $("#favourites-search-input").is(":checked") + "&mine=" + $("#mine-search-input").is(":checked");
+    request += "&include_deleted=" + $("#deleted-search-input").is(":checked");
     return request;
 }
 
+// Convert the MB value of a size input to bytes, or an empty string if no valid size is set.
+function sizeInputBytes(input) {
+    var size = parseFloat($(input).val());
+    if (isNaN(size) || size < 0) {
+        return "";
+    }
+    return Math.round(size * 1024 * 1024);
+}
+
 // Init search result tiles.
 function initSearchTiles(overlayOnClick) {
     // freewall tiled images
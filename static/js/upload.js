@@ -29,12 +29,21 @@ $(document).ready(function() {
                 else if (errorMessage === "already_published_self") {
                     refinedError = "You have already published a copy of '" + file.name + "' to memories."
                 }
+                else if (errorMessage === "already_deleted") {
+                    refinedError = "A copy of '" + file.name + "' has been deleted by another user, but is still in their trash."
+                }
+                else if (errorMessage === "already_deleted_self") {
+                    refinedError = "You have deleted a copy of '" + file.name + "' - restore it from your trash instead."
+                }
                 else if (errorMessage === "format_not_supported") {
                     refinedError = "The file type of '" + file.name + "' is unsupported."
                 }
                 else if (errorMessage === "invalid_file") {
                     refinedError = "The file '" + file.name + "' is invalid."
                 }
+                else if (errorMessage === "quota_exceeded") {
+                    refinedError = "Uploading '" + file.name + "' would exceed your storage quota."
+                }
                 else if (errorMessage.indexOf("File is too big") !== -1) {
                     refinedError = "The file '" + file.name + "' is too large."
                 }
@@ -117,6 +126,9 @@ function initUploadForm() {
                 else if (result === "max_people") {
                     panel.find(".btn-primary").attr("title", "Must provide no more than " + $("#max-people-count").attr("data-size") + " people.").tooltip('fixTitle').tooltip('show');
                 }
+                else if (result === "publish_in_progress") {
+                    panel.find(".btn-primary").attr("title", "This memory is already being published.").tooltip('fixTitle').tooltip('show');
+                }
                 else if (result === "already_stored") {
                     notifier.queueAlert("A copy of this file has already been stored!", "warning");
                     panel.fadeOut(500, function () {
@@ -150,6 +162,9 @@ function initUploadForm() {
                         $('#upload-results-panel').delay(200).masonry('reloadItems').masonry();
                     });
                 }
+                else if (result === "publish_in_progress") {
+                    notifier.queueAlert("This memory is being published and can no longer be deleted.", "warning");
+                }
                 else if (result === "file_not_found" || result === "file_already_deleted" || result === "delete_error") {
                     notifier.queueAlert("File has already been deleted!", "success");
 
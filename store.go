@@ -0,0 +1,343 @@
+package memoryshare
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FileStore persists the contents of a FileDB. The FileDB maps hold the working copy of the data and every change made
+// via a FileMapMutex or the TransactionMutex is written through to the FileStore.
+type FileStore interface {
+	// Load populates the FileDB with the stored data, returning the schema version it was stored with. The caller must
+	// hold all FileDB locks.
+	Load(db *FileDB) (schemaVersion int, err error)
+	// SetFile stores a File in the named FileMapMutex.
+	SetFile(mapName string, UUID string, file File) error
+	// DeleteFile removes a File from the named FileMapMutex.
+	DeleteFile(mapName string, UUID string) error
+	// AddTransaction stores a new Transaction.
+	AddTransaction(transaction Transaction) error
+	// Checkpoint is called after each FileDB operation and when the service stops (with force set), allowing the
+	// store to compact its data. The caller must not hold any FileDB locks.
+	Checkpoint(db *FileDB, force bool) error
+	// SetSchemaVersion records the schema version of the stored data once it has been migrated.
+	SetSchemaVersion(schemaVersion int) error
+	// Reset deletes all stored data.
+	Reset() error
+	// Close releases any resources held by the store.
+	Close() error
+}
+
+// UserStore persists the contents of a UserDB. The UserMapMutex holds the working copy of the data and every change
+// made via it is written through to the UserStore.
+type UserStore interface {
+	// Load populates the UserDB with the stored data, returning the schema version it was stored with. The caller must
+	// hold the UserMapMutex lock.
+	Load(db *UserDB) (schemaVersion int, err error)
+	// SetUser stores a User.
+	SetUser(username string, user User) error
+	// DeleteUser removes a User.
+	DeleteUser(username string) error
+	// Checkpoint is called after each UserDB operation. The caller must not hold the UserMapMutex lock.
+	Checkpoint(db *UserDB) error
+	// SetSchemaVersion records the schema version of the stored data once it has been migrated.
+	SetSchemaVersion(schemaVersion int) error
+	// Close releases any resources held by the store.
+	Close() error
+}
+
+const (
+	// GobBackend stores each DB as a gob encoded snapshot file. Changes to the FileDB are appended to a log between
+	// snapshots.
+	GobBackend = "gob"
+	// KVBackend stores each File, Transaction & User as a separate record in a directory based key-value store. Each
+	// record is replaced atomically, but there are no transactions spanning several records (see kvStore).
+	KVBackend = "kv"
+)
+
+// ErrUnknownBackend implies the configured storage backend is not supported.
+var ErrUnknownBackend = errors.New("unknown storage backend")
+
+// ErrReadOnlyStore implies a change was written to a store which was opened read only.
+var ErrReadOnlyStore = errors.New("store is read only")
+
+// NewFileStore creates the FileStore for the configured storage backend. A read only store loads the stored data
+// without creating, repairing or compacting anything on disk, & rejects every change.
+func NewFileStore(dbDir string, readOnly bool) (FileStore, error) {
+	switch config.StorageBackend {
+	case GobBackend:
+		return newGobFileStore(dbDir, readOnly)
+	case KVBackend:
+		return newKVFileStore(dbDir, readOnly)
+	}
+	return nil, ErrUnknownBackend
+}
+
+// NewUserStore creates the UserStore for the configured storage backend. A read only store loads the stored data
+// without creating anything on disk, & rejects every change.
+func NewUserStore(dbDir string, readOnly bool) (UserStore, error) {
+	switch config.StorageBackend {
+	case GobBackend:
+		return &gobUserStore{file: dbDir + "/user_db.dat", readOnly: readOnly}, nil
+	case KVBackend:
+		return newKVUserStore(dbDir, readOnly)
+	}
+	return nil, ErrUnknownBackend
+}
+
+// gobFileStore stores the FileDB as a gob snapshot file plus a FileDBLog of the changes made since the snapshot.
+type gobFileStore struct {
+	file          string
+	log           *FileDBLog
+	missing       bool // the snapshot file does not exist yet
+	schemaVersion int
+	logSequence   uint64 // sequence of the last log entry contained in the loaded snapshot
+	readOnly      bool
+}
+
+// newGobFileStore opens the gob snapshot store & its log of changes.
+func newGobFileStore(dbDir string, readOnly bool) (*gobFileStore, error) {
+	openLog := OpenFileDBLog
+	if readOnly {
+		openLog = OpenFileDBLogReadOnly
+	}
+	fileLog, err := openLog(dbDir + "/file_db.log")
+	if err != nil {
+		return nil, err
+	}
+	return &gobFileStore{file: dbDir + "/file_db.dat", log: fileLog, readOnly: readOnly}, nil
+}
+
+// Load deserializes the snapshot envelope to the FileDB structure, overwriting current map values, then replays all
+// changes logged since the snapshot was taken. If the snapshot cannot be decoded, the newest backup which decodes cleanly
+// is used instead & the log generations written since the backup are replayed before the current log. Any changes which
+// are missing from the logs are reported as recovery warnings.
+func (s *gobFileStore) Load(db *FileDB) (schemaVersion int, err error) {
+	// decode into an empty FileDB so that a failed attempt does not leave partially decoded data behind
+	var snapshot *FileDB
+	target, err := ReadFileWithBackups(s.file, config.BackupGenerations, func(r io.Reader) error {
+		snapshot = &FileDB{}
+		envelope, err := readEnvelope(r, snapshot)
+		s.schemaVersion, s.logSequence = envelope.SchemaVersion, envelope.LogSequence
+		return err
+	})
+
+	switch {
+	// if db file does not exist, a new one is created from any logged changes on the next checkpoint
+	case err != nil && os.IsNotExist(errors.Cause(err)):
+		s.missing = true
+
+	case err != nil:
+		return 0, err
+
+	default:
+		// empty maps are not encoded by gob
+		if snapshot.Published.Files != nil {
+			db.Published.Files = snapshot.Published.Files
+		}
+		if snapshot.Uploaded.Files != nil {
+			db.Uploaded.Files = snapshot.Uploaded.Files
+		}
+		if snapshot.FileTransactions.Transactions != nil {
+			db.FileTransactions.Transactions = snapshot.FileTransactions.Transactions
+		}
+	}
+
+	// apply changes made after the snapshot was taken, noting any gap in the sequence of changes
+	last := s.logSequence
+	apply := func(entry logEntry) {
+		if entry.Sequence != 0 {
+			if entry.Sequence <= last {
+				return
+			}
+			if entry.Sequence > last+1 {
+				warning := fmt.Sprintf("FileDB changes %d to %d were lost as they are not in %v or any log generation",
+					last+1, entry.Sequence-1, target)
+				Critical.Log(warning)
+				db.recoveryWarnings = append(db.recoveryWarnings, warning)
+			}
+			last = entry.Sequence
+		}
+
+		switch entry.Operation {
+		case setFileOp, deleteFileOp:
+			fm := &db.Published
+			if entry.MapName == db.Uploaded.name {
+				fm = &db.Uploaded
+			}
+
+			if entry.Operation == setFileOp {
+				fm.Files[entry.UUID] = entry.File
+			} else {
+				delete(fm.Files, entry.UUID)
+			}
+
+		case createTransactionOp:
+			db.FileTransactions.Transactions = append(db.FileTransactions.Transactions, entry.Transaction)
+		}
+	}
+
+	// a backup snapshot is older than the log, so first replay the log generations rotated since it was written
+	if target != s.file {
+		generation, _ := strconv.Atoi(strings.TrimPrefix(target, s.file+"."))
+		warning := fmt.Sprintf("%v could not be read, recovered from backup generation %d", s.file, generation)
+		db.recoveryWarnings = append(db.recoveryWarnings, warning)
+
+		for ; generation > 0; generation-- {
+			if err = s.log.ReplayGeneration(generation, apply); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	err = s.log.Replay(last, apply)
+	return s.schemaVersion, err
+}
+
+// SetFile appends a File update to the log.
+func (s *gobFileStore) SetFile(mapName string, UUID string, file File) error {
+	return s.log.Append(logEntry{Operation: setFileOp, MapName: mapName, UUID: UUID, File: file})
+}
+
+// DeleteFile appends a File removal to the log.
+func (s *gobFileStore) DeleteFile(mapName string, UUID string) error {
+	return s.log.Append(logEntry{Operation: deleteFileOp, MapName: mapName, UUID: UUID})
+}
+
+// AddTransaction appends a new Transaction to the log.
+func (s *gobFileStore) AddTransaction(transaction Transaction) error {
+	return s.log.Append(logEntry{Operation: createTransactionOp, Transaction: transaction})
+}
+
+// Checkpoint compacts the log into a new snapshot once the number of logged changes reaches the configured snapshot
+// interval. Changes are durable once logged, so this only bounds the size of the log & the startup replay time.
+func (s *gobFileStore) Checkpoint(db *FileDB, force bool) error {
+	if s.readOnly {
+		return ErrReadOnlyStore
+	}
+	if !force && !s.missing && s.log.Count() < config.SnapshotInterval {
+		return nil
+	}
+
+	db.LockAll()
+	defer db.UnlockAll()
+
+	if err := s.snapshot(db); err != nil {
+		return err
+	}
+
+	// logged changes are now contained in the snapshot, but are kept until the snapshot's backup generations expire
+	return s.log.Rotate(config.BackupGenerations)
+}
+
+// snapshot encodes & atomically replaces the DB file, keeping previous generations as backups. The snapshot records the
+// sequence of the last logged change it contains, so that the change is not replayed again if the process stops before
+// the log is truncated. The caller must hold all FileDB locks.
+func (s *gobFileStore) snapshot(db *FileDB) error {
+	s.logSequence = s.log.Sequence()
+	err := WriteFileAtomic(s.file, config.BackupGenerations, func(w io.Writer) error {
+		return writeEnvelope(w, s.schemaVersion, s.logSequence, db)
+	})
+	if err != nil {
+		return err
+	}
+	s.missing = false
+	return nil
+}
+
+// SetSchemaVersion sets the schema version written to the next snapshot.
+func (s *gobFileStore) SetSchemaVersion(schemaVersion int) error {
+	s.schemaVersion = schemaVersion
+	return nil
+}
+
+// Reset deletes the snapshot file & all logged changes.
+func (s *gobFileStore) Reset() error {
+	if s.readOnly {
+		return ErrReadOnlyStore
+	}
+	if err := os.Remove(s.file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.missing = true
+	return s.log.Truncate()
+}
+
+// Close closes the log.
+func (s *gobFileStore) Close() error {
+	return s.log.Close()
+}
+
+// gobUserStore stores the UserDB as a single gob file which is rewritten in full on every checkpoint.
+type gobUserStore struct {
+	file          string
+	schemaVersion int
+	readOnly      bool
+}
+
+// Load deserializes a file envelope to the UserDB structure, overwriting current map values. If the file cannot be
+// decoded, the newest backup which decodes cleanly is used instead.
+func (s *gobUserStore) Load(db *UserDB) (schemaVersion int, err error) {
+	// decode into an empty UserDB so that a failed attempt does not leave partially decoded data behind
+	var decoded *UserDB
+	_, err = ReadFileWithBackups(s.file, config.BackupGenerations, func(r io.Reader) error {
+		decoded = &UserDB{}
+		envelope, err := readEnvelope(r, decoded)
+		s.schemaVersion = envelope.SchemaVersion
+		return err
+	})
+
+	// if db file does not exist, a new one is created on the next checkpoint
+	if err != nil && os.IsNotExist(errors.Cause(err)) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// empty maps are not encoded by gob
+	if decoded.Users.Users != nil {
+		db.Users.Users = decoded.Users.Users
+	}
+	return s.schemaVersion, nil
+}
+
+// SetUser is a no-op as the entire UserDB is written on each checkpoint.
+func (s *gobUserStore) SetUser(username string, user User) error {
+	return nil
+}
+
+// DeleteUser is a no-op as the entire UserDB is written on each checkpoint.
+func (s *gobUserStore) DeleteUser(username string) error {
+	return nil
+}
+
+// Checkpoint serializes the entire UserDB to a file on disk via gob.
+func (s *gobUserStore) Checkpoint(db *UserDB) error {
+	if s.readOnly {
+		return ErrReadOnlyStore
+	}
+	db.Users.mu.Lock()
+	defer db.Users.mu.Unlock()
+
+	// encode & atomically replace DB file, keeping previous generations as backups
+	return WriteFileAtomic(s.file, config.BackupGenerations, func(w io.Writer) error {
+		return writeEnvelope(w, s.schemaVersion, 0, db)
+	})
+}
+
+// SetSchemaVersion sets the schema version written on the next checkpoint.
+func (s *gobUserStore) SetSchemaVersion(schemaVersion int) error {
+	s.schemaVersion = schemaVersion
+	return nil
+}
+
+// Close is a no-op as the file is not held open between checkpoints.
+func (s *gobUserStore) Close() error {
+	return nil
+}
@@ -0,0 +1,296 @@
+package memoryshare
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// kvStore is a minimal embedded key-value store where each bucket is a directory and each value is a gob encoded file
+// named after its key. Every put is an atomic file replacement, so a crash can never leave a partially written value.
+//
+// The store is not transactional: a change to several keys is made one key at a time, so a crash part way through
+// leaves the keys already written in place. FileDB operations which change several records must recover from this
+// themselves, as publishing does with publish intents.
+type kvStore struct {
+	dir      string
+	readOnly bool
+}
+
+// openKVStore creates the store directory & its bucket directories if they do not exist. A read only store creates
+// nothing & treats a missing bucket as empty.
+func openKVStore(dir string, readOnly bool, buckets ...string) (*kvStore, error) {
+	if readOnly {
+		return &kvStore{dir: dir, readOnly: true}, nil
+	}
+
+	paths := []string{dir}
+	for _, bucket := range buckets {
+		paths = append(paths, filepath.Join(dir, bucket))
+	}
+	if err := EnsureDirExists(paths...); err != nil {
+		return nil, errors.Wrap(err, "failed to create key-value store")
+	}
+	return &kvStore{dir: dir}, nil
+}
+
+// path determines the file path of a key in a bucket. Keys are escaped so that they are always valid file names.
+func (s *kvStore) path(bucket, key string) string {
+	return filepath.Join(s.dir, bucket, url.PathEscape(key)+".gob")
+}
+
+// put encodes a value & stores it under the key in the bucket.
+func (s *kvStore) put(bucket, key string, value interface{}) error {
+	if s.readOnly {
+		return ErrReadOnlyStore
+	}
+	return WriteFileAtomic(s.path(bucket, key), 0, func(w io.Writer) error {
+		return gob.NewEncoder(w).Encode(value)
+	})
+}
+
+// delete removes the key from the bucket.
+func (s *kvStore) delete(bucket, key string) error {
+	if s.readOnly {
+		return ErrReadOnlyStore
+	}
+	if err := os.Remove(s.path(bucket, key)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to delete key")
+	}
+	return nil
+}
+
+// forEach decodes every value in the bucket in key order, passing each to the decode func.
+func (s *kvStore) forEach(bucket string, decode func(key string, d *gob.Decoder) error) error {
+	entries, err := ioutil.ReadDir(filepath.Join(s.dir, bucket))
+	if s.readOnly && os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to read bucket")
+	}
+
+	var keys []string
+	for _, entry := range entries {
+		// ignore temp files left behind by an interrupted put
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".gob") {
+			continue
+		}
+		key, err := url.PathUnescape(strings.TrimSuffix(entry.Name(), ".gob"))
+		if err != nil {
+			return errors.Wrap(err, "invalid key in bucket")
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		err := func() error {
+			file, err := os.Open(s.path(bucket, key))
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			return decode(key, gob.NewDecoder(file))
+		}()
+		if err != nil {
+			return errors.Wrapf(err, "failed to decode %v/%v", bucket, key)
+		}
+	}
+	return nil
+}
+
+// clear deletes every key in the bucket.
+func (s *kvStore) clear(bucket string) error {
+	if s.readOnly {
+		return ErrReadOnlyStore
+	}
+	return RemoveDirContents(filepath.Join(s.dir, bucket))
+}
+
+// kvFileStore stores each File & Transaction of a FileDB as a separate key-value record.
+type kvFileStore struct {
+	store            *kvStore
+	transactionCount int
+}
+
+// kv buckets used by the FileDB & UserDB. Files are stored in a bucket named after the lower case FileMapMutex name.
+const (
+	publishedBucket    = "published"
+	uploadedBucket     = "uploaded"
+	transactionsBucket = "transactions"
+	usersBucket        = "users"
+	metaBucket         = "meta"
+)
+
+// schemaVersionKey is the metaBucket key under which the schema version of the stored data is recorded.
+const schemaVersionKey = "schema_version"
+
+// schemaVersion reads the stored schema version. Data stored before the schema version was recorded is version 0.
+func (s *kvStore) schemaVersion() (schemaVersion int, err error) {
+	err = s.forEach(metaBucket, func(key string, d *gob.Decoder) error {
+		if key != schemaVersionKey {
+			return nil
+		}
+		return d.Decode(&schemaVersion)
+	})
+	return
+}
+
+// setSchemaVersion records the schema version of the stored data.
+func (s *kvStore) setSchemaVersion(schemaVersion int) error {
+	return s.put(metaBucket, schemaVersionKey, &schemaVersion)
+}
+
+// newKVFileStore opens the FileDB key-value store.
+func newKVFileStore(dbDir string, readOnly bool) (*kvFileStore, error) {
+	store, err := openKVStore(dbDir+"/file_db", readOnly, publishedBucket, uploadedBucket, transactionsBucket, metaBucket)
+	if err != nil {
+		return nil, err
+	}
+	return &kvFileStore{store: store}, nil
+}
+
+// Load reads every stored File & Transaction into the FileDB.
+func (s *kvFileStore) Load(db *FileDB) (schemaVersion int, err error) {
+	if schemaVersion, err = s.store.schemaVersion(); err != nil {
+		return 0, err
+	}
+
+	for _, fm := range []*FileMapMutex{&db.Published, &db.Uploaded} {
+		err := s.store.forEach(strings.ToLower(fm.name), func(key string, d *gob.Decoder) error {
+			var file File
+			if err := d.Decode(&file); err != nil {
+				return err
+			}
+			fm.Files[key] = file
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// transaction keys are sequence numbers, so they are read back in creation order
+	s.transactionCount = 0
+	err = s.store.forEach(transactionsBucket, func(key string, d *gob.Decoder) error {
+		var transaction Transaction
+		if err := d.Decode(&transaction); err != nil {
+			return err
+		}
+		db.FileTransactions.Transactions = append(db.FileTransactions.Transactions, transaction)
+		s.transactionCount++
+		return nil
+	})
+	return schemaVersion, err
+}
+
+// SetFile stores a File in the bucket corresponding with the named FileMapMutex.
+func (s *kvFileStore) SetFile(mapName string, UUID string, file File) error {
+	return s.store.put(strings.ToLower(mapName), UUID, &file)
+}
+
+// DeleteFile removes a File from the bucket corresponding with the named FileMapMutex.
+func (s *kvFileStore) DeleteFile(mapName string, UUID string) error {
+	return s.store.delete(strings.ToLower(mapName), UUID)
+}
+
+// AddTransaction stores a new Transaction under the next sequence number. The TransactionMutex lock serialises calls.
+func (s *kvFileStore) AddTransaction(transaction Transaction) error {
+	key := fmt.Sprintf("%020d", s.transactionCount)
+	if err := s.store.put(transactionsBucket, key, &transaction); err != nil {
+		return err
+	}
+	s.transactionCount++
+	return nil
+}
+
+// Checkpoint is a no-op as every change is stored as soon as it is made.
+func (s *kvFileStore) Checkpoint(db *FileDB, force bool) error {
+	return nil
+}
+
+// SetSchemaVersion records the schema version of the stored Files & Transactions.
+func (s *kvFileStore) SetSchemaVersion(schemaVersion int) error {
+	return s.store.setSchemaVersion(schemaVersion)
+}
+
+// Reset deletes all stored Files & Transactions.
+func (s *kvFileStore) Reset() error {
+	for _, bucket := range []string{publishedBucket, uploadedBucket, transactionsBucket} {
+		if err := s.store.clear(bucket); err != nil {
+			return err
+		}
+	}
+	s.transactionCount = 0
+	return nil
+}
+
+// Close is a no-op as no files are held open between operations.
+func (s *kvFileStore) Close() error {
+	return nil
+}
+
+// kvUserStore stores each User of a UserDB as a separate key-value record.
+type kvUserStore struct {
+	store *kvStore
+}
+
+// newKVUserStore opens the UserDB key-value store.
+func newKVUserStore(dbDir string, readOnly bool) (*kvUserStore, error) {
+	store, err := openKVStore(dbDir+"/user_db", readOnly, usersBucket, metaBucket)
+	if err != nil {
+		return nil, err
+	}
+	return &kvUserStore{store: store}, nil
+}
+
+// Load reads every stored User into the UserDB.
+func (s *kvUserStore) Load(db *UserDB) (schemaVersion int, err error) {
+	if schemaVersion, err = s.store.schemaVersion(); err != nil {
+		return 0, err
+	}
+
+	err = s.store.forEach(usersBucket, func(key string, d *gob.Decoder) error {
+		var user User
+		if err := d.Decode(&user); err != nil {
+			return err
+		}
+		db.Users.Users[key] = user
+		return nil
+	})
+	return schemaVersion, err
+}
+
+// SetUser stores a User.
+func (s *kvUserStore) SetUser(username string, user User) error {
+	return s.store.put(usersBucket, username, &user)
+}
+
+// DeleteUser removes a User.
+func (s *kvUserStore) DeleteUser(username string) error {
+	return s.store.delete(usersBucket, username)
+}
+
+// Checkpoint is a no-op as every change is stored as soon as it is made.
+func (s *kvUserStore) Checkpoint(db *UserDB) error {
+	return nil
+}
+
+// SetSchemaVersion records the schema version of the stored Users.
+func (s *kvUserStore) SetSchemaVersion(schemaVersion int) error {
+	return s.store.setSchemaVersion(schemaVersion)
+}
+
+// Close is a no-op as no files are held open between operations.
+func (s *kvUserStore) Close() error {
+	return nil
+}
@@ -0,0 +1,176 @@
+package memoryshare
+
+import (
+	"fmt"
+	"testing"
+)
+
+// storeBackends are the storage backends which every FileStore & UserStore must behave identically for.
+var storeBackends = []string{GobBackend, KVBackend}
+
+// reopenTestFileDB closes the store of a FileDB & loads a new FileDB from the same directory, without migrating it.
+func reopenTestFileDB(t *testing.T, db *FileDB) *FileDB {
+	t.Helper()
+	if err := db.store.Checkpoint(db, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, _, err := openFileDB(db.dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reopened
+}
+
+func TestFileStoreConformance(t *testing.T) {
+	for _, backend := range storeBackends {
+		t.Run(backend, func(t *testing.T) {
+			t.Run("files and transactions persist", func(t *testing.T) {
+				dir := newTestConfig(t, backend)
+				db, _, err := openFileDB(dir+"/db", false)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// make more changes than the snapshot interval so that both snapshots & logged changes are loaded
+				for i := 0; i < 10; i++ {
+					UUID := fmt.Sprintf("file-%02d", i)
+					file := File{UUID: UUID, Name: UUID, State: Published}
+					db.Published.Set(UUID, file)
+					db.FileTransactions.Create(Create, "bob", File{UUID: UUID}, file)
+					db.store.Checkpoint(db, false)
+				}
+				db.Uploaded.Set("uploaded", File{UUID: "uploaded", State: Uploaded})
+
+				db = reopenTestFileDB(t, db)
+				if db.Published.Count() != 10 || db.Uploaded.Count() != 1 {
+					t.Fatalf("expected 10 published & 1 uploaded file, got %v & %v", db.Published.Count(), db.Uploaded.Count())
+				}
+				transactions := db.FileTransactions.Transactions
+				if len(transactions) != 10 {
+					t.Fatalf("expected 10 transactions, got %v", len(transactions))
+				}
+				for i, transaction := range transactions {
+					if want := fmt.Sprintf("file-%02d", i); transaction.TargetFileUUID != want {
+						t.Fatalf("expected transaction %v to target %v, got %v", i, want, transaction.TargetFileUUID)
+					}
+				}
+			})
+
+			t.Run("updates and deletes persist", func(t *testing.T) {
+				dir := newTestConfig(t, backend)
+				db, _, err := openFileDB(dir+"/db", false)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				db.Uploaded.Set("a", File{UUID: "a", Name: "before", State: Uploaded})
+				db.Uploaded.Set("b", File{UUID: "b", State: Uploaded})
+				db.Uploaded.Set("a", File{UUID: "a", Name: "after", State: Uploaded})
+				db.Uploaded.Delete("b")
+				db.Uploaded.Delete("missing")
+
+				db = reopenTestFileDB(t, db)
+				if file, ok := db.Uploaded.Get("a"); !ok || file.Name != "after" {
+					t.Fatalf("expected the updated file to persist, got %+v", file)
+				}
+				if _, ok := db.Uploaded.Get("b"); ok {
+					t.Fatal("expected the deleted file not to persist")
+				}
+			})
+
+			t.Run("schema version persists", func(t *testing.T) {
+				dir := newTestConfig(t, backend)
+				db, schemaVersion, err := openFileDB(dir+"/db", false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if schemaVersion != 0 {
+					t.Fatalf("expected a new store to be schema version 0, got %v", schemaVersion)
+				}
+
+				if err := db.store.SetSchemaVersion(5); err != nil {
+					t.Fatal(err)
+				}
+				if err := db.store.Checkpoint(db, true); err != nil {
+					t.Fatal(err)
+				}
+				db.store.Close()
+
+				if _, schemaVersion, err = openFileDB(dir+"/db", false); err != nil || schemaVersion != 5 {
+					t.Fatalf("expected schema version 5, got %v (%v)", schemaVersion, err)
+				}
+			})
+
+			t.Run("reset deletes everything", func(t *testing.T) {
+				dir := newTestConfig(t, backend)
+				db, _, err := openFileDB(dir+"/db", false)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				file := File{UUID: "a", State: Published}
+				db.Published.Set("a", file)
+				db.FileTransactions.Create(Create, "bob", File{UUID: "a"}, file)
+				if err := db.store.Checkpoint(db, true); err != nil {
+					t.Fatal(err)
+				}
+				if err := db.store.Reset(); err != nil {
+					t.Fatal(err)
+				}
+				db.store.Close()
+
+				db, _, err = openFileDB(dir+"/db", false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if db.Published.Count() != 0 || len(db.FileTransactions.Transactions) != 0 {
+					t.Fatalf("expected an empty store after reset, got %v files & %v transactions", db.Published.Count(),
+						len(db.FileTransactions.Transactions))
+				}
+			})
+		})
+	}
+}
+
+func TestUserStoreConformance(t *testing.T) {
+	for _, backend := range storeBackends {
+		t.Run(backend, func(t *testing.T) {
+			dir := newTestConfig(t, backend)
+			db, schemaVersion, err := openUserDB(dir+"/db", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if schemaVersion != 0 {
+				t.Fatalf("expected a new store to be schema version 0, got %v", schemaVersion)
+			}
+
+			db.Users.Set("bob", User{Username: "bob", Forename: "Bob"})
+			db.Users.Set("eve", User{Username: "eve"})
+			db.Users.Set("bob", User{Username: "bob", Forename: "Robert"})
+			db.Users.Delete("eve")
+			if err := db.store.SetSchemaVersion(5); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			db, schemaVersion, err = openUserDB(dir+"/db", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if schemaVersion != 5 {
+				t.Fatalf("expected schema version 5, got %v", schemaVersion)
+			}
+			if user, ok := db.Users.Get("bob"); !ok || user.Forename != "Robert" {
+				t.Fatalf("expected the updated user to persist, got %+v", user)
+			}
+			if _, ok := db.Users.Get("eve"); ok || db.Users.Count() != 1 {
+				t.Fatal("expected the deleted user not to persist")
+			}
+		})
+	}
+}
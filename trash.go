@@ -0,0 +1,141 @@
+package memoryshare
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrFileNotDeleted implies a file to be restored from the trash has not been deleted.
+var ErrFileNotDeleted = errors.New("file has not been deleted")
+
+// ErrFilePurged implies the content of a deleted file has been purged, so it can no longer be restored.
+var ErrFilePurged = errors.New("file content has been purged")
+
+// GetTrash returns the deleted memories whose content has not yet been purged, most recently deleted first. If username
+// is not empty, only memories uploaded by that user are returned.
+func (db *FileDB) GetTrash(username string) []File {
+	deletedFiles := func(m FileMapDB, mapName string) interface{} {
+		files := make([]File, 0)
+		for _, file := range m {
+			if file.State == Deleted && (username == "" || file.UploaderUsername == username) {
+				files = append(files, file)
+			}
+		}
+		return files
+	}
+	files := db.Published.PerformFunc(deletedFiles).([]File)
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].DeletedTimestamp > files[j].DeletedTimestamp
+	})
+	return files
+}
+
+// RestoreFile restores a deleted memory from the trash, making it visible to all logged in users again. The actor is
+// the username of the restoring user. The state is checked & changed under a single lock, so a memory purged
+// concurrently is never restored.
+func (db *FileDB) RestoreFile(fileUUID string, actor string) (err error) {
+	var before, restored File
+	updated := db.Published.Update(fileUUID, func(file File) (File, bool) {
+		if file.State == Purged {
+			err = ErrFilePurged
+			return file, false
+		}
+		if file.State != Deleted {
+			err = ErrFileNotDeleted
+			return file, false
+		}
+
+		before = file
+		file.State = Published
+		file.DeletedTimestamp = 0
+		restored = file
+		return file, true
+	})
+	if !updated {
+		if err == nil {
+			err = ErrFileNotFound
+		}
+		return err
+	}
+	db.FileTransactions.Create(Undelete, actor, before, restored)
+
+	db.Checkpoint()
+	return nil
+}
+
+// PurgeTrash releases the content of memories which were deleted longer than the retention period ago & marks them as
+// purged. Memories without a deletion time are never purged, as their retention period is unknown. The memory is
+// marked before its content is released, so an interrupted purge can only leave unreferenced content behind rather
+// than a restorable memory without content.
+func (db *FileDB) PurgeTrash(retention time.Duration) (purged int, err error) {
+	expiry := time.Now().Add(-retention).UnixNano()
+	expired := func(file File) bool {
+		return file.State == Deleted && file.DeletedTimestamp != 0 && file.DeletedTimestamp <= expiry
+	}
+
+	for _, file := range db.GetTrash("") {
+		if !expired(file) {
+			continue
+		}
+
+		// the memory may have been restored since the trash was listed, so is checked again under the lock
+		before := file
+		updated := db.Published.Update(file.UUID, func(current File) (File, bool) {
+			if !expired(current) {
+				return current, false
+			}
+			before = current
+			file = current
+			file.State = Purged
+			return file, true
+		})
+		if !updated {
+			continue
+		}
+		db.FileTransactions.Create(Purge, "", before, file)
+
+		if err = db.blobs.Release(file.Hash); err != nil && err != ErrBlobNotFound {
+			return purged, errors.Wrapf(err, "failed to release content of %v", file.UUID)
+		}
+		purged++
+	}
+
+	if purged > 0 {
+		db.Checkpoint()
+	}
+	return purged, nil
+}
+
+// trashPurgeInterval is the period between checks for expired memories in the trash.
+const trashPurgeInterval = time.Hour
+
+// runTrashPurger purges expired memories from the trash periodically until stop is closed. Purging is disabled if the
+// retention period is 0.
+func (db *FileDB) runTrashPurger(stop <-chan struct{}) {
+	if config.TrashRetentionDays <= 0 {
+		return
+	}
+	retention := time.Duration(config.TrashRetentionDays) * 24 * time.Hour
+
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := db.PurgeTrash(retention)
+		if err != nil {
+			Critical.Logf("%+v", err)
+		}
+		if purged > 0 {
+			Info.Logf("purged %v memories from the trash", purged)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package memoryshare
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrash(t *testing.T) {
+	db, dir := newTestFileDB(t, GobBackend)
+
+	path, hash := writeTestBlob(t, dir, "hello")
+	if err := db.blobs.Put(hash, path); err != nil {
+		t.Fatal(err)
+	}
+	db.Published.Set("a", File{UUID: "a", Hash: hash, State: Published, UploaderUsername: "bob"})
+
+	if err := db.DeleteFile("a", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.GetTrash("bob")) != 1 || len(db.GetTrash("eve")) != 0 {
+		t.Fatal("expected the deleted memory to be in its uploader's trash only")
+	}
+
+	if err := db.RestoreFile("a", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RestoreFile("a", "bob"); err != ErrFileNotDeleted {
+		t.Fatalf("expected %v, got %v", ErrFileNotDeleted, err)
+	}
+	if file, _ := db.Published.Get("a"); file.State != Published || file.DeletedTimestamp != 0 {
+		t.Fatalf("expected the memory to be published again, got %+v", file)
+	}
+
+	if err := db.DeleteFile("a", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if purged, err := db.PurgeTrash(time.Hour); purged != 0 || err != nil {
+		t.Fatalf("expected nothing to be purged within the retention period, got %v (%v)", purged, err)
+	}
+	if purged, err := db.PurgeTrash(0); purged != 1 || err != nil {
+		t.Fatalf("expected the memory to be purged, got %v (%v)", purged, err)
+	}
+	if exists, _ := db.blobs.Exists(hash); exists {
+		t.Fatal("expected the content of the purged memory to be released")
+	}
+	if err := db.RestoreFile("a", "bob"); err != ErrFilePurged {
+		t.Fatalf("expected %v, got %v", ErrFilePurged, err)
+	}
+
+	if count := len(db.FileHistory("a", 0, 0).Transactions); count != 4 {
+		t.Fatalf("expected delete, undelete, delete & purge transactions, got %v", count)
+	}
+}
+
+func TestPurgeTrashUnknownDeletionTime(t *testing.T) {
+	db, dir := newTestFileDB(t, GobBackend)
+
+	path, hash := writeTestBlob(t, dir, "hello")
+	if err := db.blobs.Put(hash, path); err != nil {
+		t.Fatal(err)
+	}
+	db.Published.Set("a", File{UUID: "a", Hash: hash, State: Deleted})
+
+	if purged, err := db.PurgeTrash(0); purged != 0 || err != nil {
+		t.Fatalf("expected a memory without a deletion time not to be purged, got %v (%v)", purged, err)
+	}
+	if exists, _ := db.blobs.Exists(hash); !exists {
+		t.Fatal("expected the content of the memory to remain")
+	}
+}
+
+func TestUploadPurgedContent(t *testing.T) {
+	db, _ := newTestFileDB(t, GobBackend)
+
+	uploadTestFile(t, db, "a", "hello")
+	if err := db.PublishFile("a", MetaData{}, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteFile("a", "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	// deleted content can still be restored, so is not uploaded again
+	b := uploadTestFile(t, db, "b", "hello")
+	db.Uploaded.Delete("b")
+	err := db.registerUpload(b)
+	if existsErr, ok := err.(*FileExistsError); !ok || existsErr.ConstructResponse() != "already_deleted_self" {
+		t.Fatalf("expected the deleted copy to be reported, got %v", err)
+	}
+
+	if purged, err := db.PurgeTrash(0); purged != 1 || err != nil {
+		t.Fatalf("expected the memory to be purged, got %v (%v)", purged, err)
+	}
+	b = uploadTestFile(t, db, "b", "hello")
+	db.Uploaded.Delete("b")
+	if err := db.registerUpload(b); err != nil {
+		t.Fatalf("expected purged content to be uploaded again, got %v", err)
+	}
+	if err := db.PublishFile("b", MetaData{}, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := db.blobs.Exists(b.Hash); !exists {
+		t.Fatal("expected the content to be stored again once published")
+	}
+}
+
+func TestRestoreDuringPurge(t *testing.T) {
+	db, dir := newTestFileDB(t, GobBackend)
+
+	path, hash := writeTestBlob(t, dir, "hello")
+	for i := 0; i < 50; i++ {
+		if err := db.blobs.Put(hash, path); err != nil {
+			t.Fatal(err)
+		}
+		db.Published.Set("a", File{UUID: "a", Hash: hash, State: Deleted, DeletedTimestamp: 1})
+
+		restored := make(chan error)
+		go func() {
+			restored <- db.RestoreFile("a", "bob")
+		}()
+		if _, err := db.PurgeTrash(0); err != nil {
+			t.Fatal(err)
+		}
+
+		// a memory is either restored with its content or purged, never restored & then purged
+		err := <-restored
+		file, _ := db.Published.Get("a")
+		exists, _ := db.blobs.Exists(hash)
+		switch err {
+		case nil:
+			if file.State != Published || !exists {
+				t.Fatalf("expected the restored memory to keep its content, got %+v", file)
+			}
+			if err := db.blobs.Release(hash); err != nil {
+				t.Fatal(err)
+			}
+		case ErrFilePurged:
+			if file.State != Purged || exists {
+				t.Fatalf("expected the purged memory to have no content, got %+v", file)
+			}
+		default:
+			t.Fatal(err)
+		}
+	}
+}
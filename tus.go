@@ -0,0 +1,348 @@
+package memoryshare
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ResumableUpload is an upload which is received in chunks using the tus protocol (https://tus.io). The data received so
+// far is stored in db/uploads & hashed as it arrives, with the hash state persisted alongside the upload so that hashing
+// resumes where it left off.
+type ResumableUpload struct {
+	ID               string
+	Username         string
+	FileName         string
+	Length           int64 // total size in bytes
+	Offset           int64 // number of bytes received
+	HashState        []byte
+	CreatedTimestamp int64
+	UpdatedTimestamp int64
+}
+
+var (
+	// ErrUploadNotFound implies a resumable upload does not exist, has expired or belongs to another user.
+	ErrUploadNotFound = errors.New("resumable upload not found")
+	// ErrUploadOffsetMismatch implies a chunk did not start at the offset of the data received so far.
+	ErrUploadOffsetMismatch = errors.New("chunk offset does not match the upload offset")
+	// ErrInvalidUploadLength implies the declared size of an upload is not positive. An empty upload would never receive
+	// a chunk, so could never be completed.
+	ErrInvalidUploadLength = errors.New("upload length must be positive")
+	// ErrUploadTooLarge implies the size of an upload exceeds the maximum file upload size.
+	ErrUploadTooLarge = errors.New("upload exceeds the maximum file upload size")
+	// ErrUploadLocked implies a chunk is already being written to the upload by another request.
+	ErrUploadLocked = errors.New("upload is locked by another request")
+)
+
+// uploadsDir returns the directory containing the data & state of each unfinished resumable upload.
+func (db *FileDB) uploadsDir() string {
+	return db.dir + "/uploads/"
+}
+
+// uploadDataPath returns the path of the data received so far.
+func (db *FileDB) uploadDataPath(ID string) string {
+	return db.uploadsDir() + ID + ".part"
+}
+
+// uploadStatePath returns the path of the persisted ResumableUpload.
+func (db *FileDB) uploadStatePath(ID string) string {
+	return db.uploadsDir() + ID + ".info"
+}
+
+// CreateResumableUpload starts a new resumable upload of length bytes by the user. The file name & the quota of the
+// user are checked up front so that an upload which would be rejected fails before any data is sent.
+func (db *FileDB) CreateResumableUpload(user User, fileName string, length int64, maxLength int64) (upload ResumableUpload, err error) {
+	if length <= 0 {
+		return upload, ErrInvalidUploadLength
+	}
+	if length > maxLength {
+		return upload, ErrUploadTooLarge
+	}
+	if _, err = db.newUpload(fileName, user.Username); err != nil {
+		return upload, err
+	}
+	if err = db.checkQuota(user, length); err != nil {
+		return upload, err
+	}
+	if err = EnsureDirExists(db.uploadsDir()); err != nil {
+		return upload, errors.Wrap(err, "could not create uploads dir")
+	}
+
+	// encode the initial hash state
+	hashState, err := sha256.New().(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return upload, errors.Wrap(err, "failed to encode hash state")
+	}
+
+	upload = ResumableUpload{
+		ID:               NewUUID(),
+		Username:         user.Username,
+		FileName:         fileName,
+		Length:           length,
+		HashState:        hashState,
+		CreatedTimestamp: time.Now().UnixNano(),
+		UpdatedTimestamp: time.Now().UnixNano(),
+	}
+	if err = ioutil.WriteFile(db.uploadDataPath(upload.ID), nil, 0666); err != nil {
+		return upload, errors.Wrap(err, "failed to create upload data file")
+	}
+	if err = db.saveResumableUpload(upload); err != nil {
+		os.Remove(db.uploadDataPath(upload.ID))
+		return upload, err
+	}
+
+	Creation.Logf("resumable upload %v of %v bytes started by %v", upload.ID, length, user.Username)
+	return upload, nil
+}
+
+// saveResumableUpload persists the state of a ResumableUpload.
+func (db *FileDB) saveResumableUpload(upload ResumableUpload) error {
+	err := WriteFileAtomic(db.uploadStatePath(upload.ID), 0, func(w io.Writer) error {
+		return gob.NewEncoder(w).Encode(&upload)
+	})
+	return errors.Wrap(err, "failed to store resumable upload state")
+}
+
+// loadResumableUpload reads the persisted state of a ResumableUpload.
+func (db *FileDB) loadResumableUpload(ID string) (upload ResumableUpload, err error) {
+	// prevent path traversal via the ID
+	if ID == "" || strings.ContainsAny(ID, `/\.`) {
+		return upload, ErrUploadNotFound
+	}
+
+	f, err := os.Open(db.uploadStatePath(ID))
+	if os.IsNotExist(err) {
+		return upload, ErrUploadNotFound
+	}
+	if err != nil {
+		return upload, errors.Wrap(err, "failed to open resumable upload state")
+	}
+	defer f.Close()
+
+	if err = gob.NewDecoder(f).Decode(&upload); err != nil {
+		return upload, errors.Wrap(err, "failed to decode resumable upload state")
+	}
+	return upload, nil
+}
+
+// Expires returns the time at which the upload will be removed if no more data is received. ok is false if abandoned
+// uploads are never removed.
+func (upload ResumableUpload) Expires() (expires time.Time, ok bool) {
+	if config.ResumableUploadExpiry <= 0 {
+		return expires, false
+	}
+	return time.Unix(0, upload.UpdatedTimestamp).Add(time.Duration(config.ResumableUploadExpiry) * time.Hour), true
+}
+
+// GetResumableUpload returns a resumable upload belonging to the user.
+func (db *FileDB) GetResumableUpload(ID string, username string) (upload ResumableUpload, err error) {
+	if upload, err = db.loadResumableUpload(ID); err != nil {
+		return upload, err
+	}
+	if upload.Username != username {
+		return upload, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+// lockResumableUpload marks an upload as being written to, returning ErrUploadLocked if it already is.
+func (db *FileDB) lockResumableUpload(ID string) error {
+	db.resumableMu.Lock()
+	defer db.resumableMu.Unlock()
+	if db.resumableLocks == nil {
+		db.resumableLocks = make(map[string]bool)
+	}
+	if db.resumableLocks[ID] {
+		return ErrUploadLocked
+	}
+	db.resumableLocks[ID] = true
+	return nil
+}
+
+// unlockResumableUpload unmarks an upload locked by lockResumableUpload.
+func (db *FileDB) unlockResumableUpload(ID string) {
+	db.resumableMu.Lock()
+	defer db.resumableMu.Unlock()
+	delete(db.resumableLocks, ID)
+}
+
+// WriteResumableUpload appends a chunk starting at offset to a resumable upload belonging to the user. Data is hashed as
+// it is written, and the data received before a read error is kept so that the client can resume from the new offset.
+// Once all data has been received, the upload is validated & added to the Uploaded DB in the same way as UploadFile;
+// the returned File is then the new upload.
+func (db *FileDB) WriteResumableUpload(ID string, user User, offset int64, chunk io.Reader) (upload ResumableUpload, file File, err error) {
+	if err = db.lockResumableUpload(ID); err != nil {
+		return upload, file, err
+	}
+	defer db.unlockResumableUpload(ID)
+
+	if upload, err = db.GetResumableUpload(ID, user.Username); err != nil {
+		return upload, file, err
+	}
+	if offset != upload.Offset {
+		return upload, file, ErrUploadOffsetMismatch
+	}
+
+	// restore hash state
+	h := sha256.New()
+	if err = h.(encoding.BinaryUnmarshaler).UnmarshalBinary(upload.HashState); err != nil {
+		return upload, file, errors.Wrap(err, "failed to decode hash state")
+	}
+
+	data, err := os.OpenFile(db.uploadDataPath(ID), os.O_WRONLY, 0666)
+	if err != nil {
+		return upload, file, errors.Wrap(err, "failed to open upload data file")
+	}
+	defer data.Close()
+
+	// discard any data written after the state was last persisted
+	if err = data.Truncate(upload.Offset); err != nil {
+		return upload, file, errors.Wrap(err, "failed to truncate upload data file")
+	}
+	if _, err = data.Seek(upload.Offset, io.SeekStart); err != nil {
+		return upload, file, errors.Wrap(err, "failed to seek upload data file")
+	}
+
+	// data beyond the declared length is ignored
+	written, copyErr := io.Copy(io.MultiWriter(data, h), io.LimitReader(chunk, upload.Length-upload.Offset))
+	if err = data.Sync(); err != nil {
+		return upload, file, errors.Wrap(err, "failed to sync upload data file")
+	}
+
+	// persist progress, including that of an interrupted chunk
+	upload.Offset += written
+	upload.UpdatedTimestamp = time.Now().UnixNano()
+	if upload.HashState, err = h.(encoding.BinaryMarshaler).MarshalBinary(); err != nil {
+		return upload, file, errors.Wrap(err, "failed to encode hash state")
+	}
+	if err = db.saveResumableUpload(upload); err != nil {
+		return upload, file, err
+	}
+
+	if copyErr != nil {
+		return upload, file, errors.Wrap(copyErr, "failed to read chunk")
+	}
+	if upload.Offset < upload.Length {
+		return upload, file, nil
+	}
+
+	// all data received, so move it to the temp dir & register the upload
+	data.Close()
+	file, err = db.completeResumableUpload(upload, user, fmt.Sprintf("%x", h.Sum(nil)))
+	return upload, file, err
+}
+
+// completeResumableUpload moves the data of a fully received upload into the temp dir of the user & adds it to the
+// Uploaded DB, then removes the resumable upload. The quota is checked again as other uploads may have completed since
+// the upload was created.
+func (db *FileDB) completeResumableUpload(upload ResumableUpload, user User, hash string) (file File, err error) {
+	// the upload is finished regardless of whether it is accepted
+	defer db.removeResumableUpload(upload.ID)
+
+	if file, err = db.newUpload(upload.FileName, upload.Username); err != nil {
+		return file, err
+	}
+	if err = db.checkQuota(user, upload.Length); err != nil {
+		return file, err
+	}
+	file.Size = upload.Length
+	file.Hash = hash
+
+	if err = os.Rename(db.uploadDataPath(upload.ID), file.UploadPath()); err != nil {
+		if err = MoveFile(db.uploadDataPath(upload.ID), file.UploadPath()); err != nil {
+			return file, errors.Wrap(err, "failed to move upload data to temp dir")
+		}
+	}
+	return file, db.registerUpload(file)
+}
+
+// removeResumableUpload deletes the data & state of a resumable upload.
+func (db *FileDB) removeResumableUpload(ID string) {
+	for _, path := range []string{db.uploadDataPath(ID), db.uploadStatePath(ID)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			Critical.Log(errors.Wrap(err, "failed to remove resumable upload"))
+		}
+	}
+}
+
+// DeleteResumableUpload terminates a resumable upload belonging to the user, deleting the data received so far.
+func (db *FileDB) DeleteResumableUpload(ID string, username string) error {
+	if err := db.lockResumableUpload(ID); err != nil {
+		return err
+	}
+	defer db.unlockResumableUpload(ID)
+
+	if _, err := db.GetResumableUpload(ID, username); err != nil {
+		return err
+	}
+	db.removeResumableUpload(ID)
+	return nil
+}
+
+// PurgeResumableUploads removes resumable uploads which have not received any data within the expiry period, returning
+// the number removed.
+func (db *FileDB) PurgeResumableUploads(expiry time.Duration) (purged int, err error) {
+	stateFiles, err := filepath.Glob(db.uploadsDir() + "*.info")
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to list resumable uploads")
+	}
+	cutoff := time.Now().Add(-expiry).UnixNano()
+
+	for _, stateFile := range stateFiles {
+		ID := strings.TrimSuffix(filepath.Base(stateFile), ".info")
+		// skip uploads which are currently being written to
+		if db.lockResumableUpload(ID) != nil {
+			continue
+		}
+
+		upload, err := db.loadResumableUpload(ID)
+		if err != nil {
+			Critical.Logf("%+v", err)
+		} else if upload.UpdatedTimestamp < cutoff {
+			db.removeResumableUpload(ID)
+			purged++
+		}
+		db.unlockResumableUpload(ID)
+	}
+	return purged, nil
+}
+
+// resumableUploadPurgeInterval is the period between checks for abandoned resumable uploads.
+const resumableUploadPurgeInterval = time.Hour
+
+// runResumableUploadPurger removes abandoned resumable uploads periodically until stop is closed. Purging is disabled
+// if the expiry period is 0.
+func (db *FileDB) runResumableUploadPurger(stop <-chan struct{}) {
+	if config.ResumableUploadExpiry <= 0 {
+		return
+	}
+	expiry := time.Duration(config.ResumableUploadExpiry) * time.Hour
+
+	ticker := time.NewTicker(resumableUploadPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := db.PurgeResumableUploads(expiry)
+		if err != nil {
+			Critical.Logf("%+v", err)
+		}
+		if purged > 0 {
+			Info.Logf("removed %v abandoned resumable uploads", purged)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
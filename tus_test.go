@@ -0,0 +1,110 @@
+package memoryshare
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// interruptedReader reads its data & then fails, like a connection dropped part way through a request body.
+type interruptedReader struct {
+	data []byte
+}
+
+func (r *interruptedReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestResumableUpload(t *testing.T) {
+	db, _ := newTestFileDB(t, GobBackend)
+	config.ResumableUploadExpiry = 24
+	bob := User{Username: "bob"}
+	content := []byte("hello resumable world")
+
+	if _, err := db.CreateResumableUpload(bob, "a.exe", 5, 100); err != ErrUnsupportedFormat {
+		t.Fatalf("expected %v, got %v", ErrUnsupportedFormat, err)
+	}
+	if _, err := db.CreateResumableUpload(bob, "a.txt", 500, 100); err != ErrUploadTooLarge {
+		t.Fatalf("expected %v, got %v", ErrUploadTooLarge, err)
+	}
+	// an empty upload would never receive the chunk which completes it
+	for _, length := range []int64{0, -1} {
+		if _, err := db.CreateResumableUpload(bob, "a.txt", length, 100); err != ErrInvalidUploadLength {
+			t.Fatalf("length %v: expected %v, got %v", length, ErrInvalidUploadLength, err)
+		}
+	}
+	upload, err := db.CreateResumableUpload(bob, "a.txt", int64(len(content)), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetResumableUpload(upload.ID, "eve"); err != ErrUploadNotFound {
+		t.Fatalf("expected uploads to be private to their user, got %v", err)
+	}
+
+	// the progress of an interrupted chunk is kept
+	upload, file, err := db.WriteResumableUpload(upload.ID, bob, 0, &interruptedReader{content[:7]})
+	if err == nil || upload.Offset != 7 || file.UUID != "" {
+		t.Fatalf("expected an interrupted chunk to write 7 bytes, got offset %v (%v)", upload.Offset, err)
+	}
+	if _, _, err := db.WriteResumableUpload(upload.ID, bob, 3, bytes.NewReader(content[3:])); err != ErrUploadOffsetMismatch {
+		t.Fatalf("expected %v, got %v", ErrUploadOffsetMismatch, err)
+	}
+	if _, file, err = db.WriteResumableUpload(upload.ID, bob, 7, bytes.NewReader(content[7:])); err != nil {
+		t.Fatal(err)
+	}
+
+	// the completed upload is registered like any other
+	if hash, _ := GenerateFileHash(file.UploadPath()); hash != file.Hash || file.Size != int64(len(content)) {
+		t.Fatalf("expected the hash & size of the completed upload to match its content, got %+v", file)
+	}
+	if _, ok := db.Uploaded.Get(file.UUID); !ok {
+		t.Fatal("expected the completed upload to be registered")
+	}
+	duplicate, err := db.CreateResumableUpload(bob, "b.txt", int64(len(content)), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = db.WriteResumableUpload(duplicate.ID, bob, 0, bytes.NewReader(content))
+	if existsErr, ok := err.(*FileExistsError); !ok || existsErr.ConstructResponse() != "already_uploaded_self" {
+		t.Fatalf("expected the duplicate upload to be rejected, got %v", err)
+	}
+}
+
+func TestPurgeResumableUploads(t *testing.T) {
+	db, _ := newTestFileDB(t, GobBackend)
+	bob := User{Username: "bob"}
+
+	upload, err := db.CreateResumableUpload(bob, "a.txt", 3, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged, err := db.PurgeResumableUploads(time.Hour); purged != 0 || err != nil {
+		t.Fatalf("expected nothing to be purged before expiry, got %v (%v)", purged, err)
+	}
+	if purged, err := db.PurgeResumableUploads(0); purged != 1 || err != nil {
+		t.Fatalf("expected the expired upload to be purged, got %v (%v)", purged, err)
+	}
+	if _, err := db.GetResumableUpload(upload.ID, "bob"); err != ErrUploadNotFound {
+		t.Fatalf("expected %v, got %v", ErrUploadNotFound, err)
+	}
+	if files, _ := ioutil.ReadDir(db.dir + "/uploads"); len(files) != 0 {
+		t.Fatalf("expected the partial content to be removed, got %v files", len(files))
+	}
+}
+
+func TestParseUploadMetadata(t *testing.T) {
+	metadata := parseUploadMetadata("filename bXkgZmlsZS50eHQ=,is_confidential")
+	if metadata["filename"] != "my file.txt" {
+		t.Fatalf("expected the base64 filename to be decoded, got %q", metadata["filename"])
+	}
+	if value, ok := metadata["is_confidential"]; !ok || value != "" {
+		t.Fatal("expected a key without a value to be present")
+	}
+}
@@ -60,9 +60,11 @@ type User struct {
 	Type                   UserType
 	CreatedTimestamp       int64
 	Image                  string
-	FavouriteFileUUIDs     map[string]bool // fileUUID key
+	FavouriteFileUUIDs     map[string]bool        // fileUUID key
+	SavedSearches          map[string]SavedSearch `json:"-"` // ID key, private unless shared
 	UploadsCount           int
 	PublishedCount         int
+	QuotaOverride          *Quota // overrides the default quota of the UserType if set
 	AccountState
 }
 
@@ -70,6 +72,7 @@ type User struct {
 type UserMapMutex struct {
 	Users map[string]User
 	mu    sync.RWMutex
+	store UserStore
 }
 
 // Set creates or updates a User in a UserDB.
@@ -77,6 +80,12 @@ func (fm *UserMapMutex) Set(username string, user User) {
 	fm.mu.Lock()
 	defer fm.mu.Unlock()
 	fm.Users[username] = user
+
+	if fm.store != nil {
+		if err := fm.store.SetUser(username, user); err != nil {
+			Critical.Log(err)
+		}
+	}
 }
 
 // Get attempts to retrieve a User from a UserDB.
@@ -99,6 +108,12 @@ func (fm *UserMapMutex) Delete(username string) {
 	fm.mu.Lock()
 	defer fm.mu.Unlock()
 	delete(fm.Users, username)
+
+	if fm.store != nil {
+		if err := fm.store.DeleteUser(username); err != nil {
+			Critical.Log(err)
+		}
+	}
 }
 
 // UserMapDB is a User container, where the map key is the User's username.
@@ -120,30 +135,23 @@ type UserDB struct {
 	Users   UserMapMutex
 	cookies *sessions.CookieStore
 	dir     string
-	file    string
+	store   UserStore
 }
 
-// NewUserDB initialises the UserDB container and populates it with data from the stored file if possible. Otherwise,
-// a new file is created containing the serialized empty UserDB. A default super admin account is also created
-// via command line if no users are found in the DB.
+// NewUserDB initialises the UserDB container, populates it with data from the configured UserStore & runs any pending
+// migrations. A default super admin account is also created via command line if no users are found in the DB.
 func NewUserDB(dbDir string) (userDB *UserDB, err error) {
-	// get session key
-	key, err := FetchSessionKey()
+	userDB, schemaVersion, err := openUserDB(dbDir, false)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to fetch session key")
+		return nil, err
 	}
 
-	userDB = &UserDB{
-		cookies: sessions.NewCookieStore(key),
-		dir:     dbDir,
-		file:    dbDir + "/user_db.dat",
-		Users:   UserMapMutex{Users: make(map[string]User)},
+	changes, err := userDB.migrate(schemaVersion, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not migrate UserDB")
 	}
-
-	// load DB from file
-	if err = userDB.DeserializeFromFile(); err != nil {
-		err = errors.Wrap(err, "could not deserialize UserDB from file")
-		return
+	for _, change := range changes {
+		Info.Log("migrated " + change)
 	}
 
 	// create default super admin account if no users exist
@@ -155,6 +163,40 @@ func NewUserDB(dbDir string) (userDB *UserDB, err error) {
 	return
 }
 
+// openUserDB initialises the UserDB container and loads it from the configured UserStore, returning the schema version
+// the data was stored with. If readOnly is set, nothing on disk is created or modified, i.e. for a migration dry run.
+func openUserDB(dbDir string, readOnly bool) (userDB *UserDB, schemaVersion int, err error) {
+	// get session key, a read only UserDB never serves sessions so a missing key is not created
+	var key []byte
+	if readOnly {
+		key = securecookie.GenerateRandomKey(64)
+	} else if key, err = FetchSessionKey(); err != nil {
+		return nil, 0, errors.Wrap(err, "failed to fetch session key")
+	}
+
+	store, err := NewUserStore(dbDir, readOnly)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "could not open UserDB store")
+	}
+
+	userDB = &UserDB{
+		cookies: sessions.NewCookieStore(key),
+		dir:     dbDir,
+		store:   store,
+		Users:   UserMapMutex{Users: make(map[string]User), store: store},
+	}
+
+	// load DB from store
+	userDB.Users.mu.Lock()
+	schemaVersion, err = store.Load(userDB)
+	userDB.Users.mu.Unlock()
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "could not load UserDB from store")
+	}
+
+	return userDB, schemaVersion, nil
+}
+
 // CreateActivatedUser creates a new User and bypasses the email & admin verification.
 func (db *UserDB) CreateActivatedUser(accountType UserType) {
 	var forename, surname, email, password, retypePassword string
@@ -236,7 +278,7 @@ func (db *UserDB) CreateActivatedUser(accountType UserType) {
 			Critical.Logf("> Account creation failed: %s. Try again to create the account.\n\n", errors.Wrap(err, "could not set password"))
 			continue
 		}
-		db.SerializeToFile()
+		db.Checkpoint()
 		return
 	}
 }
@@ -297,7 +339,7 @@ func (db *UserDB) AddUser(forename string, surname string, email string, userTyp
 
 	// add user to DB
 	db.Users.Set(newUser.Username, newUser)
-	db.SerializeToFile()
+	db.Checkpoint()
 	Creation.Log("new user created: " + newUser.Username)
 	return
 }
@@ -358,7 +400,7 @@ func (db *UserDB) SetNewUserPassword(username string, password string) *ServerEr
 	user.AccountState = Registered
 
 	db.Users.Set(username, user)
-	db.SerializeToFile()
+	db.Checkpoint()
 	return nil
 }
 
@@ -404,10 +446,24 @@ func (db *UserDB) SetFavourite(username string, fileUUID string, state bool) (er
 
 	user.FavouriteFileUUIDs = favourites
 	db.Users.Set(username, user)
-	db.SerializeToFile()
+	db.Checkpoint()
 	return
 }
 
+// FavouriteCounts returns the number of users who have favourited each file UUID.
+func (db *UserDB) FavouriteCounts() map[string]int {
+	countFavourites := func(m UserMapDB) interface{} {
+		counts := make(map[string]int)
+		for _, user := range m {
+			for fileUUID := range user.FavouriteFileUUIDs {
+				counts[fileUUID]++
+			}
+		}
+		return counts
+	}
+	return db.Users.PerformFunc(countFavourites).(map[string]int)
+}
+
 // GetUsers returns a slice copy of all each User from the Users map.
 func (db *UserDB) GetUsers() []User {
 	getAllUsers := func(m UserMapDB) interface{} {
@@ -504,7 +560,7 @@ func (db *UserDB) LoginUser(w http.ResponseWriter, r *http.Request) (success boo
 	user.LoginTimestamp = time.Now().UnixNano()
 	user.LoginCount++
 	db.Users.Set(user.Username, user)
-	db.SerializeToFile()
+	db.Checkpoint()
 
 	// set user as authenticated
 	session.Values["authenticated"] = true
@@ -560,7 +616,7 @@ func (db *UserDB) SetTempPassword(email string) (tempPass string, err error) {
 	user.PasswordResetTimestamp = time.Now()
 
 	db.Users.Set(user.Username, user)
-	db.SerializeToFile()
+	db.Checkpoint()
 	return
 }
 
@@ -612,53 +668,17 @@ func FetchSessionKey() (key []byte, err error) {
 	return key, nil
 }
 
-// SerializeToFile serializes the entire UserDB to a file on disk via gob.
-func (db *UserDB) SerializeToFile() (err error) {
-	// create/truncate file for writing to
-	file, err := os.Create(db.file)
-	if err != nil {
-		Critical.Log(err)
-		return err
-	}
-	db.Users.mu.Lock()
-	defer db.Users.mu.Unlock()
-	defer file.Close()
-
-	// encode store map to file
-	err = gob.NewEncoder(file).Encode(&db)
-	if err != nil {
+// Checkpoint allows the UserStore to persist the changes made to the UserDB. This is called after each UserDB operation.
+func (db *UserDB) Checkpoint() {
+	if err := db.store.Checkpoint(db); err != nil {
 		Critical.Log(err)
-		return err
 	}
-
-	return nil
 }
 
-// DeserializeFromFile deserializes a file to the UserDB structure, overwriting current map values.
-func (db *UserDB) DeserializeFromFile() (err error) {
-	db.Users.mu.Lock()
-
-	// if db file does not exist, create a new one
-	if _, err := os.Stat(db.file); os.IsNotExist(err) {
-		db.Users.mu.Unlock()
-		db.SerializeToFile()
-		return nil
-	}
-	defer db.Users.mu.Unlock()
-
-	// open file to read from
-	file, err := os.Open(db.file)
-	if err != nil {
-		Critical.Log(err)
-		return err
-	}
-	defer file.Close()
-
-	// decode file contents to store map
-	if err = gob.NewDecoder(file).Decode(&db); err != nil {
-		Critical.Log(err)
+// Close performs a final checkpoint & closes the UserStore.
+func (db *UserDB) Close() error {
+	if err := db.store.Checkpoint(db); err != nil {
 		return err
 	}
-
-	return nil
+	return db.store.Close()
 }
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math"
 	"math/rand"
 	"os"
@@ -87,6 +88,12 @@ func ProcessInputList(list string, delimiter string, toLowerCase bool) (separate
 	return
 }
 
+// StartOfDay returns the start of the local day containing a unix epoch timestamp in seconds.
+func StartOfDay(epoch int64) time.Time {
+	t := time.Unix(epoch, 0)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
 // TrimUnixEpoch converts a unix epoch timestamp to YYYY-MM-DD format (trims anything smaller).
 func TrimUnixEpoch(epoch int64, nano bool) time.Time {
 	var nanoEpoch int64
@@ -141,7 +148,7 @@ func MoveFile(src, dst string) (err error) {
 
 	// delete src file
 	if err = os.Remove(src); err != nil {
-		errors.Wrap(err, "failed to remove file")
+		err = errors.Wrap(err, "failed to remove file")
 	}
 	return
 }
@@ -169,6 +176,122 @@ func CopyFile(src, dst string) error {
 	return nil
 }
 
+// WriteFileAtomic writes to a temp file in the same directory as path via the write func, syncs it to disk, then
+// renames it over path. This ensures path always contains either its previous or its new contents in full, even if the
+// process crashes or the disk fills up part way through writing. If backups is greater than zero, the previous
+// generations of path are kept as path.1 (newest) through to path.<backups> (oldest).
+func WriteFileAtomic(path string, backups int, write func(io.Writer) error) (err error) {
+	dir := filepath.Dir(path)
+	tempFile, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temp file")
+	}
+	// clean up temp file if it was not renamed into place
+	defer func() {
+		if err != nil {
+			tempFile.Close()
+			os.Remove(tempFile.Name())
+		}
+	}()
+
+	if err = write(tempFile); err != nil {
+		return errors.Wrap(err, "failed to write temp file")
+	}
+	if err = tempFile.Sync(); err != nil {
+		return errors.Wrap(err, "failed to sync temp file")
+	}
+	if err = tempFile.Close(); err != nil {
+		return errors.Wrap(err, "failed to close temp file")
+	}
+
+	if err = rotateBackups(path, backups); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tempFile.Name(), path); err != nil {
+		return errors.Wrap(err, "failed to rename temp file")
+	}
+
+	// sync the directory so that the rename itself is durable (not supported on all platforms)
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// rotateBackups shifts each backup generation of path along by one, discarding the oldest, then copies the current
+// contents of path to path.1.
+func rotateBackups(path string, backups int) error {
+	if backups <= 0 {
+		return nil
+	}
+
+	exists, err := FileOrDirExists(path)
+	if err != nil || !exists {
+		return err
+	}
+
+	for i := backups - 1; i > 0; i-- {
+		src := fmt.Sprintf("%s.%d", path, i)
+		if err := os.Rename(src, fmt.Sprintf("%s.%d", path, i+1)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "failed to rotate backup")
+		}
+	}
+
+	// hard link where possible as path is about to be replaced, otherwise fall back to a full copy
+	newest := path + ".1"
+	os.Remove(newest)
+	if err := os.Link(path, newest); err != nil {
+		if err = CopyFile(path, newest); err != nil {
+			return errors.Wrap(err, "failed to create backup")
+		}
+	}
+	return nil
+}
+
+// ReadFileWithBackups passes the contents of path to the read func. If path cannot be opened or read returns an error,
+// each backup generation created by WriteFileAtomic is tried in turn from newest to oldest. The path of the file which
+// was successfully read is returned.
+func ReadFileWithBackups(path string, backups int, read func(io.Reader) error) (string, error) {
+	var firstErr error
+	for i := 0; i <= backups; i++ {
+		target := path
+		if i > 0 {
+			target = fmt.Sprintf("%s.%d", path, i)
+		}
+
+		err := func() error {
+			file, err := os.Open(target)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			return read(file)
+		}()
+		if err == nil {
+			if i > 0 {
+				Critical.Logf("%v could not be read, recovered from backup %v", path, target)
+			}
+			return target, nil
+		}
+
+		if i == 0 {
+			firstErr = err
+		}
+		if os.IsNotExist(err) {
+			// there are no older backup generations
+			if i > 0 {
+				break
+			}
+			continue
+		}
+		Critical.Log(errors.Wrapf(err, "failed to read %v", target))
+	}
+
+	return "", errors.Wrapf(firstErr, "no readable copy of %v exists", path)
+}
+
 // NewUUID generates a new Universally Unique Identifier (UUID).
 func NewUUID() (UUID string) {
 	return uuid.NewV4().String()
@@ -0,0 +1,53 @@
+package memoryshare
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestReadFileWithBackups(t *testing.T) {
+	dir := newTestConfig(t, GobBackend)
+	path := dir + "/db/test.dat"
+
+	for _, contents := range []string{"first", "second", "third"} {
+		err := WriteFileAtomic(path, 2, func(w io.Writer) error {
+			_, err := io.WriteString(w, contents)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// reject the newest generation as if it were corrupt
+	var read []string
+	target, err := ReadFileWithBackups(path, 2, func(r io.Reader) error {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		read = append(read, string(data))
+		if string(data) == "third" {
+			return errors.New("corrupt")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != path+".1" || strings.Join(read, ",") != "third,second" {
+		t.Fatalf("expected to fall back to %v.1, got %v after reading %v", path, target, read)
+	}
+
+	// every generation is unreadable
+	_, err = ReadFileWithBackups(path, 2, func(r io.Reader) error {
+		return errors.New("corrupt")
+	})
+	if err == nil {
+		t.Fatal("expected an error when no generation can be read")
+	}
+}